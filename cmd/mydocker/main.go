@@ -13,6 +13,7 @@ import (
 	"weike.sh/mydocker/pkg/cmd/container"
 	"weike.sh/mydocker/pkg/cmd/image"
 	"weike.sh/mydocker/pkg/cmd/network"
+	"weike.sh/mydocker/pkg/cmd/system"
 	netpkg "weike.sh/mydocker/pkg/network"
 )
 
@@ -31,22 +32,42 @@ func main() {
 
 	app.Commands = []cli.Command{
 		container.Init,
+		container.Shim,
 		container.Run,
+		container.Create,
 		container.List,
+		container.Stats,
+		container.Top,
 		container.Logs,
 		container.Exec,
 		container.Stop,
+		container.Kill,
 		container.Start,
 		container.Restart,
 		container.Remove,
+		container.Pause,
+		container.Unpause,
+		container.Update,
+		container.Wait,
+		container.Commit,
+		container.Diff,
+		container.Copy,
+		container.Export,
+		container.Build,
 		network.RemoveNetworks,
 		image.RemoveImages,
 		image.Pull,
+		image.Load,
+		image.Save,
+		image.Import,
+		image.Tag,
+		image.History,
 		cmd.Inspect,
 		network.ListNetworks,
 		image.ListImages,
 		network.Command,
 		image.Command,
+		system.Command,
 	}
 
 	app.Flags = []cli.Flag{
@@ -0,0 +1,390 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/pkg/container"
+	"weike.sh/mydocker/pkg/image"
+	"weike.sh/mydocker/util"
+)
+
+type Builder struct {
+	ContextDir string
+	Dockerfile string
+	Tags       []string
+	BuildArgs  map[string]string
+	NoCache    bool
+	Stdout     io.Writer
+	// NewContainer creates the throwaway container to execute a step
+	// of build on the image, which is connected to the networks only
+	// if network is true, the envs are the values of ARGs.
+	NewContainer func(img string, commands, envs []string, network bool) (*container.Container, error)
+
+	// the image built by the previous steps, nil if it's scratch.
+	img      *image.Image
+	config   *image.ContainerConfig
+	contents []byte
+	// the values of ARGs declared before FROM and in the stage.
+	metaArgs map[string]string
+	args     map[string]string
+	// the build args consumed by ARGs.
+	usedArgs map[string]bool
+	ignores  []string
+}
+
+// Build executes the instructions of the dockerfile step by step, each
+// step creates an intermediate image, which is reused as the cache if
+// the same step is built on the same image again.
+func (b *Builder) Build() (*image.Image, error) {
+	for _, tag := range b.Tags {
+		if _, err := image.ParseReference(tag); err != nil {
+			return nil, err
+		}
+	}
+
+	instructions, err := ParseFile(b.Dockerfile)
+	if err != nil {
+		return nil, err
+	}
+	if err := b.loadIgnores(); err != nil {
+		return nil, err
+	}
+
+	b.args = map[string]string{}
+	b.usedArgs = map[string]bool{}
+	fromSeen := false
+
+	for idx, inst := range instructions {
+		fmt.Fprintf(b.Stdout, "Step %d/%d : %s\n", idx+1, len(instructions), inst)
+
+		switch {
+		case inst.Cmd == "FROM" && fromSeen:
+			err = fmt.Errorf("multi-stage builds aren't supported")
+		case inst.Cmd == "FROM":
+			fromSeen = true
+			err = b.from(inst)
+		case inst.Cmd == "ARG":
+			err = b.arg(inst, fromSeen)
+		case !fromSeen:
+			err = fmt.Errorf("no build stage in current context, FROM is required")
+		case inst.Cmd == "RUN":
+			err = b.run(inst)
+		case inst.Cmd == "COPY" || inst.Cmd == "ADD":
+			err = b.copy(inst)
+		default:
+			err = b.change(inst)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to build %s at line %d: %v", inst.Cmd, inst.Line, err)
+		}
+	}
+
+	var unused []string
+	for name := range b.BuildArgs {
+		if !b.usedArgs[name] {
+			unused = append(unused, name)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		fmt.Fprintf(b.Stdout, "[Warning] One or more build-args %v were not consumed\n", unused)
+	}
+
+	if b.img == nil {
+		return nil, fmt.Errorf("no image was generated, is the dockerfile only FROM scratch?")
+	}
+
+	fmt.Fprintf(b.Stdout, "Successfully built %s\n", b.img.Uuid)
+	for _, tag := range b.Tags {
+		if err := image.Tag(b.img.Uuid, tag); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(b.Stdout, "Successfully tagged %s\n", tag)
+	}
+
+	return b.img, nil
+}
+
+func (b *Builder) from(inst *Instruction) error {
+	fields := strings.Fields(b.expand(inst.Args))
+	// e.g. FROM --platform=linux/arm64 ubuntu:18.04
+	platform := ""
+	if len(fields) > 0 && strings.HasPrefix(fields[0], "--platform=") {
+		platform = strings.TrimPrefix(fields[0], "--platform=")
+		fields = fields[1:]
+	}
+	if len(fields) != 1 && !(len(fields) == 3 && strings.ToUpper(fields[1]) == "AS") {
+		return fmt.Errorf("FROM requires either one or three arguments")
+	}
+
+	// the ARGs before FROM are only visible to FROM, unless
+	// they are declared again without values in the stage.
+	b.metaArgs, b.args = b.args, map[string]string{}
+
+	if fields[0] == "scratch" {
+		b.img = nil
+		b.config = &image.ContainerConfig{}
+		b.contents = image.ScratchConfig()
+		return nil
+	}
+
+	ref, err := image.ParseReference(fields[0])
+	if err != nil {
+		return err
+	}
+
+	img, err := image.GetImageByNameOrUuid(fields[0])
+	if err != nil {
+		// the base image is pulled if it doesn't exist.
+		if err := image.Pull(fields[0], platform); err != nil {
+			return err
+		}
+		if img, err = image.GetImageByNameOrUuid(ref.RepoTag()); err != nil {
+			return err
+		}
+	}
+
+	if err := b.setImage(img); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.Stdout, " ---> %s\n", img.Uuid)
+	return nil
+}
+
+// arg declares the ARGs, e.g. `ARG name[=default] ...`, whose
+// values are overridden by the build args passed by the user.
+func (b *Builder) arg(inst *Instruction, inStage bool) error {
+	words, err := image.SplitWords(inst.Args)
+	if err != nil {
+		return err
+	}
+
+	for _, word := range words {
+		kv := strings.SplitN(word, "=", 2)
+		name := kv[0]
+		if name == "" {
+			return fmt.Errorf("invalid ARG %q", word)
+		}
+
+		value, ok := b.BuildArgs[name]
+		if ok {
+			b.usedArgs[name] = true
+		} else if len(kv) == 2 {
+			value, ok = b.expand(kv[1]), true
+		} else if inStage {
+			value, ok = b.metaArgs[name]
+		}
+
+		// the ARG without any value is undefined.
+		if ok {
+			b.args[name] = value
+		}
+	}
+
+	return nil
+}
+
+func (b *Builder) run(inst *Instruction) error {
+	if b.img == nil {
+		return fmt.Errorf("can't run commands in an empty image")
+	}
+
+	commands := image.ParseCommand(inst.Args)
+	envs := b.argEnvs()
+	key := b.cacheKey(inst.String(), envs...)
+	if b.useCache(key) {
+		return nil
+	}
+
+	c, err := b.NewContainer(b.img.Uuid, commands, envs, true)
+	if err != nil {
+		return err
+	}
+	// the entrypoint of image is ignored by RUN.
+	c.Commands = commands
+	c.User = b.config.User
+	fmt.Fprintf(b.Stdout, " ---> Running in %s\n", c.Uuid)
+
+	var layer *image.Layer
+	created := false
+	err = c.RunAndWait()
+	if err == nil {
+		layer, created, err = c.CommitLayer()
+	}
+	b.removeContainer(c)
+	fmt.Fprintf(b.Stdout, "Removing intermediate container %s\n", c.Uuid)
+	if err != nil {
+		return err
+	}
+
+	contents, err := image.NewConfig(b.contents, b.config, layer.DiffID,
+		strings.Join(commands, " "), "")
+	if err != nil {
+		return err
+	}
+	return b.commitImage(contents, key, layer, created)
+}
+
+// change executes the instructions which only change the image
+// config, i.e. ENV, WORKDIR, ENTRYPOINT, CMD, USER, LABEL, EXPOSE and
+// STOPSIGNAL.
+func (b *Builder) change(inst *Instruction) error {
+	change := inst.String()
+	if inst.Cmd != "CMD" && inst.Cmd != "ENTRYPOINT" {
+		change = inst.Cmd + " " + b.expand(inst.Args)
+	}
+
+	key := b.cacheKey(change)
+	if b.useCache(key) {
+		return nil
+	}
+
+	if err := image.ApplyChanges(b.config, []string{change}); err != nil {
+		return err
+	}
+
+	contents, err := image.NewConfig(b.contents, b.config, "", "/bin/sh -c #(nop) "+change, "")
+	if err != nil {
+		return err
+	}
+	return b.commitImage(contents, key, nil, false)
+}
+
+// commitImage creates the intermediate image of a step from the
+// config contents, the layer is released if it's failed and the
+// layer is created by the step, i.e. created is true.
+func (b *Builder) commitImage(contents []byte, key string, layer *image.Layer, created bool) error {
+	img, err := image.CreateImage(image.NoneRepoTag, contents)
+	if err != nil {
+		// the layer isn't referenced if it's created just now.
+		if layer != nil && created {
+			image.ReleaseLayers([]string{layer.ChainID})
+		}
+		return err
+	}
+
+	img.Parent = b.parentId()
+	img.CacheKey = key
+	if err := image.Dump(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(b.Stdout, " ---> %s\n", img.Uuid)
+	return b.setImage(img)
+}
+
+// useCache returns true if the step of key has been built
+// on the current image, which becomes the current image.
+func (b *Builder) useCache(key string) bool {
+	if b.NoCache || image.Load() != nil {
+		return false
+	}
+
+	parentId := b.parentId()
+	for _, img := range image.Images {
+		if img.Parent != parentId || img.CacheKey != key {
+			continue
+		}
+		if err := b.setImage(img); err != nil {
+			log.Warnf("failed to use the cache image %s: %v", img.Uuid, err)
+			return false
+		}
+		fmt.Fprintf(b.Stdout, " ---> Using cache\n ---> %s\n", img.Uuid)
+		return true
+	}
+
+	return false
+}
+
+func (b *Builder) setImage(img *image.Image) error {
+	config, contents, err := img.LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	b.img, b.config, b.contents = img, config.Config, contents
+	return nil
+}
+
+func (b *Builder) parentId() string {
+	if b.img == nil {
+		return ""
+	}
+	return b.img.Id
+}
+
+// cacheKey returns the hash of the step built on the current image.
+func (b *Builder) cacheKey(step string, extras ...string) string {
+	parts := append([]string{b.parentId(), step}, extras...)
+	return util.Sha256Sum(strings.Join(parts, "\n"))
+}
+
+func (b *Builder) removeContainer(c *container.Container) {
+	if err := c.Delete(); err != nil {
+		log.Warnf("failed to remove the intermediate container %s: %v", c.Uuid, err)
+	}
+}
+
+// argEnvs returns the envs of ARGs sorted by their names, the ARGs
+// are overridden by the ENVs with the same names, like docker.
+func (b *Builder) argEnvs() []string {
+	var envs []string
+	for name, value := range b.args {
+		if _, ok := b.lookupEnv(name); !ok {
+			envs = append(envs, name+"="+value)
+		}
+	}
+	sort.Strings(envs)
+	return envs
+}
+
+func (b *Builder) lookupEnv(name string) (string, bool) {
+	if b.config == nil {
+		return "", false
+	}
+	for idx := len(b.config.Env) - 1; idx >= 0; idx-- {
+		kv := strings.SplitN(b.config.Env[idx], "=", 2)
+		if kv[0] == name && len(kv) == 2 {
+			return kv[1], true
+		}
+	}
+	return "", false
+}
+
+// expand replaces the variables in s with the values of ENVs and
+// ARGs, e.g. $name, ${name}, ${name:-default} and ${name:+value}.
+func (b *Builder) expand(s string) string {
+	// the escaped `\$` is kept as `$`.
+	s = strings.Replace(s, `\$`, "\x00", -1)
+	s = os.Expand(s, func(name string) string {
+		op, word := byte(0), ""
+		if idx := strings.Index(name, ":"); idx > 0 && idx+1 < len(name) {
+			name, op, word = name[:idx], name[idx+1], name[idx+2:]
+		}
+
+		value, ok := b.lookupEnv(name)
+		if !ok {
+			value = b.args[name]
+		}
+
+		switch op {
+		case '-':
+			if value == "" {
+				return word
+			}
+		case '+':
+			if value != "" {
+				return word
+			}
+			return ""
+		}
+		return value
+	})
+	return strings.Replace(s, "\x00", "$", -1)
+}
@@ -0,0 +1,143 @@
+package build
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"weike.sh/mydocker/pkg/image"
+)
+
+func TestExpand(t *testing.T) {
+	b := &Builder{
+		config: &image.ContainerConfig{Env: []string{"A=env", "EMPTY="}},
+		args:   map[string]string{"A": "arg", "B": "2"},
+	}
+
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		// the ENVs override the ARGs with the same names.
+		{"$A", "env"},
+		{"${B}", "2"},
+		{"pre${B}post", "pre2post"},
+		{"$UNDEFINED", ""},
+		{"x${UNDEFINED}y", "xy"},
+		{"${UNDEFINED:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${B:-default}", "2"},
+		{"${B:+set}", "set"},
+		{"${UNDEFINED:+set}", ""},
+		{`\$A`, "$A"},
+		{`\${B}`, "${B}"},
+		{`$B\$B`, "2$B"},
+	}
+
+	for _, test := range tests {
+		if result := b.expand(test.s); result != test.expected {
+			t.Errorf("expected %q when expanding %q, got %q", test.expected, test.s, result)
+		}
+	}
+}
+
+func setupImagesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := image.SetStoreDir(dir)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		restore()
+	})
+}
+
+func TestCache(t *testing.T) {
+	setupImagesDir(t)
+
+	contextDir, err := ioutil.TempDir("", "mydocker-context-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(contextDir)
+	writeSource := func(contents string) {
+		if err := ioutil.WriteFile(path.Join(contextDir, "app.conf"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// build executes the steps from scratch, and returns the uuid
+	// of the image built and whether the cache is used by each step.
+	build := func(steps ...string) (string, []bool) {
+		stdout := &bytes.Buffer{}
+		b := &Builder{
+			ContextDir: contextDir,
+			Stdout:     stdout,
+			args:       map[string]string{},
+			usedArgs:   map[string]bool{},
+		}
+		if err := b.from(&Instruction{Cmd: "FROM", Args: "scratch"}); err != nil {
+			t.Fatal(err)
+		}
+
+		var cached []bool
+		for _, step := range steps {
+			inst, err := parseLine(step, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stdout.Reset()
+			if inst.Cmd == "COPY" {
+				err = b.copy(inst)
+			} else {
+				err = b.change(inst)
+			}
+			if err != nil {
+				t.Fatalf("failed to build %q: %v", step, err)
+			}
+			cached = append(cached, strings.Contains(stdout.String(), "Using cache"))
+		}
+		return b.img.Uuid, cached
+	}
+
+	// the COPY on scratch needn't a container to be executed.
+	writeSource("port=80")
+	first, cached := build("COPY app.conf /etc/", "ENV A=1")
+	if !reflect.DeepEqual(cached, []bool{false, false}) {
+		t.Errorf("the cache is used by the first build: %v", cached)
+	}
+
+	tests := []struct {
+		steps  []string
+		source string
+		cached []bool
+	}{
+		{steps: []string{"COPY app.conf /etc/", "ENV A=1"}, cached: []bool{true, true}},
+		// the instruction is changed.
+		{steps: []string{"COPY app.conf /etc/", "ENV A=2"}, cached: []bool{true, false}},
+		{steps: []string{"COPY app.conf /etc/app/", "ENV A=1"}, cached: []bool{false, false}},
+		// the source of COPY is changed.
+		{steps: []string{"COPY app.conf /etc/", "ENV A=1"}, source: "port=8080", cached: []bool{false, false}},
+		{steps: []string{"COPY app.conf /etc/", "ENV A=1"}, source: "port=80", cached: []bool{true, true}},
+	}
+
+	for _, test := range tests {
+		if test.source != "" {
+			writeSource(test.source)
+		}
+		uuid, cached := build(test.steps...)
+		if !reflect.DeepEqual(cached, test.cached) {
+			t.Errorf("expected the cache used by %q with source %q to be %v, got %v",
+				test.steps, test.source, test.cached, cached)
+		}
+		if hit := uuid == first; hit != test.cached[len(test.cached)-1] {
+			t.Errorf("unexpected image %s built by %q, the first one is %s", uuid, test.steps, first)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+package build
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"weike.sh/mydocker/pkg/image"
+	"weike.sh/mydocker/util"
+)
+
+const DockerIgnore = ".dockerignore"
+
+// source is a file or dir in the build context to be copied.
+type source struct {
+	path string
+	// the path relative to the build context.
+	rel  string
+	info os.FileInfo
+}
+
+// copy executes COPY and ADD, e.g. `COPY [--chown=user:group] src... dest`
+// ADD extracts the local tarballs, but doesn't support remote URLs.
+func (b *Builder) copy(inst *Instruction) error {
+	args := b.expand(inst.Args)
+	chown := ""
+	for strings.HasPrefix(args, "--") {
+		fields := strings.SplitN(args, " ", 2)
+		if !strings.HasPrefix(fields[0], "--chown=") || len(fields) < 2 {
+			return fmt.Errorf("unsupported flag %s", fields[0])
+		}
+		chown = strings.TrimPrefix(fields[0], "--chown=")
+		args = strings.TrimSpace(fields[1])
+	}
+
+	var paths []string
+	if err := json.Unmarshal([]byte(args), &paths); err != nil || !strings.HasPrefix(args, "[") {
+		paths = strings.Fields(args)
+	}
+	if len(paths) < 2 {
+		return fmt.Errorf("%s requires at least two arguments", inst.Cmd)
+	}
+
+	// the relative dest is relative to the WORKDIR.
+	dest := paths[len(paths)-1]
+	if !path.IsAbs(dest) {
+		isDir := strings.HasSuffix(dest, "/") || dest == "."
+		dest = path.Join("/", b.config.WorkingDir, dest)
+		if isDir && dest != "/" {
+			dest += "/"
+		}
+	}
+
+	sources, err := b.resolveSources(paths[:len(paths)-1])
+	if err != nil {
+		return err
+	}
+	if len(sources) > 1 && !strings.HasSuffix(dest, "/") {
+		return fmt.Errorf("the destination %s must be a directory and end with a / "+
+			"when copying more than one source file", dest)
+	}
+
+	hash, err := b.hashSources(sources)
+	if err != nil {
+		return err
+	}
+
+	step := fmt.Sprintf("%s %s in %s", inst.Cmd, hash, dest)
+	if chown != "" {
+		step = fmt.Sprintf("%s --chown=%s %s in %s", inst.Cmd, chown, hash, dest)
+	}
+	key := b.cacheKey(step)
+	if b.useCache(key) {
+		return nil
+	}
+
+	layer, created, err := b.createLayer(func(root string) error {
+		return b.copySources(root, sources, dest, chown, inst.Cmd == "ADD")
+	})
+	if err != nil {
+		return err
+	}
+
+	contents, err := image.NewConfig(b.contents, b.config, layer.DiffID, "/bin/sh -c #(nop) "+step, "")
+	if err != nil {
+		return err
+	}
+	return b.commitImage(contents, key, layer, created)
+}
+
+// createLayer creates a layer on the current image, whose changes are
+// made by fn in the rootfs of a throwaway container, or an empty dir
+// if the current image is scratch, and returns true if the layer
+// is created by it, see image.CreateLayer().
+func (b *Builder) createLayer(fn func(root string) error) (*image.Layer, bool, error) {
+	if b.img != nil {
+		c, err := b.NewContainer(b.img.Uuid, []string{"/bin/sh", "-c", "#(nop)"}, nil, false)
+		if err != nil {
+			return nil, false, err
+		}
+		defer b.removeContainer(c)
+
+		if err := c.Dump(); err != nil {
+			return nil, false, err
+		}
+		if err := c.WithRootfs(fn); err != nil {
+			return nil, false, err
+		}
+		return c.CommitLayer()
+	}
+
+	if err := os.MkdirAll(image.LayersDir, 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to mkdir %s: %v", image.LayersDir, err)
+	}
+	dir, err := ioutil.TempDir(image.LayersDir, "build-")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp dir for build: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := fn(dir); err != nil {
+		return nil, false, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(image.PackLayer(dir, writer, image.WhiteoutFormat()))
+	}()
+
+	layer, created, err := image.CreateLayer("", "", reader)
+	reader.CloseWithError(err)
+	return layer, created, err
+}
+
+// resolveSources resolves the sources in the build context, which
+// maybe contain wildcards, and the ignored files are skipped. The
+// sources themselves maybe symlinks, but their parent dirs must not
+// be resolved outside the build context.
+func (b *Builder) resolveSources(srcs []string) ([]*source, error) {
+	contextDir, err := filepath.EvalSymlinks(b.ContextDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the build context: %v", err)
+	}
+
+	var sources []*source
+	for _, src := range srcs {
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			return nil, fmt.Errorf("the remote URL %s isn't supported", src)
+		}
+
+		pattern := filepath.Join(b.ContextDir, src)
+		if rel, err := filepath.Rel(b.ContextDir, pattern); err != nil || strings.HasPrefix(rel, "..") {
+			return nil, fmt.Errorf("forbidden path outside the build context: %s", src)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %s: %v", src, err)
+		}
+
+		found := false
+		for _, match := range matches {
+			rel, _ := filepath.Rel(b.ContextDir, match)
+			if rel != "." && b.isIgnored(rel) {
+				continue
+			}
+			if rel != "." && !isInDir(contextDir, filepath.Dir(match)) {
+				return nil, fmt.Errorf("forbidden path outside the build context: %s", src)
+			}
+			info, err := os.Lstat(match)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, &source{path: match, rel: rel, info: info})
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("%s: no such file or directory in the build context", src)
+		}
+	}
+
+	return sources, nil
+}
+
+// isInDir returns true if the file is in the dir after its symlinks
+// are resolved, the dir itself must have been resolved.
+func isInDir(dir, file string) bool {
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, resolved)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// walkSource walks the files of the source which aren't ignored, the
+// name is relative to the source, i.e. "." is the source itself.
+func (b *Builder) walkSource(src *source, fn func(file, name string, fi os.FileInfo) error) error {
+	return filepath.Walk(src.path, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(src.path, file)
+		if err != nil {
+			return err
+		}
+		// the sockets can't be archived, just like docker.
+		if fi.Mode()&os.ModeSocket != 0 {
+			return nil
+		}
+		if name != "." && b.isIgnored(filepath.Join(src.rel, name)) {
+			if fi.IsDir() && !b.hasExceptions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		return fn(file, name, fi)
+	})
+}
+
+// hashSources returns the checksum of the sources' contents, which
+// is a part of the cache key, e.g. file:<hash> or dir:<hash>.
+func (b *Builder) hashSources(sources []*source) (string, error) {
+	hash := sha256.New()
+	for _, src := range sources {
+		err := b.walkSource(src, func(file, name string, fi os.FileInfo) error {
+			link := ""
+			if fi.Mode()&os.ModeSymlink != 0 {
+				link, _ = os.Readlink(file)
+			}
+			fmt.Fprintf(hash, "%s\x00%s\x00%o\x00%s\x00", src.rel, name, fi.Mode(), link)
+
+			if fi.Mode().IsRegular() {
+				f, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				if _, err := io.Copy(hash, f); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to checksum %s: %v", src.rel, err)
+		}
+	}
+
+	kind := "multi"
+	if len(sources) == 1 && sources[0].info.IsDir() {
+		kind = "dir"
+	} else if len(sources) == 1 {
+		kind = "file"
+	}
+	return fmt.Sprintf("%s:%x", kind, hash.Sum(nil)), nil
+}
+
+// copySources copies the sources to dest in root like docker, the
+// contents of a dir are copied rather than the dir itself, and the
+// files are owned by root unless chown is specified.
+func (b *Builder) copySources(root string, sources []*source, dest, chown string, isAdd bool) error {
+	owner := &util.User{}
+	if chown != "" {
+		var err error
+		if owner, err = util.LookupUser(root, chown); err != nil {
+			return err
+		}
+	}
+
+	for _, src := range sources {
+		switch {
+		case isAdd && src.info.Mode().IsRegular() && isArchive(src.path):
+			if err := extractArchive(root, src.path, dest); err != nil {
+				return err
+			}
+
+		case src.info.IsDir():
+			err := b.walkSource(src, func(file, name string, fi os.FileInfo) error {
+				// the existing dest dir is kept as it is.
+				if name == "." && isDir(root, dest) {
+					return nil
+				}
+				return copyFile(root, file, path.Join(dest, name), fi, owner)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %v", src.rel, err)
+			}
+
+		default:
+			target := dest
+			if strings.HasSuffix(dest, "/") || isDir(root, dest) {
+				target = path.Join(dest, path.Base(src.path))
+			}
+			if err := copyFile(root, src.path, target, src.info, owner); err != nil {
+				return fmt.Errorf("failed to copy %s: %v", src.rel, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// copyFile copies the file to target in root, the symlinks in
+// the parent dirs of target are resolved in the scope of root.
+func copyFile(root, file, target string, fi os.FileInfo, owner *util.User) error {
+	parent, err := util.SecureJoin(root, path.Dir(path.Clean(target)))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir %s: %v", parent, err)
+	}
+
+	link := ""
+	if fi.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(file); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Uid, hdr.Gid = owner.Uid, owner.Gid
+
+	var r io.Reader
+	if fi.Mode().IsRegular() {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	return util.ExtractEntry(root, path.Join(parent, path.Base(target)), hdr, r)
+}
+
+func extractArchive(root, file, dest string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := image.Decompress(f)
+	if err != nil {
+		return err
+	}
+	return util.Untar(r, root, dest)
+}
+
+// isArchive returns true if the file is a tarball, which maybe gzipped.
+func isArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	r, err := image.Decompress(f)
+	if err != nil {
+		return false
+	}
+	_, err = tar.NewReader(r).Next()
+	return err == nil
+}
+
+func isDir(root, name string) bool {
+	file, err := util.SecureJoin(root, name)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(file)
+	return err == nil && fi.IsDir()
+}
+
+// loadIgnores loads the patterns of .dockerignore in the build context.
+func (b *Builder) loadIgnores() error {
+	file, err := os.Open(filepath.Join(b.ContextDir, DockerIgnore))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open %s: %v", DockerIgnore, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		exception := strings.HasPrefix(pattern, "!")
+		pattern = filepath.Clean(strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "/"))
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s in %s: %v", pattern, DockerIgnore, err)
+		}
+		if exception {
+			pattern = "!" + pattern
+		}
+		b.ignores = append(b.ignores, pattern)
+	}
+
+	return scanner.Err()
+}
+
+// isIgnored returns true if the file or any of its parent dirs is
+// matched by the patterns, the last matched pattern wins.
+func (b *Builder) isIgnored(rel string) bool {
+	ignored := false
+	for _, pattern := range b.ignores {
+		exception := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		for name := rel; name != "." && name != "/"; name = filepath.Dir(name) {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				ignored = !exception
+				break
+			}
+		}
+	}
+	return ignored
+}
+
+func (b *Builder) hasExceptions() bool {
+	for _, pattern := range b.ignores {
+		if strings.HasPrefix(pattern, "!") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,69 @@
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestResolveSources(t *testing.T) {
+	contextDir, err := ioutil.TempDir("", "mydocker-context-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(contextDir)
+	outside, err := ioutil.TempDir("", "mydocker-outside-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	for _, file := range []string{path.Join(outside, "secret"), path.Join(contextDir, "app", "app.conf")} {
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"escape":      outside,
+		"abs-escape":  "/",
+		"inside":      "app",
+		"secret-link": path.Join(outside, "secret"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, path.Join(contextDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		src string
+		err string
+	}{
+		{src: "app/app.conf"},
+		{src: "inside/app.conf"},
+		{src: "."},
+		// the symlink itself is copied, rather than its target.
+		{src: "secret-link"},
+		{src: "escape"},
+		{src: "../secret", err: "forbidden path"},
+		{src: "escape/secret", err: "forbidden path"},
+		{src: "escape/*", err: "forbidden path"},
+		{src: "abs-escape/etc/passwd", err: "forbidden path"},
+		{src: "inside/missing", err: "no such file or directory"},
+	}
+
+	b := &Builder{ContextDir: contextDir}
+	for _, test := range tests {
+		_, err := b.resolveSources([]string{test.src})
+		if test.err == "" && err != nil {
+			t.Errorf("failed to resolve %s: %v", test.src, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("expected error %q when resolving %s, got %v", test.err, test.src, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package build
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// the instructions of dockerfile supported by build.
+var Instructions = []string{
+	"FROM", "RUN", "COPY", "ADD", "ENV", "WORKDIR",
+	"ENTRYPOINT", "CMD", "USER", "LABEL", "EXPOSE", "STOPSIGNAL", "ARG",
+}
+
+type Instruction struct {
+	// the line number of the instruction in dockerfile.
+	Line int
+	// the upper-case instruction, e.g. RUN
+	Cmd  string
+	Args string
+}
+
+func (inst *Instruction) String() string {
+	return inst.Cmd + " " + inst.Args
+}
+
+// ParseFile parses the dockerfile named fileName.
+func ParseFile(fileName string) ([]*Instruction, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dockerfile %s: %v", fileName, err)
+	}
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Parse parses the dockerfile read from r into instructions, the
+// comments are skipped and the lines ending with `\` are joined.
+func Parse(r io.Reader) ([]*Instruction, error) {
+	var instructions []*Instruction
+	var lines []string
+	startLine := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		// the comments and empty lines are allowed between
+		// the continuation lines, which are skipped as well.
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+
+		if len(lines) == 0 {
+			startLine = lineNum
+		}
+		if strings.HasSuffix(line, "\\") {
+			lines = append(lines, strings.TrimSuffix(line, "\\"))
+			continue
+		}
+		lines = append(lines, line)
+
+		inst, err := parseLine(strings.Join(lines, " "), startLine)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		lines = nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dockerfile: %v", err)
+	}
+	if len(lines) > 0 {
+		return nil, fmt.Errorf("unexpected end of dockerfile after line %d", startLine)
+	}
+
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("the dockerfile is empty")
+	}
+	return instructions, nil
+}
+
+func parseLine(line string, lineNum int) (*Instruction, error) {
+	line = strings.TrimSpace(line)
+	fields := []string{line}
+	if idx := strings.IndexFunc(line, unicode.IsSpace); idx > 0 {
+		fields = []string{line[:idx], line[idx+1:]}
+	}
+
+	inst := &Instruction{
+		Line: lineNum,
+		Cmd:  strings.ToUpper(fields[0]),
+	}
+	if len(fields) == 2 {
+		inst.Args = strings.TrimSpace(fields[1])
+	}
+
+	supported := false
+	for _, cmd := range Instructions {
+		if cmd == inst.Cmd {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("unsupported instruction %s at line %d", fields[0], lineNum)
+	}
+
+	if inst.Args == "" {
+		return nil, fmt.Errorf("%s requires at least one argument at line %d", inst.Cmd, lineNum)
+	}
+	return inst, nil
+}
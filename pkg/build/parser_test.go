@@ -0,0 +1,93 @@
+package build
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"weike.sh/mydocker/pkg/image"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		dockerfile   string
+		instructions []*Instruction
+		err          string
+	}{
+		{
+			dockerfile: "# syntax\nfrom ubuntu:18.04\n\nRUN echo hello",
+			instructions: []*Instruction{
+				{Line: 2, Cmd: "FROM", Args: "ubuntu:18.04"},
+				{Line: 4, Cmd: "RUN", Args: "echo hello"},
+			},
+		},
+		{
+			// the comments and empty lines between continuation lines are skipped.
+			dockerfile: "FROM alpine\nRUN apk update && \\\n  # install curl\n\n  apk add curl\nCMD sh",
+			instructions: []*Instruction{
+				{Line: 1, Cmd: "FROM", Args: "alpine"},
+				{Line: 2, Cmd: "RUN", Args: "apk update &&  apk add curl"},
+				{Line: 6, Cmd: "CMD", Args: "sh"},
+			},
+		},
+		{
+			dockerfile: "FROM alpine\nENV A=1 \\\n    B=2",
+			instructions: []*Instruction{
+				{Line: 1, Cmd: "FROM", Args: "alpine"},
+				{Line: 2, Cmd: "ENV", Args: "A=1  B=2"},
+			},
+		},
+		{
+			dockerfile: "FROM alpine\nCMD [\"nginx\", \"-g\", \"daemon off;\"]",
+			instructions: []*Instruction{
+				{Line: 1, Cmd: "FROM", Args: "alpine"},
+				{Line: 2, Cmd: "CMD", Args: `["nginx", "-g", "daemon off;"]`},
+			},
+		},
+		{dockerfile: "FROM alpine\nVOLUME /data", err: "unsupported instruction VOLUME at line 2"},
+		{dockerfile: "FROM alpine\nRUN", err: "RUN requires at least one argument at line 2"},
+		{dockerfile: "FROM alpine\nRUN make \\", err: "unexpected end of dockerfile after line 2"},
+		{dockerfile: "# only comments\n\n", err: "the dockerfile is empty"},
+	}
+
+	for _, test := range tests {
+		instructions, err := Parse(strings.NewReader(test.dockerfile))
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q when parsing %q, got %v", test.err, test.dockerfile, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.dockerfile, err)
+			continue
+		}
+		if !reflect.DeepEqual(instructions, test.instructions) {
+			t.Errorf("unexpected instructions of %q: %+v", test.dockerfile, instructions)
+		}
+	}
+}
+
+func TestParseCommandForm(t *testing.T) {
+	tests := []struct {
+		line     string
+		commands []string
+	}{
+		{`RUN ["echo", "hello world"]`, []string{"echo", "hello world"}},
+		{`RUN echo "hello world"`, []string{"/bin/sh", "-c", `echo "hello world"`}},
+		// the invalid json is taken as the shell form.
+		{`CMD [echo, hello]`, []string{"/bin/sh", "-c", "[echo, hello]"}},
+	}
+
+	for _, test := range tests {
+		instructions, err := Parse(strings.NewReader(test.line))
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.line, err)
+			continue
+		}
+		commands := image.ParseCommand(instructions[0].Args)
+		if !reflect.DeepEqual(commands, test.commands) {
+			t.Errorf("unexpected commands of %q: %q", test.line, commands)
+		}
+	}
+}
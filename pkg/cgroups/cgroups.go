@@ -2,8 +2,13 @@ package cgroups
 
 import (
 	"fmt"
-	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
 	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Cgroups struct {
@@ -59,3 +64,38 @@ func (cg *Cgroups) Destory() error {
 
 	return nil
 }
+
+// Children returns the names of the child cgroups of parent in all the
+// mounted subsystems, e.g. the uuids of containers under /mydocker.
+func Children(parent string) ([]string, error) {
+	seen := map[string]bool{}
+	var children []string
+	for _, subsystem := range Subsystems {
+		if !subsystemIsMounted(subsystem.RootName()) {
+			continue
+		}
+		rootMntPoint, err := getSubsystemMountPoint(subsystem.RootName())
+		if err != nil {
+			continue
+		}
+
+		parentPath := path.Join(rootMntPoint, parent)
+		dirs, err := ioutil.ReadDir(parentPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dir %s: %v", parentPath, err)
+		}
+
+		for _, dir := range dirs {
+			if dir.IsDir() && !seen[dir.Name()] {
+				seen[dir.Name()] = true
+				children = append(children, dir.Name())
+			}
+		}
+	}
+
+	sort.Strings(children)
+	return children, nil
+}
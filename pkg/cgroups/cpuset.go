@@ -6,6 +6,7 @@ import (
 	"path"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -87,16 +88,23 @@ func setParentCpuset(currentDir string) error {
 
 // get value from /sys/fs/cgroup/cpuset/cpuset.mems
 // or use the command: `numactl --hardware`
-// this function ignores errors on purpose.
+// this function ignores errors on purpose, and returns
+// 1 if the cpuset subsystem isn't mounted.
 func getMemNodesNum() int {
-	cpusetRoot, _ := getSubsystemMountPoint(cpuset)
+	cpusetRoot, err := getSubsystemMountPoint(cpuset)
+	if err != nil {
+		return 1
+	}
 	confFile := path.Join(cpusetRoot, cpusetMems)
 
 	valueBytes, _ := ioutil.ReadFile(confFile)
-	value := string(valueBytes[:len(valueBytes)-1])
+	value := strings.TrimSpace(string(valueBytes))
 
 	re, _ := regexp.Compile(`^[\d,-]*(\d+)$`)
 	results := re.FindStringSubmatch(value)
+	if results == nil {
+		return 1
+	}
 
 	memNodesNum, _ := strconv.Atoi(results[1])
 	return memNodesNum + 1
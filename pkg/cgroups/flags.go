@@ -43,10 +43,11 @@ var Flags = []cli.Flag{
 		Usage: "CPUs in which to allow execution (0-3, 0,1)",
 		Value: fmt.Sprintf("0-%d", runtime.NumCPU()-1),
 	},
+	// the defaults of cpuset-mems and memory-swappiness are read
+	// from the host by NewResources, rather than when initializing.
 	cli.StringFlag{
 		Name:  "cpuset-mems",
-		Usage: "MEMs in which to allow execution (0-3, 0,1)",
-		Value: fmt.Sprintf("0-%d", getMemNodesNum()-1),
+		Usage: "MEMs in which to allow execution (0-3, 0,1) (default: all)",
 	},
 	cli.Int64Flag{
 		Name:  "memory-limit",
@@ -65,8 +66,7 @@ var Flags = []cli.Flag{
 	},
 	cli.Uint64Flag{
 		Name:  "memory-swappiness",
-		Usage: "Tune container memory swappiness (range [0, 100])",
-		Value: getDefaultSwappiness(),
+		Usage: "Tune container memory swappiness (range [0, 100]) (default: the host's)",
 	},
 	cli.BoolFlag{
 		Name:  "oom-kill-disable",
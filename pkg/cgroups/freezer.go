@@ -1,8 +1,11 @@
 package cgroups
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"weike.sh/mydocker/util"
@@ -13,7 +16,17 @@ const (
 	freezerState = "freezer.state"
 )
 
-var freezerStates = []string{"FROZEN", "THAWED"}
+// the states written into the freezer, while it's read as
+// FREEZING before all the tasks in the cgroup are frozen.
+const (
+	Frozen = "FROZEN"
+	Thawed = "THAWED"
+)
+
+// freezeTimeout is how long to wait for the freezer state to settle.
+const freezeTimeout = 10 * time.Second
+
+var freezerStates = []string{Frozen, Thawed}
 
 type FreezerSubsystem struct{}
 
@@ -50,3 +63,49 @@ func (_ *FreezerSubsystem) Set(cgPath string, r *Resources) error {
 
 	return nil
 }
+
+// Freeze writes the state FROZEN or THAWED into the freezer of the
+// cgroup, and waits until all the tasks in the cgroup are frozen or
+// thawed, the state is written again while it's FREEZING, since the
+// tasks forked during freezing maybe left running by the kernel.
+func (cg *Cgroups) Freeze(state string) error {
+	if !util.Contains(freezerStates, state) {
+		return fmt.Errorf("invalid freezer state: %s", state)
+	}
+	if !subsystemIsMounted(freezer) {
+		return fmt.Errorf("subsystem %s is not mounted", freezer)
+	}
+
+	freezerPath, err := getSubsystemPath(freezer, cg.Path)
+	if err != nil {
+		return err
+	}
+	confFile := path.Join(freezerPath, freezerState)
+
+	deadline := time.Now().Add(freezeTimeout)
+	for {
+		log.Debugf("set %s => %s", freezerState, state)
+		if err := ioutil.WriteFile(confFile, []byte(state), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", confFile, err)
+		}
+
+		contents, err := ioutil.ReadFile(confFile)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", confFile, err)
+		}
+		current := strings.TrimSpace(string(contents))
+		if current == state {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			// don't leave the cgroup half frozen.
+			if state == Frozen {
+				ioutil.WriteFile(confFile, []byte(Thawed), 0644)
+			}
+			return fmt.Errorf("timeout waiting for the freezer of cgroup %s "+
+				"to be %s, it's %s", cg.Path, state, current)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
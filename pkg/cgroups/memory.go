@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"path"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	"weike.sh/mydocker/util"
@@ -65,6 +66,22 @@ func (_ *MemorySubsystem) Set(cgPath string, r *Resources) error {
 		r.MemorySwapLimit = -2
 	}
 
+	// the memory limit can't exceed the memsw limit, so the memsw limit is
+	// written firstly if the memory limit is raised above the current
+	// memsw limit, e.g. by `update`, otherwise it's written later, since
+	// it can't be lowered below the current memory limit, like runc.
+	swapFirst := false
+	if r.MemorySwapLimit >= -1 {
+		if current, err := readUint(memswLimitFile); err == nil {
+			swapFirst = r.MemoryLimit == -1 || r.MemoryLimit > 0 && uint64(r.MemoryLimit) > current
+		}
+	}
+	if swapFirst {
+		if err := setMemorySwapLimit(memoryPath, r); err != nil {
+			return err
+		}
+	}
+
 	// TODO: compare current usages and values to be set.
 	if r.MemoryLimit >= -1 {
 		confFile := path.Join(memoryPath, memoryLimit)
@@ -75,19 +92,16 @@ func (_ *MemorySubsystem) Set(cgPath string, r *Resources) error {
 		}
 	}
 
-	if r.MemorySoftLimit >= -1 {
-		confFile := path.Join(memoryPath, memorySoftLimit)
-		confValue := []byte(fmt.Sprintf("%d", r.MemorySoftLimit))
-		log.Debugf("set %s => %s", memorySoftLimit, confValue)
-		if err := ioutil.WriteFile(confFile, confValue, 0644); err != nil {
+	if !swapFirst {
+		if err := setMemorySwapLimit(memoryPath, r); err != nil {
 			return err
 		}
 	}
 
-	if r.MemorySwapLimit >= -1 {
-		confFile := path.Join(memoryPath, memorySwapLimit)
-		confValue := []byte(fmt.Sprintf("%d", r.MemorySwapLimit))
-		log.Debugf("set %s => %s", memorySwapLimit, confValue)
+	if r.MemorySoftLimit >= -1 {
+		confFile := path.Join(memoryPath, memorySoftLimit)
+		confValue := []byte(fmt.Sprintf("%d", r.MemorySoftLimit))
+		log.Debugf("set %s => %s", memorySoftLimit, confValue)
 		if err := ioutil.WriteFile(confFile, confValue, 0644); err != nil {
 			return err
 		}
@@ -131,9 +145,55 @@ func (_ *MemorySubsystem) Set(cgPath string, r *Resources) error {
 	return nil
 }
 
+func setMemorySwapLimit(memoryPath string, r *Resources) error {
+	if r.MemorySwapLimit < -1 {
+		return nil
+	}
+	confFile := path.Join(memoryPath, memorySwapLimit)
+	confValue := []byte(fmt.Sprintf("%d", r.MemorySwapLimit))
+	log.Debugf("set %s => %s", memorySwapLimit, confValue)
+	return ioutil.WriteFile(confFile, confValue, 0644)
+}
+
+// OOMKilled returns true if any process in the cgroup has been killed
+// by the oom killer, it must be called before destroying the cgroup.
+func (cg *Cgroups) OOMKilled() (bool, error) {
+	if !subsystemIsMounted(memory) {
+		return false, nil
+	}
+	rootMntPoint, err := getSubsystemMountPoint(memory)
+	if err != nil {
+		return false, err
+	}
+
+	// e.g. oom_kill_disable 0\nunder_oom 0\noom_kill 1
+	confFile := path.Join(rootMntPoint, cg.Path, memoryOomControl)
+	contents, err := ioutil.ReadFile(confFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %v", confFile, err)
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		// oom_kill is only provided by kernel 4.13+, while
+		// under_oom is set if the oom killer is disabled.
+		switch fields[0] {
+		case "oom_kill", "under_oom":
+			if fields[1] != "0" {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
 // this function ignores errors on purpose.
 func getDefaultSwappiness() uint64 {
 	valueBytes, _ := ioutil.ReadFile("/proc/sys/vm/swappiness")
-	value, _ := strconv.Atoi(string(valueBytes[:len(valueBytes)-1]))
+	value, _ := strconv.Atoi(strings.TrimSpace(string(valueBytes)))
 	return uint64(value)
 }
@@ -86,6 +86,9 @@ func parseCpusetFlags(ctx *cli.Context, r *Resources) error {
 	r.CpusetCpus = cpusetCpus
 
 	cpusetMems := ctx.String("cpuset-mems")
+	if cpusetMems == "" {
+		cpusetMems = fmt.Sprintf("0-%d", numMem-1)
+	}
 	if err := validateCpusetArgs(cpusetMems, "mem", numMem); err != nil {
 		return err
 	}
@@ -160,6 +163,9 @@ func parseMemoryFlags(ctx *cli.Context, r *Resources) error {
 	r.MemorySwapLimit = memorySwapLimit
 
 	memorySwappiness := ctx.Uint64("memory-swappiness")
+	if !ctx.IsSet("memory-swappiness") {
+		memorySwappiness = getDefaultSwappiness()
+	}
 	if memorySwappiness > 100 {
 		memorySwappiness = 100
 	}
@@ -36,13 +36,16 @@ func (_ *PidsSubsystem) Set(cgPath string, r *Resources) error {
 		return err
 	}
 
+	// 0 indicates unlimited, which is written as "max", so that
+	// the limit can be removed from a running container by update.
+	confFile := path.Join(pidsPath, pidsMax)
+	confValue := []byte("max")
 	if r.PidsMax != 0 {
-		confFile := path.Join(pidsPath, pidsMax)
-		confValue := []byte(fmt.Sprintf("%d", r.PidsMax))
-		log.Debugf("set %s => %s", pidsMax, confValue)
-		if err := ioutil.WriteFile(confFile, confValue, 0644); err != nil {
-			return err
-		}
+		confValue = []byte(fmt.Sprintf("%d", r.PidsMax))
+	}
+	log.Debugf("set %s => %s", pidsMax, confValue)
+	if err := ioutil.WriteFile(confFile, confValue, 0644); err != nil {
+		return err
 	}
 
 	return nil
@@ -0,0 +1,46 @@
+package cgroups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Pids returns the pids of all the processes in the cgroup, which are
+// read from the first mounted subsystem, since the processes of the
+// container are applied to all the subsystems.
+func (cg *Cgroups) Pids() ([]int, error) {
+	for _, subsystem := range Subsystems {
+		if !subsystemIsMounted(subsystem.RootName()) {
+			continue
+		}
+		rootMntPoint, err := getSubsystemMountPoint(subsystem.RootName())
+		if err != nil {
+			continue
+		}
+
+		procsFile := path.Join(rootMntPoint, cg.Path, cgroupProcs)
+		contents, err := ioutil.ReadFile(procsFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", procsFile, err)
+		}
+
+		var pids []int
+		for _, field := range strings.Fields(string(contents)) {
+			pid, err := strconv.Atoi(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pid %q in %s", field, procsFile)
+			}
+			pids = append(pids, pid)
+		}
+		return pids, nil
+	}
+
+	return nil, fmt.Errorf("no cgroup %s is found in the mounted subsystems", cg.Path)
+}
@@ -0,0 +1,189 @@
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	cpuacctUsage        = "cpuacct.usage"
+	memoryUsage         = "memory.usage_in_bytes"
+	memoryStat          = "memory.stat"
+	pidsCurrent         = "pids.current"
+	blkioIOServiceBytes = "blkio.throttle.io_service_bytes"
+	memInfoFile         = "/proc/meminfo"
+)
+
+// Stats is the resource usages read back from the files of subsystems,
+// the usages of the subsystems which aren't mounted are left as 0.
+type Stats struct {
+	// the total cpu time consumed by the cgroup in nanoseconds.
+	CpuUsage uint64 `json:"CpuUsage"`
+	// the memory usage excluding the inactive file cache, like docker,
+	// and the limit, which is the memory of the host if it's unlimited.
+	MemoryUsage uint64 `json:"MemoryUsage"`
+	MemoryLimit uint64 `json:"MemoryLimit"`
+	PidsCurrent uint64 `json:"PidsCurrent"`
+	// the bytes read from and written to all the block devices.
+	BlkioRead  uint64 `json:"BlkioRead"`
+	BlkioWrite uint64 `json:"BlkioWrite"`
+}
+
+// Stats reads the usages of cpu, memory, pids and blkio of the cgroup.
+func (cg *Cgroups) Stats() (*Stats, error) {
+	stats := &Stats{}
+
+	if dir, ok := cg.statsPath(cpu); ok {
+		usage, err := readUint(path.Join(dir, cpuacctUsage))
+		if err != nil {
+			return nil, err
+		}
+		stats.CpuUsage = usage
+	}
+
+	if dir, ok := cg.statsPath(memory); ok {
+		if err := readMemoryStats(dir, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir, ok := cg.statsPath(pids); ok {
+		current, err := readUint(path.Join(dir, pidsCurrent))
+		if err != nil {
+			return nil, err
+		}
+		stats.PidsCurrent = current
+	}
+
+	if dir, ok := cg.statsPath(blkio); ok {
+		if err := readBlkioStats(dir, stats); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// statsPath returns the path of the cgroup in the subsystem, it's not
+// created like getSubsystemPath(), since the cgroup maybe removed.
+func (cg *Cgroups) statsPath(subsystemRootName string) (string, bool) {
+	if !subsystemIsMounted(subsystemRootName) {
+		return "", false
+	}
+	rootMntPoint, err := getSubsystemMountPoint(subsystemRootName)
+	if err != nil {
+		return "", false
+	}
+	return path.Join(rootMntPoint, cg.Path), true
+}
+
+func readMemoryStats(dir string, stats *Stats) error {
+	usage, err := readUint(path.Join(dir, memoryUsage))
+	if err != nil {
+		return err
+	}
+
+	limit, err := readUint(path.Join(dir, memoryLimit))
+	if err != nil {
+		return err
+	}
+	if total, err := hostMemory(); err == nil && (limit == 0 || limit > total) {
+		limit = total
+	}
+	stats.MemoryLimit = limit
+
+	// e.g. cache 1234\nrss 5678\n...\ntotal_inactive_file 1024
+	contents, err := ioutil.ReadFile(path.Join(dir, memoryStat))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", memoryStat, err)
+	}
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "total_inactive_file" {
+			continue
+		}
+		if inactive, err := strconv.ParseUint(fields[1], 10, 64); err == nil && inactive < usage {
+			usage -= inactive
+		}
+	}
+	stats.MemoryUsage = usage
+
+	return nil
+}
+
+func readBlkioStats(dir string, stats *Stats) error {
+	// e.g. 8:0 Read 1234\n8:0 Write 5678\n...\nTotal 6912
+	contents, err := ioutil.ReadFile(path.Join(dir, blkioIOServiceBytes))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", blkioIOServiceBytes, err)
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+
+	return nil
+}
+
+// hostMemory returns the total memory of the host in bytes.
+func hostMemory() (uint64, error) {
+	file, err := os.Open(memInfoFile)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// e.g. MemTotal:       16318412 kB
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			total, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return total * 1024, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("MemTotal isn't found in %s", memInfoFile)
+}
+
+func readUint(fileName string) (uint64, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %v", fileName, err)
+	}
+
+	value := strings.TrimSpace(string(contents))
+	// e.g. pids.max is "max" if it's unlimited.
+	if value == "max" {
+		return 0, nil
+	}
+	result, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s: %v", value, fileName, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,226 @@
+package cgroups
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli"
+)
+
+// UpdateFlags are the flags of `mydocker update`, i.e. the resources
+// which can be changed while the container is running, they have no
+// defaults since only the given ones are changed.
+var UpdateFlags = []cli.Flag{
+	cli.Uint64Flag{
+		Name:  "cpu-cfs-period",
+		Usage: "Limit CPU CFS (Completely Fair Scheduler) period in us",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-cfs-quota",
+		Usage: "Limit CPU CFS (Completely Fair Scheduler) quota in us",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-rt-period",
+		Usage: "Limit CPU Real-Time Scheduler period in us",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-rt-runtime",
+		Usage: "Limit CPU Real-Time Scheduler runtime in us",
+	},
+	cli.Uint64Flag{
+		Name:  "cpu-shares,c",
+		Usage: "CPU shares (relative weight)",
+	},
+	cli.StringFlag{
+		Name:  "cpuset-cpus",
+		Usage: "CPUs in which to allow execution (0-3, 0,1)",
+	},
+	cli.StringFlag{
+		Name:  "cpuset-mems",
+		Usage: "MEMs in which to allow execution (0-3, 0,1)",
+	},
+	cli.Int64Flag{
+		Name:  "memory-limit",
+		Usage: "Memory limit in bytes; -1 indicates unlimited",
+	},
+	cli.Int64Flag{
+		Name:  "memory-soft-limit",
+		Usage: "Memory soft limit in bytes; -1 indicates unlimited",
+	},
+	cli.Int64Flag{
+		Name:  "memory-swap-limit",
+		Usage: "Swap limit equals to memory plus swap; -1 indicates unlimited",
+	},
+	cli.Uint64Flag{
+		Name:  "pids-max",
+		Usage: "Limit pids number in container; 0 indicates unlimited",
+	},
+}
+
+// updatableResource describes how a flag of UpdateFlags is mapped to
+// the field of Resources, and the root name of the subsystem writing it.
+type updatableResource struct {
+	subsystem string
+	// get formats the field as the value of the flag.
+	get func(r *Resources) string
+	// copy copies the field from src to dst.
+	copy func(dst, src *Resources)
+}
+
+var updatableResources = map[string]*updatableResource{
+	"cpu-cfs-period": {
+		subsystem: cpu,
+		get:       func(r *Resources) string { return formatUint(r.CpuCfsPeriod) },
+		copy:      func(dst, src *Resources) { dst.CpuCfsPeriod = src.CpuCfsPeriod },
+	},
+	"cpu-cfs-quota": {
+		subsystem: cpu,
+		get:       func(r *Resources) string { return formatUint(r.CpuCfsQuota) },
+		copy:      func(dst, src *Resources) { dst.CpuCfsQuota = src.CpuCfsQuota },
+	},
+	"cpu-rt-period": {
+		subsystem: cpu,
+		get:       func(r *Resources) string { return formatUint(r.CpuRtPeriod) },
+		copy:      func(dst, src *Resources) { dst.CpuRtPeriod = src.CpuRtPeriod },
+	},
+	"cpu-rt-runtime": {
+		subsystem: cpu,
+		get:       func(r *Resources) string { return formatUint(r.CpuRtRuntime) },
+		copy:      func(dst, src *Resources) { dst.CpuRtRuntime = src.CpuRtRuntime },
+	},
+	"cpu-shares": {
+		subsystem: cpu,
+		get:       func(r *Resources) string { return formatUint(r.CpuShares) },
+		copy:      func(dst, src *Resources) { dst.CpuShares = src.CpuShares },
+	},
+	"cpuset-cpus": {
+		subsystem: cpuset,
+		get:       func(r *Resources) string { return r.CpusetCpus },
+		copy:      func(dst, src *Resources) { dst.CpusetCpus = src.CpusetCpus },
+	},
+	"cpuset-mems": {
+		subsystem: cpuset,
+		get:       func(r *Resources) string { return r.CpusetMems },
+		copy:      func(dst, src *Resources) { dst.CpusetMems = src.CpusetMems },
+	},
+	"memory-limit": {
+		subsystem: memory,
+		get:       func(r *Resources) string { return formatInt(r.MemoryLimit) },
+		copy:      func(dst, src *Resources) { dst.MemoryLimit = src.MemoryLimit },
+	},
+	"memory-soft-limit": {
+		subsystem: memory,
+		get:       func(r *Resources) string { return formatInt(r.MemorySoftLimit) },
+		copy:      func(dst, src *Resources) { dst.MemorySoftLimit = src.MemorySoftLimit },
+	},
+	"memory-swap-limit": {
+		subsystem: memory,
+		get:       func(r *Resources) string { return formatInt(r.MemorySwapLimit) },
+		copy:      func(dst, src *Resources) { dst.MemorySwapLimit = src.MemorySwapLimit },
+	},
+	"pids-max": {
+		subsystem: pids,
+		get:       func(r *Resources) string { return formatUint(r.PidsMax) },
+		copy:      func(dst, src *Resources) { dst.PidsMax = src.PidsMax },
+	},
+}
+
+// Update changes the resources of the cgroup by the flags of UpdateFlags
+// given in ctx, which are validated with the unchanged resources by the
+// same functions as NewResources. if the process of the cgroup is alive,
+// only the changed files are rewritten by the subsystems at once.
+func (cg *Cgroups) Update(ctx *cli.Context, live bool) error {
+	if cg.Resources == nil {
+		cg.Resources = &Resources{}
+	}
+
+	var changed []string
+	for name := range updatableResources {
+		if ctx.IsSet(name) {
+			changed = append(changed, name)
+		}
+	}
+	if len(changed) == 0 {
+		return fmt.Errorf("at least one resource flag must be given")
+	}
+
+	// parse the flags of run with the current resources as their values,
+	// except the ones to be changed, so that the limits depending on each
+	// other, e.g. the soft and hard limits of memory, are still validated.
+	set := flag.NewFlagSet("update", flag.ContinueOnError)
+	for _, f := range Flags {
+		f.Apply(set)
+	}
+	for name, resource := range updatableResources {
+		value := resource.get(cg.Resources)
+		if ctx.IsSet(name) {
+			value = ctx.String(name)
+		}
+		if err := set.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for --%s: %v", value, name, err)
+		}
+	}
+
+	parsed := *cg.Resources
+	runCtx := cli.NewContext(nil, set, nil)
+	for _, parseFlagsFunc := range []func(*cli.Context, *Resources) error{
+		parseCpuFlags,
+		parseCpusetFlags,
+		parseMemoryFlags,
+		parsePidsFlags,
+	} {
+		if err := parseFlagsFunc(runCtx, &parsed); err != nil {
+			return err
+		}
+	}
+
+	// the fields unchanged are skipped by the subsystems.
+	updated := *cg.Resources
+	delta := &Resources{
+		MemoryLimit:          -2,
+		MemorySoftLimit:      -2,
+		MemorySwapLimit:      -2,
+		MemorySwappiness:     101,
+		KernelMemoryLimit:    -2,
+		KernelMemoryTCPLimit: -2,
+	}
+	subsystems := map[string]bool{}
+	for _, name := range changed {
+		resource := updatableResources[name]
+		resource.copy(&updated, &parsed)
+		resource.copy(delta, &parsed)
+		subsystems[resource.subsystem] = true
+	}
+
+	if live {
+		for _, subsystem := range Subsystems {
+			if !subsystems[subsystem.RootName()] {
+				continue
+			}
+			if !subsystemIsMounted(subsystem.RootName()) {
+				return fmt.Errorf("subsystem %s is not mounted", subsystem.Name())
+			}
+			if err := subsystem.Set(cg.Path, delta); err != nil {
+				return fmt.Errorf("failed to set subsystem %s: %v",
+					subsystem.Name(), err)
+			}
+		}
+		// the swap limit is reset by the memory subsystem
+		// if the memory is unlimited or swap isn't supported.
+		if subsystems[memory] && delta.MemorySwapLimit >= -1 {
+			updated.MemorySwapLimit = delta.MemorySwapLimit
+		}
+	}
+
+	cg.Resources = &updated
+	return nil
+}
+
+func formatUint(value uint64) string {
+	return strconv.FormatUint(value, 10)
+}
+
+func formatInt(value int64) string {
+	return strconv.FormatInt(value, 10)
+}
@@ -29,8 +29,14 @@ func subsystemIsMounted(subsystemRootName string) bool {
 		return false
 	}
 
+	// e.g. 4:cpu,cpuacct:/user.slice, the lines without
+	// the subsystems, e.g. the empty last one, are skipped.
 	for _, subsystemInfo := range strings.Split(string(contentsBytes), "\n") {
-		if strings.Split(subsystemInfo, ":")[1] == subsystemRootName {
+		fields := strings.Split(subsystemInfo, ":")
+		if len(fields) < 3 {
+			continue
+		}
+		if fields[1] == subsystemRootName {
 			return true
 		}
 	}
@@ -44,9 +50,22 @@ func getSubsystemMountPoint(subsystemRootName string) (string, error) {
 		return "", err
 	}
 
+	// e.g. 36 25 0:31 / /sys/fs/cgroup/cpuset rw,relatime shared:13 - cgroup cgroup rw,cpuset
+	// the number of optional fields before the separator `-` varies,
+	// and the malformed lines, e.g. the empty last one, are skipped.
 	for _, mntInfo := range strings.Split(string(contentsBytes), "\n") {
 		mntFields := strings.Split(mntInfo, " ")
-		if mntFields[8] == cgroup && mntFields[9] == cgroup {
+		sep := -1
+		for idx := 6; idx < len(mntFields); idx++ {
+			if mntFields[idx] == "-" {
+				sep = idx
+				break
+			}
+		}
+		if sep < 0 || sep+2 >= len(mntFields) {
+			continue
+		}
+		if mntFields[sep+1] == cgroup && mntFields[sep+2] == cgroup {
 			if strings.HasSuffix(mntFields[4], subsystemRootName) {
 				return mntFields[4], nil
 			}
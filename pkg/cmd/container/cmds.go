@@ -1,6 +1,8 @@
 package container
 
 import (
+	"fmt"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"weike.sh/mydocker/pkg/cgroups"
@@ -17,6 +19,15 @@ var Init = cli.Command{
 	},
 }
 
+var Shim = cli.Command{
+	Name:   "shim",
+	Usage:  "Supervise a detached container. Do not call it outside!",
+	Hidden: true,
+	Action: func(ctx *cli.Context) error {
+		return container.RunShim()
+	},
+}
+
 var runFlags = []cli.Flag{
 	cli.BoolFlag{
 		Name:  "detach,d",
@@ -39,6 +50,10 @@ var runFlags = []cli.Flag{
 		Name:  "image,i",
 		Usage: "The image to be used (name or id)",
 	},
+	cli.StringFlag{
+		Name:  "platform",
+		Usage: "Require the image of the platform, e.g. linux/arm64",
+	},
 	cli.StringSliceFlag{
 		Name:  "env,e",
 		Usage: "Set environment variables, e.g. -e key=value",
@@ -55,6 +70,20 @@ var runFlags = []cli.Flag{
 		Name:  "publish,p",
 		Usage: "Publish the container's port(s) to the host",
 	},
+	cli.StringFlag{
+		Name:  "restart",
+		Usage: "Restart policy: no, on-failure[:max-retries], always, unless-stopped",
+		Value: container.RestartNo,
+	},
+	cli.StringFlag{
+		Name:  "stop-signal",
+		Usage: "Signal to stop the container (default is the image's or SIGTERM)",
+	},
+	cli.IntFlag{
+		Name:  "stop-timeout",
+		Usage: "Seconds to wait for the container to stop before killing it",
+		Value: container.DefaultStopTimeout,
+	},
 	cli.StringFlag{
 		Name:  "storage-driver,s",
 		Usage: "Storage driver to be used",
@@ -75,6 +104,25 @@ var Run = cli.Command{
 	},
 }
 
+var Create = cli.Command{
+	Name:  container.Create,
+	Usage: "Create a new container without starting it",
+	Flags: append(runFlags, cgroups.Flags...),
+	Action: func(ctx *cli.Context) error {
+		// the created container is started in background by
+		// `mydocker start`, unless it's attached by `start -a`.
+		if err := ctx.Set("detach", "true"); err != nil {
+			return err
+		}
+
+		c, err := container.NewContainer(ctx)
+		if err != nil {
+			return err
+		}
+		return c.Create()
+	},
+}
+
 var List = cli.Command{
 	Name:  "ps",
 	Usage: "List all containers on the host",
@@ -83,6 +131,40 @@ var List = cli.Command{
 	},
 }
 
+var Stats = cli.Command{
+	Name:  "stats",
+	Usage: "Display a live stream of containers' resource usage statistics",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "Disable streaming stats and only pull the first result",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format (table or json)",
+			Value: "table",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return showStats(ctx)
+	},
+}
+
+var Top = cli.Command{
+	Name:      "top",
+	Usage:     "Display the running processes of a container",
+	UsageText: "mydocker top CONTAINER [ps OPTIONS]",
+	// the options after the container are passed to ps.
+	SkipFlagParsing: true,
+	Action: func(ctx *cli.Context) error {
+		c, err := getContainerFromArg(ctx)
+		if err != nil {
+			return err
+		}
+		return showProcesses(c, ctx.Args().Tail())
+	},
+}
+
 var Logs = cli.Command{
 	Name:  "logs",
 	Usage: "Show all the logs of a container",
@@ -119,15 +201,52 @@ var Exec = cli.Command{
 var Stop = cli.Command{
 	Name:  container.Stop,
 	Usage: "Stop one or more containers",
+	Flags: []cli.Flag{
+		cli.IntFlag{
+			Name:  "time,t",
+			Usage: "Seconds to wait for stop before killing it (default is the container's)",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
 		return operateContainers(ctx, container.Stop)
 	},
 }
 
+var Kill = cli.Command{
+	Name:  "kill",
+	Usage: "Kill one or more running containers",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "signal,s",
+			Usage: "Signal to send to the container",
+			Value: "KILL",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return killContainers(ctx)
+	},
+}
+
 var Start = cli.Command{
 	Name:  container.Start,
 	Usage: "Start one or more containers",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "attach,a",
+			Usage: "Attach the terminal to the container and wait for it",
+		},
+	},
 	Action: func(ctx *cli.Context) error {
+		if ctx.Bool("attach") {
+			if len(ctx.Args()) != 1 {
+				return fmt.Errorf("only one container can be started with -a")
+			}
+			c, err := getContainerFromArg(ctx)
+			if err != nil {
+				return err
+			}
+			return c.StartAttached()
+		}
 		return operateContainers(ctx, container.Start)
 	},
 }
@@ -147,3 +266,148 @@ var Remove = cli.Command{
 		return operateContainers(ctx, container.Delete)
 	},
 }
+
+var Pause = cli.Command{
+	Name:  container.Pause,
+	Usage: "Pause all processes within one or more containers",
+	Action: func(ctx *cli.Context) error {
+		return operateContainers(ctx, container.Pause)
+	},
+}
+
+var Unpause = cli.Command{
+	Name:  container.Unpause,
+	Usage: "Unpause all processes within one or more containers",
+	Action: func(ctx *cli.Context) error {
+		return operateContainers(ctx, container.Unpause)
+	},
+}
+
+var Update = cli.Command{
+	Name:  "update",
+	Usage: "Update resource limits of one or more containers",
+	Flags: cgroups.UpdateFlags,
+	Action: func(ctx *cli.Context) error {
+		return updateContainers(ctx)
+	},
+}
+
+var Wait = cli.Command{
+	Name:  "wait",
+	Usage: "Block until one or more containers stop, then print their exit codes",
+	Action: func(ctx *cli.Context) error {
+		return waitContainers(ctx)
+	},
+}
+
+var Commit = cli.Command{
+	Name:  "commit",
+	Usage: "Create a new image from a container's changes",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "change,c",
+			Usage: "Apply instructions to the image, e.g. -c 'CMD [\"sh\"]'",
+		},
+		cli.StringFlag{
+			Name:  "message,m",
+			Usage: "Commit message",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		c, err := getContainerFromArg(ctx)
+		if err != nil {
+			return err
+		}
+
+		img, err := c.Commit(ctx.Args().Get(1), ctx.StringSlice("change"), ctx.String("message"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(img.Id)
+		return nil
+	},
+}
+
+var Diff = cli.Command{
+	Name:  "diff",
+	Usage: "Inspect changes to files on a container's filesystem",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "Output format (text or json)",
+			Value: "text",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		c, err := getContainerFromArg(ctx)
+		if err != nil {
+			return err
+		}
+		return showDiff(c, ctx.String("format"))
+	},
+}
+
+var Copy = cli.Command{
+	Name:  "cp",
+	Usage: "Copy files/folders between a container and the host",
+	UsageText: "mydocker cp CONTAINER:SRC_PATH DEST_PATH|-\n" +
+		"   mydocker cp SRC_PATH|- CONTAINER:DEST_PATH",
+	Action: func(ctx *cli.Context) error {
+		return copyFiles(ctx)
+	},
+}
+
+var Export = cli.Command{
+	Name:  "export",
+	Usage: "Export a container's filesystem as a tar archive",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output,o",
+			Usage: "Write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		c, err := getContainerFromArg(ctx)
+		if err != nil {
+			return err
+		}
+		return exportContainer(c, ctx.String("output"))
+	},
+}
+
+var Build = cli.Command{
+	Name:      "build",
+	Usage:     "Build an image from a Dockerfile",
+	UsageText: "mydocker build [options] PATH",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tag,t",
+			Usage: "Name and optionally a tag in the 'name:tag' format",
+		},
+		cli.StringFlag{
+			Name:  "file,f",
+			Usage: "Name of the Dockerfile (default is 'PATH/Dockerfile')",
+		},
+		cli.StringSliceFlag{
+			Name:  "build-arg",
+			Usage: "Set build-time variables, e.g. --build-arg key=value",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "Do not use cache when building the image",
+		},
+		cli.StringSliceFlag{
+			Name:  "network,net",
+			Usage: "Connect the containers of RUN to a network",
+		},
+		cli.StringFlag{
+			Name:  "storage-driver,s",
+			Usage: "Storage driver to be used",
+			Value: "overlay2",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return buildImage(ctx)
+	},
+}
@@ -1,13 +1,22 @@
 package container
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/c2h5oh/datasize"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"weike.sh/mydocker/pkg/build"
+	"weike.sh/mydocker/pkg/cgroups"
 	"weike.sh/mydocker/pkg/container"
+	"weike.sh/mydocker/util"
 )
 
 func listContainers(_ *cli.Context) error {
@@ -39,7 +48,7 @@ func listContainers(_ *cli.Context) error {
 			c.Uuid,
 			c.Name,
 			c.Image,
-			c.Status,
+			c.StateString(),
 			c.StorageDriver,
 			c.Cgroups.Pid,
 			c.Commands,
@@ -55,6 +64,170 @@ func listContainers(_ *cli.Context) error {
 	return nil
 }
 
+// statsInterval is the interval between the samples of stats.
+const statsInterval = time.Second
+
+func showStats(ctx *cli.Context) error {
+	format := ctx.String("format")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	// the cpu percents are computed since the previous samples, so
+	// the first samples are taken one interval before showing them.
+	prevs, err := sampleStats(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	refresh := !ctx.Bool("no-stream") && format == "table"
+	if fi, err := os.Stdout.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		refresh = false
+	}
+
+	for {
+		time.Sleep(statsInterval)
+		stats, err := sampleStats(ctx, prevs)
+		if err != nil {
+			return err
+		}
+
+		if refresh {
+			// move the cursor to the top left and clear the screen.
+			fmt.Print("\033[H\033[2J")
+		}
+		if err := printStats(stats, format); err != nil {
+			return err
+		}
+
+		if ctx.Bool("no-stream") {
+			return nil
+		}
+		prevs = stats
+	}
+}
+
+// sampleStats samples the stats of the containers given by args, or all
+// the running containers, the containers given are reloaded every time,
+// so that the ones stopped are skipped while streaming.
+func sampleStats(ctx *cli.Context, prevs []*container.Stats) ([]*container.Stats, error) {
+	var containers []*container.Container
+	if len(ctx.Args()) > 0 {
+		for _, arg := range ctx.Args() {
+			c, err := container.GetContainerByNameOrUuid(arg)
+			if err != nil {
+				return nil, err
+			}
+			containers = append(containers, c)
+		}
+	} else {
+		allContainers, err := container.GetAllContainers()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range allContainers {
+			if c.Status == container.Running || c.Status == container.Paused {
+				containers = append(containers, c)
+			}
+		}
+	}
+
+	prevMap := map[string]*container.Stats{}
+	for _, prev := range prevs {
+		prevMap[prev.Uuid] = prev
+	}
+
+	var stats []*container.Stats
+	for _, c := range containers {
+		s, err := c.Stats(prevMap[c.Uuid])
+		if err != nil {
+			// the containers given must be running at first.
+			if prevs == nil && len(ctx.Args()) > 0 {
+				return nil, err
+			}
+			log.Debugf("skip container %s: %v", c.Uuid, err)
+			continue
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
+
+func printStats(stats []*container.Stats, format string) error {
+	if format == "json" {
+		// one json object per line, like `docker stats --format json`.
+		for _, s := range stats {
+			jsonBytes, err := json.Marshal(s)
+			if err != nil {
+				return fmt.Errorf("failed to json-encode stats: %v", err)
+			}
+			fmt.Println(string(jsonBytes))
+		}
+		return nil
+	}
+
+	human := func(size uint64) string {
+		return datasize.ByteSize(size).HumanReadable()
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "CONTAINER ID\tNAME\tCPU %%\tMEM USAGE / LIMIT\tMEM %%\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, s := range stats {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s / %s\t%s / %s\t%d\n",
+			s.Uuid,
+			s.Name,
+			s.CpuPercent,
+			human(s.MemoryUsage), human(s.MemoryLimit),
+			s.MemoryPercent,
+			human(s.NetworkRx), human(s.NetworkTx),
+			human(s.BlockRead), human(s.BlockWrite),
+			s.Pids)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush buffer: %v", err)
+	}
+
+	return nil
+}
+
+func showProcesses(c *container.Container, psOptions []string) error {
+	if len(psOptions) > 0 {
+		lines, err := c.Ps(psOptions)
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(lines, "\n"))
+		return nil
+	}
+
+	processes, err := c.Top()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "USER\tPID\tPPID\tNSPID\tTIME\tRSS\tCOMMAND\n")
+	for _, p := range processes {
+		seconds := int(p.Time.Seconds())
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%02d:%02d:%02d\t%s\t%s\n",
+			p.User,
+			p.Pid,
+			p.Ppid,
+			p.NsPid,
+			seconds/3600, seconds/60%60, seconds%60,
+			datasize.ByteSize(p.Rss).HumanReadable(),
+			p.Command)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush buffer: %v", err)
+	}
+
+	return nil
+}
+
 func getContainerFromArg(ctx *cli.Context) (*container.Container, error) {
 	if len(ctx.Args()) < 1 {
 		return nil, fmt.Errorf("missing container's name or uuid")
@@ -87,6 +260,9 @@ func parseExecArgs(ctx *cli.Context) (*container.Container, []string, error) {
 		return nil, nil, fmt.Errorf("invalid container name or uuid: %s", identifier)
 	}
 
+	if c.Status == container.Paused {
+		return nil, nil, fmt.Errorf("the container %s is paused, unpause it first", identifier)
+	}
 	if c.Status != container.Running {
 		return nil, nil, fmt.Errorf("the container %s is %s, not running", identifier, c.Status)
 	}
@@ -115,6 +291,13 @@ func operateContainers(ctx *cli.Context, action string) error {
 	}
 
 	unknownErr := fmt.Errorf("unknown action: %s", action)
+	// the stop timeout of each container is used unless -t is given.
+	timeout := -1
+	if action == container.Stop && ctx.IsSet("time") {
+		if timeout = ctx.Int("time"); timeout < 0 {
+			return fmt.Errorf("--time must be >= 0")
+		}
+	}
 	for _, arg := range ctx.Args() {
 		c, err := container.GetContainerByNameOrUuid(arg)
 		if err != nil {
@@ -123,13 +306,17 @@ func operateContainers(ctx *cli.Context, action string) error {
 
 		switch action {
 		case container.Stop:
-			err = c.Stop()
+			err = c.Stop(timeout)
 		case container.Start:
 			err = c.Start()
 		case container.Restart:
 			err = c.Restart()
 		case container.Delete:
 			err = c.Delete()
+		case container.Pause:
+			err = c.Pause()
+		case container.Unpause:
+			err = c.Unpause()
 		default:
 			err = unknownErr
 		}
@@ -141,3 +328,240 @@ func operateContainers(ctx *cli.Context, action string) error {
 
 	return nil
 }
+
+func killContainers(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing container's name or uuid")
+	}
+
+	sig, err := util.ParseSignal(ctx.String("signal"))
+	if err != nil {
+		return err
+	}
+
+	for _, arg := range ctx.Args() {
+		c, err := container.GetContainerByNameOrUuid(arg)
+		if err != nil {
+			return err
+		}
+		if err := c.Kill(sig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func updateContainers(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing container's name or uuid")
+	}
+
+	for _, arg := range ctx.Args() {
+		c, err := container.GetContainerByNameOrUuid(arg)
+		if err != nil {
+			return err
+		}
+		if err := c.Update(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func waitContainers(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing container's name or uuid")
+	}
+
+	for _, arg := range ctx.Args() {
+		c, err := container.GetContainerByNameOrUuid(arg)
+		if err != nil {
+			return err
+		}
+
+		exitCode, err := c.Wait()
+		if err != nil {
+			return err
+		}
+		fmt.Println(exitCode)
+	}
+
+	return nil
+}
+
+func showDiff(c *container.Container, format string) error {
+	changes, err := c.Diff()
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "text":
+		for _, change := range changes {
+			fmt.Printf("%s %s\n", change.Kind, change.Path)
+		}
+	case "json":
+		// print an empty array rather than null if nothing changed.
+		if changes == nil {
+			changes = []*container.Change{}
+		}
+		jsonBytes, err := json.MarshalIndent(changes, "", "    ")
+		if err != nil {
+			return fmt.Errorf("failed to json-encode changes: %v", err)
+		}
+		fmt.Println(string(jsonBytes))
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	return nil
+}
+
+func copyFiles(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return fmt.Errorf("cp requires exactly 2 arguments: SRC and DEST")
+	}
+
+	src, dst := ctx.Args().Get(0), ctx.Args().Get(1)
+	srcContainer, srcPath := splitCopyArg(src)
+	dstContainer, dstPath := splitCopyArg(dst)
+
+	switch {
+	case srcContainer != "" && dstContainer != "":
+		return fmt.Errorf("copying between containers is not supported")
+	case srcContainer != "":
+		c, err := container.GetContainerByNameOrUuid(srcContainer)
+		if err != nil {
+			return err
+		}
+		return c.CopyFrom(srcPath, dstPath)
+	case dstContainer != "":
+		c, err := container.GetContainerByNameOrUuid(dstContainer)
+		if err != nil {
+			return err
+		}
+		return c.CopyTo(srcPath, dstPath)
+	default:
+		return fmt.Errorf("one of SRC and DEST must be CONTAINER:PATH")
+	}
+}
+
+// splitCopyArg splits the arg `container:path` of cp, the arg is a
+// local path if it starts with `/` or `.`, e.g. ./file:with:colons
+func splitCopyArg(arg string) (string, string) {
+	if strings.HasPrefix(arg, "/") || strings.HasPrefix(arg, ".") {
+		return "", arg
+	}
+
+	parts := strings.SplitN(arg, ":", 2)
+	if len(parts) == 1 {
+		return "", arg
+	}
+	return parts[0], parts[1]
+}
+
+func exportContainer(c *container.Container, fileName string) error {
+	if fileName == "" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to export to a terminal, use -o or redirect STDOUT")
+		}
+		return c.Export(os.Stdout)
+	}
+
+	// write to a temp file first, so that a failed export
+	// doesn't leave a broken tarball with the given name.
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmpName, err)
+	}
+	defer os.Remove(tmpName)
+
+	if err := c.Export(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, fileName)
+}
+
+func buildImage(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("requires exactly one argument as the build context")
+	}
+
+	contextDir, err := filepath.Abs(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(contextDir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("the build context %s must be a directory", contextDir)
+	}
+
+	dockerfile := ctx.String("file")
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+
+	buildArgs := make(map[string]string)
+	for _, arg := range ctx.StringSlice("build-arg") {
+		kv := strings.SplitN(arg, "=", 2)
+		if kv[0] == "" {
+			return fmt.Errorf("the argument of --build-arg should be 'key=value'")
+		}
+		// the value is taken from the env if it's omitted.
+		if len(kv) == 1 {
+			kv = append(kv, os.Getenv(kv[0]))
+		}
+		buildArgs[kv[0]] = kv[1]
+	}
+
+	builder := &build.Builder{
+		ContextDir:   contextDir,
+		Dockerfile:   dockerfile,
+		Tags:         ctx.StringSlice("tag"),
+		BuildArgs:    buildArgs,
+		NoCache:      ctx.Bool("no-cache"),
+		Stdout:       os.Stdout,
+		NewContainer: newBuildContainer(ctx),
+	}
+
+	_, err = builder.Build()
+	return err
+}
+
+// newBuildContainer returns the function creating the containers of
+// build steps, which parses the flags of `mydocker run` like a user.
+func newBuildContainer(ctx *cli.Context) func(string, []string, []string, bool) (*container.Container, error) {
+	return func(img string, commands, envs []string, network bool) (*container.Container, error) {
+		set := flag.NewFlagSet("run", flag.ContinueOnError)
+		for _, f := range append(runFlags, cgroups.Flags...) {
+			f.Apply(set)
+		}
+
+		args := []string{"--image", img, "--storage-driver", ctx.String("storage-driver")}
+		for _, env := range envs {
+			args = append(args, "--env", env)
+		}
+
+		networks := ctx.StringSlice("network")
+		if !network {
+			networks = []string{"none"}
+		}
+		for _, nw := range networks {
+			args = append(args, "--network", nw)
+		}
+
+		args = append(append(args, "--"), commands...)
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+
+		return container.NewContainer(cli.NewContext(ctx.App, set, nil))
+	}
+}
@@ -2,6 +2,7 @@ package image
 
 import (
 	"github.com/urfave/cli"
+	"weike.sh/mydocker/pkg/image"
 )
 
 var Command = cli.Command{
@@ -11,37 +12,171 @@ var Command = cli.Command{
 		Pull,
 		Remove,
 		List,
+		Load,
+		Save,
+		Import,
+		Tag,
+		Untag,
+		History,
+		Prune,
+		Inspect,
+	},
+}
+
+var removeFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "force,f",
+		Usage: "Force removal of the image referenced by multiple repotags",
+	},
+}
+
+var listFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "all,a",
+		Usage: "Show all images (default hides intermediate images)",
+	},
+	cli.BoolFlag{
+		Name:  "digests",
+		Usage: "Show digests",
+	},
+	cli.StringSliceFlag{
+		Name:  "filter,f",
+		Usage: "Filter output based on conditions provided, e.g. dangling=true",
 	},
 }
 
 var (
 	Pull = cli.Command{
-		Name:   "pull",
-		Usage:  "Pull an image from a registry",
+		Name:  "pull",
+		Usage: "Pull an image from a registry",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "platform",
+				Usage: "Set platform if the image is multi-platform, e.g. linux/arm64",
+			},
+		},
 		Action: pull,
 	}
 
 	Remove = cli.Command{
 		Name:   "rm",
 		Usage:  "Remove one or more images",
+		Flags:  removeFlags,
 		Action: remove,
 	}
 
 	RemoveImages = cli.Command{
 		Name:   "rmi",
 		Usage:  "Remove one or more images",
+		Flags:  removeFlags,
 		Action: remove,
 	}
 
 	List = cli.Command{
 		Name:   "list",
 		Usage:  "List images on the host",
+		Flags:  listFlags,
 		Action: list,
 	}
 
 	ListImages = cli.Command{
 		Name:   "images",
 		Usage:  "List images on the host",
+		Flags:  listFlags,
 		Action: list,
 	}
+
+	Load = cli.Command{
+		Name:  "load",
+		Usage: "Load images from a docker-archive or OCI layout tarball",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "input,i",
+				Usage: "Read from a tarball file, instead of STDIN",
+			},
+		},
+		Action: load,
+	}
+
+	Save = cli.Command{
+		Name:  "save",
+		Usage: "Save one or more images to a tarball",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:  "output,o",
+				Usage: "Write to a file, instead of STDOUT",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "The format of the tarball (docker or oci)",
+				Value: image.FormatDocker,
+			},
+		},
+		Action: save,
+	}
+
+	Import = cli.Command{
+		Name:      "import",
+		Usage:     "Import the contents from a tarball to create an image",
+		UsageText: "mydocker import [options] file|- [REPOSITORY[:TAG]]",
+		Flags: []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "change,c",
+				Usage: "Apply instructions to the image, e.g. -c 'ENV a=b'",
+			},
+			cli.StringFlag{
+				Name:  "message,m",
+				Usage: "Set commit message for imported image",
+			},
+		},
+		Action: importImage,
+	}
+
+	Tag = cli.Command{
+		Name:      "tag",
+		Usage:     "Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+		UsageText: "mydocker tag SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]",
+		Action:    tag,
+	}
+
+	Untag = cli.Command{
+		Name:      "untag",
+		Usage:     "Remove names from an image, all of them if none is given",
+		UsageText: "mydocker image untag IMAGE [NAME...]",
+		Action:    untag,
+	}
+
+	History = cli.Command{
+		Name:  "history",
+		Usage: "Show the history of an image",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "no-trunc",
+				Usage: "Don't truncate output",
+			},
+		},
+		Action: history,
+	}
+
+	Prune = cli.Command{
+		Name:  "prune",
+		Usage: "Remove unused images",
+		Flags: []cli.Flag{
+			cli.BoolFlag{
+				Name:  "all,a",
+				Usage: "Remove all unused images, not just dangling ones",
+			},
+			cli.StringSliceFlag{
+				Name:  "filter",
+				Usage: "Provide filter values, e.g. until=24h",
+			},
+		},
+		Action: prune,
+	}
+
+	Inspect = cli.Command{
+		Name:   "inspect",
+		Usage:  "Display detailed information on one or more images",
+		Action: inspect,
+	}
 )
@@ -1,11 +1,15 @@
 package image
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"text/tabwriter"
+	"time"
 
+	"github.com/c2h5oh/datasize"
 	"github.com/urfave/cli"
 	"weike.sh/mydocker/pkg/image"
 )
@@ -15,14 +19,26 @@ func pull(ctx *cli.Context) error {
 		return fmt.Errorf("misssing image's repo and tag")
 	}
 
+	platform := ctx.String("platform")
+	var p *image.Platform
+	if platform != "" {
+		var err error
+		if p, err = image.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+
 	for _, imageName := range ctx.Args() {
-		if !strings.Contains(imageName, ":") {
-			imageName = imageName + ":latest"
+		ref, err := image.ParseReference(imageName)
+		if err != nil {
+			return err
 		}
-		if image.Exist(imageName) {
+		// the image is pulled again if it's for another platform.
+		if img, err := image.GetImageByNameOrUuid(ref.RepoTag()); err == nil &&
+			(p == nil || img.Platform() == nil || p.Match(img.Platform())) {
 			continue
 		}
-		if err := image.Pull(imageName); err != nil {
+		if err := image.Pull(imageName, platform); err != nil {
 			return err
 		}
 	}
@@ -36,7 +52,7 @@ func remove(ctx *cli.Context) error {
 	}
 
 	for _, identifier := range ctx.Args() {
-		if err := image.Delete(identifier); err != nil {
+		if err := image.Delete(identifier, ctx.Bool("force")); err != nil {
 			return err
 		}
 	}
@@ -44,22 +60,145 @@ func remove(ctx *cli.Context) error {
 	return nil
 }
 
-func list(_ *cli.Context) error {
-	if err := image.Load(); err != nil {
+func load(ctx *cli.Context) error {
+	input := os.Stdin
+	if fileName := ctx.String("input"); fileName != "" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", fileName, err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	repoTags, err := image.LoadArchive(input)
+	for _, repoTag := range repoTags {
+		fmt.Printf("Loaded image: %s\n", repoTag)
+	}
+	return err
+}
+
+func save(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("misssing image's repo and tag")
+	}
+
+	fileName := ctx.String("output")
+	if fileName == "" {
+		if fi, err := os.Stdout.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to save to a terminal, use -o or redirect STDOUT")
+		}
+		return image.SaveArchive(os.Stdout, ctx.Args(), ctx.String("format"))
+	}
+
+	// write to a temp file first, so that a failed save
+	// doesn't leave a broken tarball with the given name.
+	tmpName := fileName + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", tmpName, err)
+	}
+	defer os.Remove(tmpName)
+
+	if err := image.SaveArchive(file, ctx.Args(), ctx.String("format")); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, fileName)
+}
+
+func importImage(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing the tarball to import")
+	}
+
+	input := os.Stdin
+	if fileName := ctx.Args().Get(0); fileName != "-" {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %v", fileName, err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	img, err := image.Import(input, ctx.Args().Get(1), ctx.StringSlice("change"), ctx.String("message"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(img.Id)
+	return nil
+}
+
+func tag(ctx *cli.Context) error {
+	if len(ctx.Args()) != 2 {
+		return fmt.Errorf("requires exactly 2 arguments: SOURCE_IMAGE TARGET_IMAGE")
+	}
+	return image.Tag(ctx.Args().Get(0), ctx.Args().Get(1))
+}
+
+func untag(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing image's name or uuid")
+	}
+
+	img, err := image.GetImageByNameOrUuid(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	repoTags := ctx.Args()[1:]
+	if len(repoTags) == 0 {
+		repoTags = image.RepoTags(img.Uuid)
+	}
+
+	for _, repoTag := range repoTags {
+		// the names must be the repotags of the same image.
+		if named, err := image.GetImageByNameOrUuid(repoTag); err != nil || named.Uuid != img.Uuid {
+			return fmt.Errorf("%s is not a name of image %s", repoTag, img.Uuid)
+		}
+		if err := image.Untag(repoTag); err != nil {
+			return err
+		}
+		fmt.Printf("Untagged: %s\n", repoTag)
+	}
+
+	return nil
+}
+
+func history(ctx *cli.Context) error {
+	if len(ctx.Args()) != 1 {
+		return fmt.Errorf("requires exactly 1 argument: IMAGE")
+	}
+
+	img, err := image.GetImageByNameOrUuid(ctx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	entries, err := img.History()
+	if err != nil {
 		return err
 	}
 
 	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
-	fmt.Fprint(w, "IMAGE ID\tREPO\tTAG\tCOUNTS\tCREATED\tSIZE\n")
-	for _, img := range image.Images {
-		repoTags := strings.Split(img.RepoTag, ":")
-		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n",
-			img.Uuid,
-			repoTags[0],
-			repoTags[1],
-			img.Counts,
-			img.CreateTime,
-			img.Size,
+	fmt.Fprint(w, "IMAGE\tCREATED\tCREATED BY\tSIZE\tCOMMENT\n")
+	for _, entry := range entries {
+		createdBy := strings.Replace(entry.CreatedBy, "\t", " ", -1)
+		if !ctx.Bool("no-trunc") && len(createdBy) > 45 {
+			createdBy = createdBy[:44] + "…"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			entry.Id,
+			entry.Created,
+			createdBy,
+			datasize.ByteSize(entry.Size).HumanReadable(),
+			entry.Comment,
 		)
 	}
 
@@ -68,3 +207,152 @@ func list(_ *cli.Context) error {
 	}
 	return nil
 }
+
+// parseDanglingFilter returns the value of filter `dangling=true|false`,
+// which is nil if the filter isn't given.
+func parseDanglingFilter(filters []string) (*bool, error) {
+	var dangling *bool
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] != "dangling" {
+			return nil, fmt.Errorf("unsupported filter %q, only dangling=true|false is supported", filter)
+		}
+
+		value, err := strconv.ParseBool(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of filter dangling: %s", kv[1])
+		}
+		dangling = &value
+	}
+	return dangling, nil
+}
+
+func list(ctx *cli.Context) error {
+	dangling, err := parseDanglingFilter(ctx.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+
+	if err := image.UpdateCounts(); err != nil {
+		return err
+	}
+
+	// the intermediate images of build are untagged parents.
+	parents := map[string]bool{}
+	for _, img := range image.Images {
+		if img.Parent != "" {
+			parents[img.Parent] = true
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	if ctx.Bool("digests") {
+		fmt.Fprint(w, "IMAGE ID\tREPO\tTAG\tDIGEST\tCOUNTS\tCREATED\tSIZE\n")
+	} else {
+		fmt.Fprint(w, "IMAGE ID\tREPO\tTAG\tCOUNTS\tCREATED\tSIZE\n")
+	}
+	for _, img := range image.Images {
+		untagged := img.RepoTag == image.NoneRepoTag
+		if !ctx.Bool("all") && untagged && parents[img.Id] {
+			continue
+		}
+		// the dangling images are untagged but not intermediate.
+		if dangling != nil && *dangling != (untagged && !parents[img.Id]) {
+			continue
+		}
+
+		// notes: the repo maybe contain the registry's port,
+		// e.g. localhost:5000/app:v1, so split by the last colon.
+		idx := strings.LastIndex(img.RepoTag, ":")
+		fmt.Fprintf(w, "%s\t%s\t%s\t", img.Uuid, img.RepoTag[:idx], img.RepoTag[idx+1:])
+		if ctx.Bool("digests") {
+			digest := img.Digest
+			if digest == "" {
+				digest = "<none>"
+			}
+			fmt.Fprintf(w, "%s\t", digest)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", img.Counts, img.CreateTime, img.Size)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush %v", err)
+	}
+	return nil
+}
+
+// parseUntilFilter returns the time of filter `until=<duration|timestamp>`,
+// e.g. until=24h or until=2019-01-25T09:43:22, it's zero if not given.
+func parseUntilFilter(filters []string) (time.Time, error) {
+	var until time.Time
+	for _, filter := range filters {
+		kv := strings.SplitN(filter, "=", 2)
+		if len(kv) != 2 || kv[0] != "until" {
+			return until, fmt.Errorf("unsupported filter %q, only until=<timestamp> is supported", filter)
+		}
+
+		if d, err := time.ParseDuration(kv[1]); err == nil {
+			until = time.Now().Add(-d)
+			continue
+		}
+
+		var err error
+		for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+			if until, err = time.ParseInLocation(layout, kv[1], time.Local); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return until, fmt.Errorf("invalid value of filter until: %s", kv[1])
+		}
+	}
+	return until, nil
+}
+
+func prune(ctx *cli.Context) error {
+	until, err := parseUntilFilter(ctx.StringSlice("filter"))
+	if err != nil {
+		return err
+	}
+
+	report, err := image.Prune(ctx.Bool("all"), until)
+	if err != nil {
+		return err
+	}
+
+	if len(report.Untagged)+len(report.Deleted) > 0 {
+		fmt.Println("Deleted Images:")
+		for _, repoTag := range report.Untagged {
+			fmt.Printf("untagged: %s\n", repoTag)
+		}
+		for _, id := range report.Deleted {
+			fmt.Printf("deleted: %s\n", id)
+		}
+		fmt.Println()
+	}
+
+	fmt.Printf("Total reclaimed space: %s\n", datasize.ByteSize(report.Reclaimed).HumanReadable())
+	return nil
+}
+
+func inspect(ctx *cli.Context) error {
+	if len(ctx.Args()) < 1 {
+		return fmt.Errorf("missing image's name or uuid")
+	}
+
+	var images []*image.Image
+	for _, identifier := range ctx.Args() {
+		img, err := image.GetImageByNameOrUuid(identifier)
+		if err != nil {
+			return err
+		}
+		images = append(images, img)
+	}
+
+	jsonBytes, err := json.MarshalIndent(images, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to json-encode images: %v", err)
+	}
+	fmt.Println(string(jsonBytes))
+	return nil
+}
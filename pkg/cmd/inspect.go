@@ -21,7 +21,11 @@ var Inspect = cli.Command{
 
 		for _, arg := range ctx.Args() {
 			if c, err := container.GetContainerByNameOrUuid(arg); err == nil {
-				showUp(c, "container", arg)
+				// the state in the form of ps, e.g. "Exited (1) 2 hours ago".
+				showUp(&struct {
+					*container.Container
+					State string `json:"State"`
+				}{c, c.StateString()}, "container", arg)
 				continue
 			}
 			if nw, ok := network.Networks[arg]; ok {
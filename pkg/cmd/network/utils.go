@@ -79,8 +79,11 @@ func handleConnection(ctx *cli.Context, action string) error {
 			cName, err)
 	}
 
-	if c.Status != container.Running {
-		return fmt.Errorf("the container %s is not running", c.Uuid)
+	// only the configs of the container not running are modified,
+	// its endpoints are connected once it's started.
+	running := c.Status == container.Running || c.Status == container.Paused
+	if c.Status == container.Restarting {
+		return fmt.Errorf("the container %s is restarting", c.Uuid)
 	}
 
 	nwExist := false
@@ -106,9 +109,11 @@ func handleConnection(ctx *cli.Context, action string) error {
 		}
 
 		// there's only one endpoint to be added.
-		if err := eps[0].Connect(c.Cgroups.Pid); err != nil {
-			// note: need to release the ipaddr if failed.
-			return network.IPAllocator.Release(nw, &eps[0].IPAddr)
+		if running {
+			if err := eps[0].Connect(c.Cgroups.Pid); err != nil {
+				// note: need to release the ipaddr if failed.
+				return network.IPAllocator.Release(nw, &eps[0].IPAddr)
+			}
 		}
 
 		// update container's Endpoints finally.
@@ -125,8 +130,10 @@ func handleConnection(ctx *cli.Context, action string) error {
 		c.Endpoints = c.Endpoints[:0]
 		for _, ep := range tmpEndpoints {
 			// first, disconnect all the endpoints.
-			if err := ep.DisConnect(c.Cgroups.Pid); err != nil {
-				return err
+			if running {
+				if err := ep.DisConnect(c.Cgroups.Pid); err != nil {
+					return err
+				}
 			}
 			if ep.Network.Name == nwName {
 				// note: don't forget to release the ipaddr.
@@ -139,8 +146,11 @@ func handleConnection(ctx *cli.Context, action string) error {
 			}
 		}
 
+		// then, connect all the remaining endpoints.
 		for _, ep := range c.Endpoints {
-			// then, connect all the remaining endpoints.
+			if !running {
+				continue
+			}
 			if err := ep.Connect(c.Cgroups.Pid); err != nil {
 				return err
 			}
@@ -0,0 +1,37 @@
+package system
+
+import (
+	"github.com/urfave/cli"
+	"weike.sh/mydocker/pkg/container"
+)
+
+var Command = cli.Command{
+	Name:  "system",
+	Usage: "Manage the mydocker host",
+	Subcommands: []cli.Command{
+		Check,
+		Restore,
+	},
+}
+
+var Check = cli.Command{
+	Name:  "check",
+	Usage: "Check the state of mydocker against the host",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "repair",
+			Usage: "Repair the problems found if possible",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		return checkSystem(ctx)
+	},
+}
+
+var Restore = cli.Command{
+	Name:  "restore",
+	Usage: "Restore networks and containers after the host rebooted",
+	Action: func(ctx *cli.Context) error {
+		return container.Restore()
+	},
+}
@@ -0,0 +1,54 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"weike.sh/mydocker/pkg/container"
+)
+
+func checkSystem(ctx *cli.Context) error {
+	problems, err := container.Check()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("no problems found")
+		return nil
+	}
+
+	repair := ctx.Bool("repair")
+	unresolved := 0
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "OBJECT\tPROBLEM\tSTATUS\n")
+	for _, p := range problems {
+		status := "unrepairable"
+		switch {
+		case !p.Repairable():
+			unresolved++
+		case !repair:
+			status = "repairable"
+			unresolved++
+		default:
+			if err := p.Repair(); err != nil {
+				log.Warnf("failed to repair %s: %v", p.Object, err)
+				status = "failed"
+				unresolved++
+			} else {
+				status = "repaired"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Object, p.Problem, status)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush %v", err)
+	}
+
+	if unresolved > 0 {
+		return fmt.Errorf("%d of %d problems aren't repaired", unresolved, len(problems))
+	}
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 
+	"weike.sh/mydocker/pkg/image"
 	"weike.sh/mydocker/util"
 )
 
@@ -23,9 +25,20 @@ func (aufs *AufsDriver) MountRootfs(c *Container) error {
 		return err
 	}
 
-	options := fmt.Sprintf("xino=%s/.xino,dirs=%s:%s",
-		XinoTmpfs, c.Rootfs.WriteDir, c.Rootfs.ImageDir)
+	// the whiteout files in image layers are only respected
+	// by aufs when the branches are mounted with ro+wh.
+	branches := []string{c.Rootfs.WriteDir + "=rw"}
+	lowerDirs, relative := relativeDirs(c.Rootfs.LowerDirs(), image.LayersDir)
+	for _, dir := range lowerDirs {
+		branches = append(branches, dir+"=ro+wh")
+	}
+
+	options := fmt.Sprintf("xino=%s/.xino,dirs=%s",
+		XinoTmpfs, strings.Join(branches, ":"))
 	cmd := exec.Command("mount", "-t", "aufs", "-o", options, "none", c.Rootfs.MergeDir)
+	if relative {
+		cmd.Dir = image.LayersDir
+	}
 
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to mount aufs: %v", err)
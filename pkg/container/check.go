@@ -0,0 +1,387 @@
+package container
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"weike.sh/mydocker/pkg/cgroups"
+	"weike.sh/mydocker/pkg/image"
+	"weike.sh/mydocker/pkg/network"
+	"weike.sh/mydocker/util"
+)
+
+const MountInfoFile = "/proc/self/mountinfo"
+
+// Problem is an orphan or a drift between the state of mydocker and
+// the host, e.g. a mount of the container which isn't running.
+type Problem struct {
+	Object  string
+	Problem string
+	repair  func() error
+}
+
+// Repairable returns true if the problem can be repaired by Repair().
+func (p *Problem) Repairable() bool {
+	return p.repair != nil
+}
+
+func (p *Problem) Repair() error {
+	if p.repair == nil {
+		return fmt.Errorf("the problem can't be repaired automatically")
+	}
+	return p.repair()
+}
+
+// createGracePeriod is how long a dir without config is taken as
+// a container being created rather than a broken one.
+const createGracePeriod = time.Minute
+
+// Check compares the configs of containers, the ip allocations and
+// configs of networks and the counts of images with the host, i.e.
+// the processes, mounts, cgroups, links and iptables rules, and
+// returns the problems found in the order they should be repaired.
+// the containers being created, started or stopped are skipped.
+func Check() ([]*Problem, error) {
+	var problems []*Problem
+	report := func(object, problem string, repair func() error) {
+		problems = append(problems, &Problem{Object: object, Problem: problem, repair: repair})
+	}
+
+	containers, busy, err := checkContainers(report)
+	if err != nil {
+		return nil, err
+	}
+
+	// the containers whose processes are alive, which are
+	// expected to have mounts, cgroups, veths and port maps.
+	running := map[string]*Container{}
+	var endpoints, runningEndpoints, busyEndpoints []*network.Endpoint
+	for _, c := range containers {
+		endpoints = append(endpoints, c.Endpoints...)
+		if busy[c.Uuid] != nil {
+			busyEndpoints = append(busyEndpoints, c.Endpoints...)
+			continue
+		}
+		if (c.Status == Running || c.Status == Paused) && c.Cgroups.Pid > 0 {
+			running[c.Uuid] = c
+			runningEndpoints = append(runningEndpoints, c.Endpoints...)
+		}
+	}
+
+	// the ips and images of the containers without configs yet
+	// are allocated and counted, but not recorded anywhere.
+	settled := true
+	for _, c := range busy {
+		if c == nil {
+			settled = false
+		}
+	}
+
+	if err := checkMounts(containers, running, busy, report); err != nil {
+		return nil, err
+	}
+	if err := checkCgroups(running, busy, report); err != nil {
+		return nil, err
+	}
+	if err := network.Check(endpoints, runningEndpoints, busyEndpoints, settled, report); err != nil {
+		return nil, err
+	}
+	if err := checkImages(containers, settled, report); err != nil {
+		return nil, err
+	}
+
+	return problems, nil
+}
+
+// checkContainers reads the configs of containers without fixing their
+// status like Load() does, and returns the containers loaded, and the
+// busy ones, i.e. the ones locked by others, or being created, which
+// are nil if they have no config yet.
+func checkContainers(report network.Report) ([]*Container, map[string]*Container, error) {
+	busy := map[string]*Container{}
+	dirs, err := ioutil.ReadDir(ContainersDir)
+	if os.IsNotExist(err) {
+		return nil, busy, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read dir %s: %v", ContainersDir, err)
+	}
+
+	var containers []*Container
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		uuid := dir.Name()
+		c, isBusy, err := checkContainer(uuid, dir.ModTime(), report)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isBusy {
+			busy[uuid] = c
+		}
+		if c != nil {
+			containers = append(containers, c)
+		}
+	}
+
+	return containers, busy, nil
+}
+
+// checkContainer checks the container of uuid while holding its lock,
+// returns true if it's busy, and nil if it has no valid config.
+func checkContainer(uuid string, modTime time.Time, report network.Report) (*Container, bool, error) {
+	containerDir := path.Join(ContainersDir, uuid)
+	configFileName := path.Join(containerDir, ConfigName)
+	if exist, _ := util.FileOrDirExists(configFileName); !exist {
+		// the config of a new container is written after its
+		// dir is created, the rootfs mounted and ips allocated.
+		if time.Since(modTime) < createGracePeriod {
+			return nil, true, nil
+		}
+		report("container "+uuid, "the dir has no config.json", func() error {
+			if exist, _ := util.FileOrDirExists(configFileName); exist {
+				return nil
+			}
+			return removeContainerDir(containerDir)
+		})
+		return nil, false, nil
+	}
+
+	unlock, ok, err := util.TryLockFile(configFileName)
+	if err != nil {
+		return nil, false, err
+	}
+	if ok {
+		defer unlock()
+	}
+
+	contents, err := ioutil.ReadFile(configFileName)
+	if os.IsNotExist(err) {
+		// removed by others just now.
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read config of container %s: %v", uuid, err)
+	}
+	if !json.Valid(contents) {
+		report("container "+uuid, "the dir has no valid config.json",
+			whileLocked(uuid, func() error { return removeContainerDir(containerDir) }))
+		return nil, false, nil
+	}
+
+	c := &Container{}
+	if err := json.Unmarshal(contents, c); err != nil {
+		report("container "+uuid, fmt.Sprintf("failed to load config: %v", err), nil)
+		return nil, false, nil
+	}
+	// the config is still read by the busy container, since
+	// its endpoints and image are in use anyway.
+	if !ok || c.Status == Creating {
+		return c, true, nil
+	}
+
+	if c.Status != Running && c.Status != Paused {
+		return c, false, nil
+	}
+	// the shim is cleaning up the container.
+	if c.processExists() || c.shimIsAlive() {
+		return c, false, nil
+	}
+
+	report("container "+c.Name, fmt.Sprintf("the container is running but "+
+		"its process %d doesn't exist", c.Cgroups.Pid), func() error {
+		unlock, err := c.lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		// it's been stopped or restarted since it's checked.
+		if c.Status != Running && c.Status != Paused ||
+			c.processExists() || c.shimIsAlive() {
+			return nil
+		}
+		c.recordLost()
+		return c.Dump()
+	})
+	// the container is checked as a stopped one from now on.
+	c.Cgroups.Pid = 0
+	return c, false, nil
+}
+
+// whileLocked returns the repair calling repair with the container of
+// uuid locked, if it has a config, repair isn't called if the container
+// has been started by others since it's checked.
+func whileLocked(uuid string, repair func() error) func() error {
+	return func() error {
+		configFileName := path.Join(ContainersDir, uuid, ConfigName)
+		if exist, _ := util.FileOrDirExists(configFileName); !exist {
+			return repair()
+		}
+
+		unlock, err := util.LockFile(configFileName)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		c := &Container{Uuid: uuid}
+		contents, err := ioutil.ReadFile(configFileName)
+		if err != nil || json.Unmarshal(contents, c) != nil || c.Cgroups == nil {
+			return repair()
+		}
+		switch {
+		case c.Status == Creating:
+		case (c.Status == Running || c.Status == Paused) && c.processExists():
+		case c.Status == Restarting && c.shimIsAlive():
+		default:
+			return repair()
+		}
+		return nil
+	}
+}
+
+// removeContainerDir removes the dir of a broken container,
+// the mounts under the dir are unmounted before removing it.
+func removeContainerDir(containerDir string) error {
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+	for _, mountPoint := range mountPoints {
+		if strings.HasPrefix(mountPoint, containerDir+"/") {
+			if err := util.Umount(mountPoint); err != nil {
+				return err
+			}
+		}
+	}
+	return os.RemoveAll(containerDir)
+}
+
+func checkMounts(containers []*Container, running, busy map[string]*Container, report network.Report) error {
+	mountPoints, err := readMountPoints()
+	if err != nil {
+		return err
+	}
+
+	mounted := map[string]bool{}
+	for _, mountPoint := range mountPoints {
+		mounted[mountPoint] = true
+		rel := strings.TrimPrefix(mountPoint, ContainersDir+"/")
+		if rel == mountPoint {
+			continue
+		}
+
+		uuid := strings.Split(rel, "/")[0]
+		if _, ok := running[uuid]; ok {
+			continue
+		}
+		if _, ok := busy[uuid]; ok {
+			continue
+		}
+		mountPoint := mountPoint
+		report("mount "+mountPoint, "the container of the mount isn't running",
+			whileLocked(uuid, func() error { return util.Umount(mountPoint) }))
+	}
+
+	for _, c := range running {
+		if !mounted[c.Rootfs.MergeDir] {
+			report("container "+c.Name, fmt.Sprintf("the rootfs %s isn't mounted, "+
+				"please restart the container", c.Rootfs.MergeDir), nil)
+		}
+	}
+
+	return nil
+}
+
+// readMountPoints returns the mount points of the current mount
+// namespace, the nested ones are in front of their parents.
+func readMountPoints() ([]string, error) {
+	file, err := os.Open(MountInfoFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", MountInfoFile, err)
+	}
+	defer file.Close()
+
+	// e.g. 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+	var mountPoints []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		// the spaces and tabs in the paths are escaped as octal.
+		mountPoint := strings.NewReplacer(`\040`, " ", `\011`, "\t",
+			`\012`, "\n", `\134`, `\`).Replace(fields[4])
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", MountInfoFile, err)
+	}
+
+	sort.Slice(mountPoints, func(i, j int) bool {
+		return len(mountPoints[i]) > len(mountPoints[j])
+	})
+	return mountPoints, nil
+}
+
+func checkCgroups(running, busy map[string]*Container, report network.Report) error {
+	parent := "/" + MyDocker
+	children, err := cgroups.Children(parent)
+	if err != nil {
+		return err
+	}
+
+	for _, uuid := range children {
+		if _, ok := running[uuid]; ok {
+			continue
+		}
+		if _, ok := busy[uuid]; ok {
+			continue
+		}
+		cg := &cgroups.Cgroups{Path: path.Join(parent, uuid)}
+		report("cgroup "+cg.Path, "the container of the cgroup isn't running",
+			whileLocked(uuid, cg.Destory))
+	}
+
+	return nil
+}
+
+// checkImages checks the counts of images, and the images used by
+// containers, which maybe deleted by old versions of mydocker.
+func checkImages(containers []*Container, settled bool, report network.Report) error {
+	if err := image.Load(); err != nil {
+		return err
+	}
+	refs := countImageRefs(containers)
+
+	uuids := map[string]bool{}
+	for _, img := range image.Images {
+		uuids[img.Uuid] = true
+		// the images of the containers being created are counted,
+		// but they can't be told from the leaked counts.
+		if img.Counts != refs[img.Uuid] && (settled || img.Counts < refs[img.Uuid]) {
+			report("image "+img.RepoTag, fmt.Sprintf("the counts is %d but %d "+
+				"containers use it", img.Counts, refs[img.Uuid]), image.UpdateCounts)
+		}
+	}
+
+	for _, c := range containers {
+		if c.ImageUuid != "" && !uuids[c.ImageUuid] {
+			report("container "+c.Name, fmt.Sprintf("the image %s (%s) doesn't exist",
+				c.Image, c.ImageUuid), nil)
+		}
+	}
+
+	return nil
+}
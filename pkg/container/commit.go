@@ -0,0 +1,115 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"weike.sh/mydocker/pkg/image"
+)
+
+// the files generated by mydocker in the writable layer, which
+// are the configs of the container but not its contents.
+var generatedFiles = []string{"etc/hosts", "etc/hostname", "etc/resolv.conf"}
+
+// Commit creates a new image from the container's changes, i.e. its
+// writable layer is stacked onto the layers of the container's image.
+// the image config is inherited and overridden by the changes.
+func (c *Container) Commit(repoTag string, changes []string, comment string) (*image.Image, error) {
+	img, err := c.getImage()
+	if err != nil {
+		return nil, err
+	}
+
+	baseConfig, baseContents, err := img.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	layer, created, err := c.createLayer(img)
+	if err != nil {
+		return nil, err
+	}
+
+	config := baseConfig.Config
+	// the command line args of `mydocker run` override the Cmd.
+	if len(c.Commands) >= len(config.Entrypoint) &&
+		strings.Join(c.Commands[:len(config.Entrypoint)], "\u0000") == strings.Join(config.Entrypoint, "\u0000") {
+		config.Cmd = c.Commands[len(config.Entrypoint):]
+	}
+
+	var envs []string
+	for key, value := range c.Envs {
+		envs = append(envs, key+"="+value)
+	}
+	sort.Strings(envs)
+	config.Env = image.MergeEnvs(config.Env, envs)
+
+	if err := image.ApplyChanges(config, changes); err != nil {
+		return nil, err
+	}
+
+	createdBy := "mydocker commit " + strings.Join(c.Commands, " ")
+	contents, err := image.NewConfig(baseContents, config, layer.DiffID, createdBy, comment)
+	if err != nil {
+		return nil, err
+	}
+
+	if repoTag == "" {
+		repoTag = image.NoneRepoTag
+	}
+	newImg, err := image.CreateImage(repoTag, contents)
+	if err != nil {
+		// the layer isn't referenced if it's created just now.
+		if created {
+			image.ReleaseLayers([]string{layer.ChainID})
+		}
+		return nil, err
+	}
+
+	return newImg, nil
+}
+
+// CommitLayer creates a new layer from the container's writable layer,
+// which is stacked onto the layers of the container's image, but no
+// image is created, e.g. the layers of build steps. it returns true if
+// the layer is created by it rather than existing, see image.CreateLayer.
+func (c *Container) CommitLayer() (*image.Layer, bool, error) {
+	img, err := c.getImage()
+	if err != nil {
+		return nil, false, err
+	}
+	return c.createLayer(img)
+}
+
+// createLayer packs the writable layer of the container, and
+// creates it in the layer store onto the layers of the image.
+func (c *Container) createLayer(img *image.Image) (*image.Layer, bool, error) {
+	if err := os.MkdirAll(image.LayersDir, 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to mkdir %s: %v", image.LayersDir, err)
+	}
+
+	tmpFile, err := ioutil.TempFile(image.LayersDir, "commit-")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp file for committing: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// the whiteouts in the writable layer are created by the driver.
+	whiteout := DriverWhiteouts[c.StorageDriver]
+	if err := image.PackLayer(c.Rootfs.WriteDir, tmpFile, whiteout, generatedFiles...); err != nil {
+		return nil, false, err
+	}
+	if _, err := tmpFile.Seek(0, 0); err != nil {
+		return nil, false, err
+	}
+
+	parent := ""
+	if len(img.Layers) > 0 {
+		parent = img.Layers[len(img.Layers)-1]
+	}
+	return image.CreateLayer(parent, "", tmpFile)
+}
@@ -3,6 +3,8 @@ package container
 import (
 	"path"
 	"syscall"
+
+	"weike.sh/mydocker/pkg/image"
 )
 
 const (
@@ -10,14 +12,35 @@ const (
 	MyDockerDir = "/var/lib/mydocker"
 	ConfigName  = "config.json"
 	LogName     = "container.log"
-	XinoTmpfs   = "/var/local/xino"
+	ShimLogName = "shim.log"
+	// the waiters of the container lock it shared, see Wait().
+	WaitName  = "wait"
+	XinoTmpfs = "/var/local/xino"
 )
 
+// the envs passing the working dir and user of the container
+// to the init process, which are unset before the user's process.
 const (
-	Creating = "creating"
-	Running  = "running"
-	Stopped  = "stopped"
-	Exited   = "exited"
+	EnvWorkDir = "mydocker_workdir"
+	EnvUser    = "mydocker_user"
+)
+
+const (
+	Creating   = "creating"
+	Created    = "created"
+	Running    = "running"
+	Paused     = "paused"
+	Stopped    = "stopped"
+	Exited     = "exited"
+	Restarting = "restarting"
+)
+
+// the restart policies of containers.
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
 )
 
 const (
@@ -26,6 +49,8 @@ const (
 	Restart = "restart"
 	Create  = "create"
 	Delete  = "delete"
+	Pause   = "pause"
+	Unpause = "unpause"
 )
 
 const (
@@ -56,6 +81,13 @@ var (
 		Overlay2: &Overlay2Driver{},
 	}
 
+	// key is driver's name, value is the whiteout
+	// format of image layers understood by the driver.
+	DriverWhiteouts = map[string]string{
+		Aufs:     image.WhiteoutAufs,
+		Overlay2: image.WhiteoutOverlay,
+	}
+
 	defaultHostsLines = []string{
 		"127.0.0.1 localhost",
 		"::1 localhost ip6-localhost ip6-loopback",
@@ -8,64 +8,288 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
-	"weike.sh/mydocker/pkg/image"
 	"weike.sh/mydocker/pkg/network"
 	_ "weike.sh/mydocker/pkg/nsenter"
 	"weike.sh/mydocker/util"
 )
 
 func (c *Container) Run() error {
-	parentCmd, writePipe, err := c.NewParentProcess()
+	defer c.releaseImages()
+
+	unlock, err := c.lockStarting()
+	if err != nil {
+		return err
+	}
+
+	// the detached container is started by its shim,
+	// which waits for it after `run` returned.
+	if c.Detach {
+		err := c.startShim()
+		unlock()
+		// the config has been written by the shim.
+		c.releaseImages()
+		if err != nil {
+			return err
+		}
+		fmt.Println(c.Uuid)
+		return nil
+	}
+
+	// the container in foreground is waited by `run` itself.
+	c.ShimPid = 0
+	parentCmd, err := c.start()
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	unlock = c.waitLocked(parentCmd)
+	// the exit code is read by the waiters before it's removed.
+	err = c.Dump()
+	unlock()
+	if err != nil {
+		log.Warnf("failed to record the exit code of container %s: %v", c.Uuid, err)
+	}
+	if unlock, err := util.LockFile(path.Join(c.Rootfs.ContainerDir, WaitName)); err == nil {
+		defer unlock()
+	}
+	c.handleNetwork(Delete)
+	c.cleanNetwork()
+	c.Cgroups.Destory()
+	if err := c.cleanupRootfs(); err != nil {
+		return err
+	}
+
+	// the exit code of the container is returned by `run`.
+	if c.ExitCode != 0 {
+		return &ExitError{Code: c.ExitCode}
+	}
+	return nil
+}
+
+// waitLocked waits for the init process of the container run in
+// foreground and records its exit, and returns with the container
+// locked until its exit is dumped, so that the container isn't taken
+// as dead by others, e.g. Load(), after it's reaped but before then.
+func (c *Container) waitLocked(parentCmd *exec.Cmd) func() {
+	if err := util.WaitExited(parentCmd.Process.Pid); err != nil {
+		log.Debugf("failed to wait for the process of container %s: %v", c.Uuid, err)
+	}
+	unlock, err := util.LockFile(path.Join(ContainersDir, c.Uuid, ConfigName))
+	if err != nil {
+		log.Warnf("failed to lock container %s: %v", c.Uuid, err)
+		unlock = func() {}
+	}
+	parentCmd.Wait()
+	c.recordExit(parentCmd.ProcessState)
+	return unlock
+}
+
+// RunAndWait runs the container in foreground like Run, but the
+// container is stopped rather than removed after its process exits,
+// so that its writable layer can be committed, e.g. by build.
+func (c *Container) RunAndWait() error {
+	c.Detach = false
+	err := c.runAttached()
+	if exitErr, ok := err.(*ExitError); ok {
+		return fmt.Errorf("the command `%s` of container %s failed: exit status %d",
+			strings.Join(c.Commands, " "), c.Uuid, exitErr.Code)
+	}
+	return err
+}
+
+// StartAttached starts the container with its stdio attached to the
+// terminal, and waits for it like Run without -d, but the container
+// is stopped rather than removed after its process exits.
+func (c *Container) StartAttached() error {
+	if c.Status == Running || c.Status == Restarting || c.Status == Paused {
+		return fmt.Errorf("the container %s is %s", c.Name, c.Status)
+	}
+	c.RestartCount = 0
+	return c.runAttached()
+}
+
+func (c *Container) runAttached() error {
+	defer c.releaseImages()
+
+	c.ShimPid = 0
+	c.attached = true
+	defer func() { c.attached = false }()
+
+	unlock, err := c.lockStarting()
+	if err != nil {
+		return err
+	}
+	parentCmd, err := c.start()
+	unlock()
 	if err != nil {
 		return err
 	}
 
+	unlock = c.waitLocked(parentCmd)
+	defer unlock()
+	if err := c.handleNetwork(Delete); err != nil {
+		log.Debugf("failed to cleanup networks of container %s: %v", c.Uuid, err)
+	}
+	c.Cgroups.Destory()
+
+	if err := c.umountRootfsVolume(); err != nil {
+		return err
+	}
+
+	if err := c.Dump(); err != nil {
+		return err
+	}
+
+	if c.ExitCode != 0 {
+		return &ExitError{Code: c.ExitCode}
+	}
+	return nil
+}
+
+// Create creates the container without starting it, i.e. its rootfs
+// is created and its config is written with the status created, so
+// that it can be connected to networks or copied files into before
+// it's started by Start() or StartAttached().
+func (c *Container) Create() (err error) {
+	defer c.releaseImages()
+
+	if c.undo == nil {
+		c.undo = &undoLog{}
+	}
+	defer func() {
+		if err != nil {
+			c.undo.rollback()
+		}
+		c.undo = nil
+	}()
+
+	c.undo.add("remove the dir of container", c.deleteRootfs)
+	if err := c.createRootfs(); err != nil {
+		return err
+	}
+	if err := c.configHostname(); err != nil {
+		return err
+	}
+	if err := c.configDNS(); err != nil {
+		return err
+	}
+
+	c.Status = Created
+	if err := c.Dump(); err != nil {
+		return err
+	}
+
+	fmt.Println(c.Uuid)
+	return nil
+}
+
+// start starts the init process of the container, and sets up
+// its cgroups and networks, but doesn't wait it. if any step failed,
+// all the side effects made so far are undone in the reverse order,
+// including the ips allocated by NewContainer for a new container.
+func (c *Container) start() (_ *exec.Cmd, err error) {
+	if c.undo == nil {
+		c.undo = &undoLog{}
+	}
+	var unlock func()
+	defer func() {
+		if err != nil {
+			c.undo.rollback()
+		}
+		c.undo = nil
+		if unlock != nil {
+			unlock()
+		}
+	}()
+
+	// an existing container is kept but marked as stopped, or created
+	// if it's never started, while the dir of a new container is
+	// removed by prepareRootfs().
+	exist, _ := util.FileOrDirExists(c.Rootfs.ContainerDir)
+	if exist {
+		status := Stopped
+		if c.Status == Created {
+			status = Created
+		}
+		c.undo.add("reset the status of container", func() error {
+			c.Cgroups.Pid = 0
+			c.Status = status
+			return c.Dump()
+		})
+	}
+
+	parentCmd, writePipe, err := c.NewParentProcess()
+	if err != nil {
+		return nil, err
+	}
+
 	if parentCmd == nil {
-		return fmt.Errorf("failed to create parent process in container")
+		return nil, fmt.Errorf("failed to create parent process in container")
+	}
+
+	// the existing container is locked by the caller, see lockStarting(),
+	// while the new one is locked once its dir is created, before its
+	// config is written, so that it's never seen by check half-started.
+	if !exist {
+		unlock, err = util.LockFile(path.Join(c.Rootfs.ContainerDir, ConfigName))
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	sendInitCommand(c.Commands, writePipe)
 	if err := parentCmd.Start(); err != nil {
-		return err
+		return nil, err
 	}
+	c.undo.add("kill the init process", func() error {
+		parentCmd.Process.Kill()
+		parentCmd.Wait()
+		return nil
+	})
 
 	c.Cgroups.Pid = parentCmd.Process.Pid
+	c.PidStartTime, _ = util.ProcessStartTime(c.Cgroups.Pid)
 	c.Status = Running
+	c.StartedAt = time.Now().Format("2006-01-02 15:04:05")
+	c.ManuallyStopped = false
+	c.ExitCode = 0
+	c.OOMKilled = false
+	c.Error = ""
 	// util.PrintExeFile(parentCmd.Process.Pid)
 
 	// MUST call c.Dump() after modifying c.Pid
 	if err := c.Dump(); err != nil {
-		return err
+		return nil, err
 	}
 
+	c.undo.add("remove the cgroups", c.Cgroups.Destory)
 	if err := c.Cgroups.Set(); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := c.Cgroups.Apply(); err != nil {
-		return err
+		return nil, err
 	}
 
-	if err := c.handleNetwork(Create); err != nil {
-		if err := image.ChangeCounts(c.Image, "delete"); err != nil {
-			log.Debugf("failed to recover image counts: %v", err)
+	// the endpoints connected are disconnected before the init
+	// process is killed, which needs the netns of the process.
+	pid := c.Cgroups.Pid
+	for _, ep := range c.Endpoints {
+		if err := ep.Connect(pid); err != nil {
+			return nil, err
 		}
-		return err
+		ep := ep
+		c.undo.add("disconnect the endpoint "+ep.Uuid, func() error {
+			return ep.DisConnect(pid)
+		})
 	}
 
-	if !c.Detach {
-		parentCmd.Wait()
-		c.handleNetwork(Delete)
-		c.cleanNetworkImage()
-		c.Cgroups.Destory()
-		return c.cleanupRootfs()
-	} else {
-		fmt.Println(c.Uuid)
-		return nil
-	}
+	return parentCmd, nil
 }
 
 func (c *Container) Logs(ctx *cli.Context) error {
@@ -123,14 +347,53 @@ func (c *Container) Exec(cmdArray []string) error {
 	}
 
 	cmd.Env = append(os.Environ(), containerEnvs...)
-	return cmd.Run()
+	err = cmd.Run()
+	// the exit code of the command is returned by `exec`.
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return &ExitError{Code: exitCodeOf(exitErr.ProcessState)}
+	}
+	return err
 }
 
-func (c *Container) Stop() error {
-	if c.Status == Stopped {
+// Stop stops the container gracefully, i.e. it's killed if it doesn't
+// exit within timeout seconds after receiving the stop signal, the
+// negative timeout means the stop timeout of the container.
+func (c *Container) Stop(timeout int) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status == Restarting {
+		// the shim waiting to restart the container
+		// gives up once it finds the container stopped.
+		c.Status = Stopped
+		c.ManuallyStopped = true
+		if err := c.Dump(); err != nil {
+			return err
+		}
+		fmt.Println(c.Uuid)
+		return nil
+	}
+	if c.Status == Stopped || c.Status == Exited || c.Status == Created {
 		return nil
 	}
+	if c.Status == Paused {
+		if err := c.thaw(); err != nil {
+			return err
+		}
+	}
+
+	if timeout < 0 {
+		timeout = c.stopTimeout()
+	}
+	if err := c.terminate(timeout); err != nil {
+		return err
+	}
 
+	// the networks are kept until the process exits, so that
+	// it can close its connections gracefully.
 	if len(c.Endpoints) > 0 {
 		if err := c.handleNetwork(Delete); err != nil {
 			// just need to record error logs if failed.
@@ -139,16 +402,14 @@ func (c *Container) Stop() error {
 		}
 	}
 
-	if err := util.KillProcess(c.Cgroups.Pid); err != nil {
-		return err
-	}
-
 	if err := c.umountRootfsVolume(); err != nil {
 		return err
 	}
 
 	c.Cgroups.Pid = 0
 	c.Status = Stopped
+	c.ManuallyStopped = true
+	c.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
 	if err := c.Dump(); err != nil {
 		return fmt.Errorf("failed to modify the status of container %s : %v",
 			c.Uuid, err)
@@ -161,15 +422,19 @@ func (c *Container) Stop() error {
 }
 
 func (c *Container) Start() error {
-	if c.Status != Running {
-		return c.Run()
+	if c.Status == Running || c.Status == Restarting {
+		return nil
 	}
-	return nil
+	if c.Status == Paused {
+		return fmt.Errorf("the container %s is paused, unpause it instead", c.Name)
+	}
+	c.RestartCount = 0
+	return c.Run()
 }
 
 func (c *Container) Restart() error {
-	if c.Status == Running {
-		if err := c.Stop(); err != nil {
+	if c.Status == Running || c.Status == Restarting || c.Status == Paused {
+		if err := c.Stop(-1); err != nil {
 			return err
 		}
 	}
@@ -177,13 +442,19 @@ func (c *Container) Restart() error {
 }
 
 func (c *Container) Delete() error {
-	if c.Status == Running {
-		if err := c.Stop(); err != nil {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status == Running || c.Status == Restarting || c.Status == Paused {
+		if err := c.Stop(-1); err != nil {
 			return err
 		}
 	}
 
-	c.cleanNetworkImage()
+	c.cleanNetwork()
 	return c.cleanupRootfs()
 }
 
@@ -199,17 +470,81 @@ func (c *Container) Dump() error {
 			c.Uuid, err)
 	}
 
-	// WriteFile will create the file if it doesn't exist,
-	// otherwise WriteFile will truncate it before writing
-	if err := ioutil.WriteFile(configFileName, jsonBytes, 0644); err != nil {
+	// the config is replaced atomically, since it's read by all
+	// the other mydocker processes, e.g. to count image references.
+	if err := util.WriteFileAtomic(configFileName, jsonBytes, 0644); err != nil {
 		return fmt.Errorf("failed to write container config to file %s: %v",
 			configFileName, err)
 	}
 
+	c.releaseImages()
 	return nil
 }
 
 func (c *Container) Load() error {
+	if err := c.load(); err != nil {
+		return err
+	}
+
+	// the container whose shim is alive is cleaned up by the shim.
+	if !c.isDead() {
+		return nil
+	}
+
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// its exit maybe recorded by others before it's locked.
+	if !c.isDead() {
+		return nil
+	}
+	c.recordLost()
+	return c.Dump()
+}
+
+// isDead returns true if the process of the container doesn't exist,
+// but the container isn't marked as exited by its shim or `run`.
+func (c *Container) isDead() bool {
+	return c.Cgroups.Pid > 0 && !c.shimIsAlive() && !c.processExists()
+}
+
+// lock locks the config of the container across processes, e.g. the
+// shim and `mydocker stop`, and reloads it since it maybe modified.
+func (c *Container) lock() (func(), error) {
+	configFileName := path.Join(ContainersDir, c.Uuid, ConfigName)
+	if exist, _ := util.FileOrDirExists(configFileName); !exist {
+		return nil, fmt.Errorf("no such container: %s", c.Uuid)
+	}
+
+	unlock, err := util.LockFile(configFileName)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.load(); err != nil {
+		unlock()
+		return nil, err
+	}
+	return unlock, nil
+}
+
+// lockStarting locks the existing container while it's being started,
+// which isn't running but may have its rootfs mounted, so that it's
+// skipped by check as a busy one. the shim of the detached container
+// starts it without the lock, which is held by `run` waiting for it.
+func (c *Container) lockStarting() (func(), error) {
+	configFileName := path.Join(ContainersDir, c.Uuid, ConfigName)
+	if exist, _ := util.FileOrDirExists(configFileName); !exist {
+		return func() {}, nil
+	}
+	return util.LockFile(configFileName)
+}
+
+// load reads the config of the container as it is, without
+// marking it as stopped if its process doesn't exist.
+func (c *Container) load() error {
 	configFileName := path.Join(ContainersDir, c.Uuid, ConfigName)
 	if err := util.EnSureFileExists(configFileName); err != nil {
 		return err
@@ -230,21 +565,10 @@ func (c *Container) Load() error {
 			c.Uuid, err)
 	}
 
-	if c.Cgroups.Pid > 0 {
-		processDir := fmt.Sprintf("/proc/%d", c.Cgroups.Pid)
-		if exist, _ := util.FileOrDirExists(processDir); !exist {
-			c.Cgroups.Pid = 0
-			c.Status = Stopped
-			if err := c.Dump(); err != nil {
-				return err
-			}
-		}
-	}
-
 	return nil
 }
 
-func (c *Container) cleanNetworkImage() {
+func (c *Container) cleanNetwork() {
 	for _, ep := range c.Endpoints {
 		nw := ep.Network
 		ip := ep.IPAddr
@@ -253,11 +577,6 @@ func (c *Container) cleanNetworkImage() {
 				ip.String(), c.Uuid, err)
 		}
 	}
-
-	if err := image.ChangeCounts(c.Image, "delete"); err != nil {
-		log.Errorf("failed to decrease counts of image %s: %v",
-			c.Image, err)
-	}
 }
 
 func (c *Container) handleNetwork(action string) error {
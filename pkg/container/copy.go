@@ -0,0 +1,211 @@
+package container
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// CopyFrom copies the file or dir src in the container to dst on the
+// host, if dst is "-", a tarball of src is written to stdout instead.
+func (c *Container) CopyFrom(src, dst string) error {
+	root, cleanup, err := c.copyRoot()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	if dst == "-" {
+		srcPath, _, err := resolveSource(root, src)
+		if err != nil {
+			return err
+		}
+		return util.TarPath(os.Stdout, srcPath, sourceName(src))
+	}
+
+	absDst, err := absPath(dst)
+	if err != nil {
+		return err
+	}
+	return copyPath(root, src, "/", absDst)
+}
+
+// CopyTo copies the file or dir src on the host to dst in the
+// container, if src is "-", a tarball read from stdin is
+// extracted into the dir dst instead.
+func (c *Container) CopyTo(src, dst string) error {
+	root, cleanup, err := c.copyRoot()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// the relative paths in the container are relative to `/`.
+	if !strings.HasPrefix(dst, "/") {
+		dst = "/" + dst
+	}
+
+	if src == "-" {
+		dstPath, err := util.SecureJoin(root, dst)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Stat(dstPath); err != nil || !fi.IsDir() {
+			return fmt.Errorf("the destination %s must be an existing directory", dst)
+		}
+		return util.Untar(os.Stdin, root, dst)
+	}
+
+	absSrc, err := absPath(src)
+	if err != nil {
+		return err
+	}
+	return copyPath("/", absSrc, root, dst)
+}
+
+// WithRootfs calls fn with the rootfs of the container seen by its
+// processes, which is mounted temporarily if the container is stopped.
+func (c *Container) WithRootfs(fn func(root string) error) error {
+	root, cleanup, err := c.copyRoot()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	return fn(root)
+}
+
+// copyRoot returns the rootfs of the container seen by its processes.
+// the rootfs of a running container is accessed through its mount
+// namespace, so that the volumes are respected, while the rootfs
+// and volumes of a stopped container are mounted temporarily.
+func (c *Container) copyRoot() (string, func(), error) {
+	if (c.Status == Running || c.Status == Paused) && c.Cgroups.Pid > 0 {
+		return fmt.Sprintf("/proc/%d/root", c.Cgroups.Pid), func() {}, nil
+	}
+
+	if util.DirIsMounted(c.Rootfs.MergeDir) {
+		return c.Rootfs.MergeDir, func() {}, nil
+	}
+
+	// the dirs of the rootfs don't exist if it's never started.
+	if err := c.createRootfs(); err != nil {
+		return "", nil, err
+	}
+	if err := c.mountRootfsVolume(); err != nil {
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		if err := c.umountRootfsVolume(); err != nil {
+			log.Warnf("failed to umount the rootfs of container %s: %v", c.Uuid, err)
+		}
+	}
+	return c.Rootfs.MergeDir, cleanup, nil
+}
+
+// copyPath copies src in srcRoot to dst in dstRoot, following the
+// semantics of `docker cp`, e.g. `dir/.` means the contents of dir,
+// and `dst/` means dst must be an existing dir.
+func copyPath(srcRoot, src, dstRoot, dst string) error {
+	srcPath, srcInfo, err := resolveSource(srcRoot, src)
+	if err != nil {
+		return err
+	}
+
+	dstPath, err := util.SecureJoin(dstRoot, dst)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// the dir to extract into and the name of the top entry.
+	var dir, name string
+	switch {
+	case dstInfo == nil && strings.HasSuffix(dst, "/") && !srcInfo.IsDir():
+		return fmt.Errorf("the destination directory %s must exist", dst)
+	case dstInfo == nil:
+		dir, name = path.Dir(path.Clean(dst)), path.Base(path.Clean(dst))
+		parent, err := util.SecureJoin(dstRoot, dir)
+		if err != nil {
+			return err
+		}
+		if fi, err := os.Stat(parent); err != nil || !fi.IsDir() {
+			return fmt.Errorf("the parent directory of %s doesn't exist", dst)
+		}
+	case dstInfo.IsDir():
+		dir, name = dst, sourceName(src)
+	case strings.HasSuffix(dst, "/"):
+		return fmt.Errorf("the destination %s is not a directory", dst)
+	case srcInfo.IsDir():
+		return fmt.Errorf("can't copy the directory %s to the file %s", src, dst)
+	default:
+		dir, name = path.Dir(path.Clean(dst)), path.Base(path.Clean(dst))
+	}
+
+	log.Debugf("copying %s to %s as %s", srcPath, path.Join(dstRoot, dir), name)
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(util.TarPath(writer, srcPath, name))
+	}()
+
+	err = util.Untar(reader, dstRoot, dir)
+	reader.CloseWithError(err)
+	return err
+}
+
+// resolveSource resolves src in the scope of root, the symlinks in
+// the parent dirs of src are followed, but src itself is not.
+func resolveSource(root, src string) (string, os.FileInfo, error) {
+	parent, err := util.SecureJoin(root, path.Dir(path.Clean("/"+src)))
+	if err != nil {
+		return "", nil, err
+	}
+	srcPath := path.Join(parent, path.Base(path.Clean("/"+src)))
+
+	srcInfo, err := os.Lstat(srcPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("no such file or directory: %s", src)
+	}
+	if strings.HasSuffix(src, "/") && !srcInfo.IsDir() {
+		return "", nil, fmt.Errorf("the source %s is not a directory", src)
+	}
+
+	return srcPath, srcInfo, nil
+}
+
+// sourceName returns the name of the top entry to copy src, which
+// is "." if only the contents of the dir src should be copied.
+func sourceName(src string) string {
+	name := path.Base(path.Clean("/" + src))
+	if strings.HasSuffix(src, "/.") || src == "." || name == "/" {
+		return "."
+	}
+	return name
+}
+
+// absPath returns the absolute path of the path on the host, the
+// trailing `/` or `/.` is kept, which matters to copyPath.
+func absPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasSuffix(p, "/.") || p == ".":
+		abs += "/."
+	case strings.HasSuffix(p, "/") && abs != "/":
+		abs += "/"
+	}
+	return abs, nil
+}
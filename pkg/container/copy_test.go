@@ -0,0 +1,135 @@
+package container
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// listFiles returns the files in dir with their contents, the dirs
+// are suffixed with "/", e.g. {"dir/": "", "dir/file": "data"}.
+func listFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil || file == dir {
+			return err
+		}
+		name, _ := filepath.Rel(dir, file)
+		if fi.IsDir() {
+			files[name+"/"] = ""
+			return nil
+		}
+		data, err := ioutil.ReadFile(file)
+		files[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func sortedKeys(files map[string]string) []string {
+	var keys []string
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestCopyPath(t *testing.T) {
+	tests := []struct {
+		src string
+		dst string
+		// the files in the dest root after copying, besides the
+		// existing dir and file, i.e. "dir/" and "file".
+		files map[string]string
+		err   string
+	}{
+		// the file is copied as dst if dst doesn't exist,
+		// into dst if it's a dir, or overwrites the file dst.
+		{src: "/src/app.conf", dst: "/new.conf", files: map[string]string{"new.conf": "conf"}},
+		{src: "/src/app.conf", dst: "/dir", files: map[string]string{"dir/app.conf": "conf"}},
+		{src: "/src/app.conf", dst: "/dir/", files: map[string]string{"dir/app.conf": "conf"}},
+		{src: "/src/app.conf", dst: "/file", files: map[string]string{"file": "conf"}},
+		{src: "/src/app.conf", dst: "/missing/", err: "the destination directory /missing/ must exist"},
+		{src: "/src/app.conf", dst: "/file/", err: "the destination /file/ is not a directory"},
+		{src: "/src/app.conf/", dst: "/dir", err: "the source /src/app.conf/ is not a directory"},
+		{src: "/src/missing", dst: "/dir", err: "no such file or directory"},
+		{src: "/src/app.conf", dst: "/missing/app.conf", err: "the parent directory of /missing/app.conf doesn't exist"},
+
+		// the dir is copied as dst if dst doesn't exist, whether
+		// it ends with "/" or not, or into dst if it's a dir.
+		{src: "/src", dst: "/new", files: map[string]string{"new/": "", "new/app.conf": "conf", "new/lib/": "", "new/lib/a.so": "a"}},
+		{src: "/src", dst: "/new/", files: map[string]string{"new/": "", "new/app.conf": "conf", "new/lib/": "", "new/lib/a.so": "a"}},
+		{src: "/src/", dst: "/dir", files: map[string]string{"dir/src/": "", "dir/src/app.conf": "conf", "dir/src/lib/": "", "dir/src/lib/a.so": "a"}},
+		{src: "/src", dst: "/file", err: "can't copy the directory /src to the file /file"},
+
+		// only the contents of the dir are copied with "/.".
+		{src: "/src/.", dst: "/dir", files: map[string]string{"dir/app.conf": "conf", "dir/lib/": "", "dir/lib/a.so": "a"}},
+		{src: "/src/.", dst: "/new", files: map[string]string{"new/": "", "new/app.conf": "conf", "new/lib/": "", "new/lib/a.so": "a"}},
+	}
+
+	for _, test := range tests {
+		srcRoot, err := ioutil.TempDir("", "mydocker-cp-src-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(srcRoot)
+		dstRoot, err := ioutil.TempDir("", "mydocker-cp-dst-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dstRoot)
+
+		for name, contents := range map[string]string{
+			path.Join(srcRoot, "src/app.conf"): "conf",
+			path.Join(srcRoot, "src/lib/a.so"): "a",
+			path.Join(dstRoot, "file"):         "file",
+		} {
+			if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Mkdir(path.Join(dstRoot, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		err = copyPath(srcRoot, test.src, dstRoot, test.dst)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q when copying %s to %s, got %v", test.err, test.src, test.dst, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to copy %s to %s: %v", test.src, test.dst, err)
+			continue
+		}
+
+		expected := map[string]string{"dir/": "", "file": "file"}
+		for name, contents := range test.files {
+			expected[name] = contents
+		}
+		files := listFiles(t, dstRoot)
+		if strings.Join(sortedKeys(files), " ") != strings.Join(sortedKeys(expected), " ") {
+			t.Errorf("expected %v after copying %s to %s, got %v",
+				sortedKeys(expected), test.src, test.dst, sortedKeys(files))
+			continue
+		}
+		for name, contents := range expected {
+			if files[name] != contents {
+				t.Errorf("expected %s to be %q after copying %s to %s, got %q",
+					name, contents, test.src, test.dst, files[name])
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"weike.sh/mydocker/pkg/image"
+	"weike.sh/mydocker/util"
+)
+
+const (
+	ChangeAdd    = "A"
+	ChangeModify = "C"
+	ChangeDelete = "D"
+)
+
+type Change struct {
+	Kind string `json:"Kind"`
+	Path string `json:"Path"`
+}
+
+// Diff returns the changes of the container's rootfs relative to its
+// image, i.e. the files added, changed or deleted in the writable
+// layer, which are sorted by their paths.
+func (c *Container) Diff() ([]*Change, error) {
+	whiteout := DriverWhiteouts[c.StorageDriver]
+	lowerDirs := c.Rootfs.LowerDirs()
+	changes := map[string]string{}
+
+	err := filepath.Walk(c.Rootfs.WriteDir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(c.Rootfs.WriteDir, file)
+		if err != nil {
+			return err
+		}
+		if name == "." || util.Contains(generatedFiles, name) {
+			return nil
+		}
+
+		parent, base := path.Split("/" + name)
+		switch whiteout {
+		case image.WhiteoutOverlay:
+			if isOverlayWhiteout(fi) {
+				changes["/"+name] = ChangeDelete
+				return nil
+			}
+		case image.WhiteoutAufs:
+			if base == image.WhiteoutOpaque {
+				return nil
+			}
+			// the aufs metadata files, e.g. .wh..wh.plnk
+			if strings.HasPrefix(base, image.WhiteoutPrefix+image.WhiteoutPrefix) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasPrefix(base, image.WhiteoutPrefix) {
+				deleted := path.Join(parent, strings.TrimPrefix(base, image.WhiteoutPrefix))
+				changes[deleted] = ChangeDelete
+				return nil
+			}
+		}
+
+		if !existsInLower(lowerDirs, name, whiteout) {
+			changes["/"+name] = ChangeAdd
+			return nil
+		}
+		changes["/"+name] = ChangeModify
+
+		// the files of lower layers are hidden by the opaque dir.
+		if fi.IsDir() && isOpaqueDir(file, whiteout) {
+			for _, child := range lowerChildren(lowerDirs, name, whiteout) {
+				upper := path.Join(file, child)
+				if _, err := os.Lstat(upper); os.IsNotExist(err) {
+					changes[path.Join("/", name, child)] = ChangeDelete
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk the writable layer of container %s: %v",
+			c.Uuid, err)
+	}
+
+	var sorted []*Change
+	for name, kind := range changes {
+		sorted = append(sorted, &Change{Kind: kind, Path: name})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Path < sorted[j].Path
+	})
+
+	return sorted, nil
+}
+
+// existsInLower returns true if the file named name is visible in
+// the union of lowerDirs, which are ordered from top to bottom.
+func existsInLower(lowerDirs []string, name, whiteout string) bool {
+	for _, dir := range lowerDirs {
+		if fi, err := os.Lstat(path.Join(dir, name)); err == nil {
+			return !(whiteout == image.WhiteoutOverlay && isOverlayWhiteout(fi))
+		}
+
+		// the file of lower layers is hidden if it or any of its
+		// ancestors is deleted, or any ancestor is opaque.
+		for ancestor := name; ancestor != "."; ancestor = path.Dir(ancestor) {
+			if isWhiteout(dir, ancestor, whiteout) {
+				return false
+			}
+			if ancestor != name && isOpaqueDir(path.Join(dir, ancestor), whiteout) {
+				return false
+			}
+		}
+	}
+	return false
+}
+
+// lowerChildren returns the names of children visible in the
+// dir named name of the union of lowerDirs.
+func lowerChildren(lowerDirs []string, name, whiteout string) []string {
+	var children []string
+	for _, dir := range lowerDirs {
+		infos, err := ioutil.ReadDir(path.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		for _, info := range infos {
+			child := info.Name()
+			if strings.HasPrefix(child, image.WhiteoutPrefix) || util.Contains(children, child) {
+				continue
+			}
+			if existsInLower(lowerDirs, path.Join(name, child), whiteout) {
+				children = append(children, child)
+			}
+		}
+	}
+	return children
+}
+
+func isOverlayWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+// isWhiteout returns true if the file named name is deleted in the layer dir.
+func isWhiteout(dir, name, whiteout string) bool {
+	switch whiteout {
+	case image.WhiteoutOverlay:
+		fi, err := os.Lstat(path.Join(dir, name))
+		return err == nil && isOverlayWhiteout(fi)
+	case image.WhiteoutAufs:
+		parent, base := path.Split(name)
+		exist, _ := util.FileOrDirExists(path.Join(dir, parent, image.WhiteoutPrefix+base))
+		return exist
+	}
+	return false
+}
+
+func isOpaqueDir(dir, whiteout string) bool {
+	switch whiteout {
+	case image.WhiteoutOverlay:
+		return image.IsOpaque(dir)
+	case image.WhiteoutAufs:
+		exist, _ := util.FileOrDirExists(path.Join(dir, image.WhiteoutOpaque))
+		return exist
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package container
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"weike.sh/mydocker/pkg/image"
+)
+
+// setupLayers creates the dirs of the image's layers and the writable
+// layer with the files, whose contents are written as they are, except
+// the ones named "<dir>" and "<whiteout>", e.g. "upper/etc/": "<dir>".
+func setupLayers(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "mydocker-diff-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	for name, contents := range files {
+		file := path.Join(dir, name)
+		if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		switch contents {
+		case "<dir>":
+			err = os.MkdirAll(file, 0755)
+		case "<whiteout>":
+			err = syscall.Mknod(file, syscall.S_IFCHR, 0)
+		default:
+			err = ioutil.WriteFile(file, []byte(contents), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func checkDiff(t *testing.T, c *Container, expected string) {
+	changes, err := c.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonBytes, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(jsonBytes) != expected {
+		t.Errorf("unexpected changes of %s:\nexpected %s\ngot      %s", c.StorageDriver, expected, jsonBytes)
+	}
+}
+
+func TestDiffOverlay(t *testing.T) {
+	// the whiteouts of overlay are char devices and trusted xattrs.
+	if os.Geteuid() != 0 {
+		t.Skip("creating overlay whiteouts requires root")
+	}
+
+	dir := setupLayers(t, map[string]string{
+		"bottom/etc/passwd":     "root",
+		"bottom/etc/deleted":    "deleted",
+		"bottom/etc/readded":    "old",
+		"bottom/opt/app/old":    "old",
+		"bottom/opt/app/lib/a":  "a",
+		"bottom/var/log/":       "<dir>",
+		"top/etc/readded":       "<whiteout>",
+		"top/opt/app/kept":      "kept",
+		"upper/etc/hosts":       "127.0.0.1 localhost",
+		"upper/etc/passwd":      "root\nuser",
+		"upper/etc/deleted":     "<whiteout>",
+		"upper/etc/readded":     "new",
+		"upper/opt/app/new":     "new",
+		"upper/opt/app/kept":    "kept",
+		"upper/tmp/cache/file":  "cache",
+		"upper/var/log/deleted": "<whiteout>",
+	})
+	if err := syscall.Setxattr(path.Join(dir, "upper/opt/app"), image.OverlayOpaque, []byte("y"), 0); err != nil {
+		t.Skipf("the fs doesn't support trusted xattrs: %v", err)
+	}
+
+	c := &Container{
+		StorageDriver: Overlay2,
+		Rootfs: &Rootfs{
+			ImageDirs: []string{path.Join(dir, "top"), path.Join(dir, "bottom")},
+			WriteDir:  path.Join(dir, "upper"),
+		},
+	}
+	// the generated /etc/hosts is skipped, the file deleted in a lower
+	// layer is added again, and the whiteout of a file which doesn't
+	// exist in the lower layers is still a deletion, like docker.
+	checkDiff(t, c, `[{"Kind":"C","Path":"/etc"},`+
+		`{"Kind":"D","Path":"/etc/deleted"},`+
+		`{"Kind":"C","Path":"/etc/passwd"},`+
+		`{"Kind":"A","Path":"/etc/readded"},`+
+		`{"Kind":"C","Path":"/opt"},`+
+		`{"Kind":"C","Path":"/opt/app"},`+
+		`{"Kind":"C","Path":"/opt/app/kept"},`+
+		`{"Kind":"D","Path":"/opt/app/lib"},`+
+		`{"Kind":"A","Path":"/opt/app/new"},`+
+		`{"Kind":"D","Path":"/opt/app/old"},`+
+		`{"Kind":"A","Path":"/tmp"},`+
+		`{"Kind":"A","Path":"/tmp/cache"},`+
+		`{"Kind":"A","Path":"/tmp/cache/file"},`+
+		`{"Kind":"C","Path":"/var"},`+
+		`{"Kind":"C","Path":"/var/log"},`+
+		`{"Kind":"D","Path":"/var/log/deleted"}]`)
+}
+
+func TestDiffAufs(t *testing.T) {
+	dir := setupLayers(t, map[string]string{
+		"image/etc/passwd":             "root",
+		"image/etc/deleted":            "deleted",
+		"image/opt/app/old":            "old",
+		"image/opt/app/kept":           "kept",
+		"upper/etc/passwd":             "root\nuser",
+		"upper/etc/.wh.deleted":        "",
+		"upper/opt/app/.wh..wh..opq":   "",
+		"upper/opt/app/new":            "new",
+		"upper/opt/app/kept":           "kept",
+		"upper/.wh..wh.plnk/12.34":     "",
+		"upper/.wh..wh.aufs":           "",
+		"upper/tmp/.wh.never-existed":  "",
+		"upper/tmp/cache/file":         "cache",
+		"upper/etc/resolv.conf":        "nameserver 8.8.8.8",
+		"upper/etc/hostname":           "c1",
+		"upper/etc/not-generated/file": "data",
+	})
+
+	c := &Container{
+		StorageDriver: Aufs,
+		Rootfs: &Rootfs{
+			ImageDir: path.Join(dir, "image"),
+			WriteDir: path.Join(dir, "upper"),
+		},
+	}
+	// the aufs metadata files and the generated files are skipped.
+	checkDiff(t, c, `[{"Kind":"C","Path":"/etc"},`+
+		`{"Kind":"D","Path":"/etc/deleted"},`+
+		`{"Kind":"A","Path":"/etc/not-generated"},`+
+		`{"Kind":"A","Path":"/etc/not-generated/file"},`+
+		`{"Kind":"C","Path":"/etc/passwd"},`+
+		`{"Kind":"C","Path":"/opt"},`+
+		`{"Kind":"C","Path":"/opt/app"},`+
+		`{"Kind":"C","Path":"/opt/app/kept"},`+
+		`{"Kind":"A","Path":"/opt/app/new"},`+
+		`{"Kind":"D","Path":"/opt/app/old"},`+
+		`{"Kind":"A","Path":"/tmp"},`+
+		`{"Kind":"A","Path":"/tmp/cache"},`+
+		`{"Kind":"A","Path":"/tmp/cache/file"},`+
+		`{"Kind":"D","Path":"/tmp/never-existed"}]`)
+}
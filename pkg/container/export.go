@@ -0,0 +1,32 @@
+package container
+
+import (
+	"io"
+	"path"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// Export writes the merged view of the container's rootfs into w as
+// a flat tarball, the contents of volumes are excluded like docker.
+func (c *Container) Export(w io.Writer) error {
+	if !util.DirIsMounted(c.Rootfs.MergeDir) {
+		// only the rootfs is needed, so the volumes aren't mounted.
+		if err := Drivers[c.StorageDriver].MountRootfs(c); err != nil {
+			return err
+		}
+		defer func() {
+			if err := util.Umount(c.Rootfs.MergeDir); err != nil {
+				log.Warnf("failed to umount the rootfs of container %s: %v", c.Uuid, err)
+			}
+		}()
+	}
+
+	var excludes []string
+	for _, target := range c.Volumes {
+		excludes = append(excludes, path.Clean(target))
+	}
+
+	return util.TarPath(w, c.Rootfs.MergeDir, ".", excludes...)
+}
@@ -28,6 +28,8 @@ func RunContainerInitProcess() error {
 		createDevSymlinks,
 		mountCgroups,
 		setHostname,
+		setWorkingDir,
+		setUser,
 	}
 
 	for _, initFunc := range initFuncs {
@@ -230,3 +232,49 @@ func setHostname() error {
 
 	return nil
 }
+
+func setWorkingDir() error {
+	workDir := os.Getenv(EnvWorkDir)
+	os.Unsetenv(EnvWorkDir)
+	if workDir == "" {
+		return nil
+	}
+
+	// docker creates the working dir if it doesn't exist.
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir the working dir %s: %v", workDir, err)
+	}
+	if err := syscall.Chdir(workDir); err != nil {
+		return fmt.Errorf("failed to chdir to the working dir %s: %v", workDir, err)
+	}
+
+	return nil
+}
+
+// setUser switches to the user of the container, MUST be the last
+// init function, since the others need the privileges of root.
+func setUser() error {
+	spec := os.Getenv(EnvUser)
+	os.Unsetenv(EnvUser)
+	if spec == "" {
+		return nil
+	}
+
+	u, err := util.LookupUser("/", spec)
+	if err != nil {
+		return err
+	}
+	log.Debugf("switch to the user %s (uid: %d, gid: %d)", spec, u.Uid, u.Gid)
+
+	if err := syscall.Setgroups(u.Groups); err != nil {
+		return fmt.Errorf("failed to set the groups of user %s: %v", spec, err)
+	}
+	if err := syscall.Setgid(u.Gid); err != nil {
+		return fmt.Errorf("failed to set the gid of user %s: %v", spec, err)
+	}
+	if err := syscall.Setuid(u.Uid); err != nil {
+		return fmt.Errorf("failed to set the uid of user %s: %v", spec, err)
+	}
+
+	return os.Setenv("HOME", u.Home)
+}
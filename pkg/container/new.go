@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Pallinder/go-randomdata"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"github.com/vishvananda/netlink"
 	"weike.sh/mydocker/pkg/cgroups"
@@ -18,7 +19,7 @@ import (
 	"weike.sh/mydocker/util"
 )
 
-func NewContainer(ctx *cli.Context) (*Container, error) {
+func NewContainer(ctx *cli.Context) (_ *Container, err error) {
 	detach := ctx.Bool("detach")
 
 	name := ctx.String("name")
@@ -45,10 +46,25 @@ func NewContainer(ctx *cli.Context) (*Container, error) {
 		return nil, fmt.Errorf("the image name is required")
 	}
 
+	// the references of images are counted by the configs of containers,
+	// so the image is locked until the config of the container is written.
+	unlockImages, err := image.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			unlockImages()
+		}
+	}()
+
 	img, err := image.GetImageByNameOrUuid(imgNameOrUuid)
 	if err != nil {
 		return nil, err
 	}
+	if err := checkPlatform(img, ctx.String("platform")); err != nil {
+		return nil, err
+	}
 
 	var commands []string
 	if len(img.Entrypoint) > 0 {
@@ -64,6 +80,30 @@ func NewContainer(ctx *cli.Context) (*Container, error) {
 		return nil, fmt.Errorf("missing container commands")
 	}
 
+	restartPolicy, err := ParseRestartPolicy(ctx.String("restart"))
+	if err != nil {
+		return nil, err
+	}
+	// the container in foreground is removed after it exits.
+	if restartPolicy != nil && !detach {
+		return nil, fmt.Errorf("the restart policy only works with detached containers")
+	}
+
+	// the stop signal of the image is used unless it's given.
+	stopSignal := ctx.String("stop-signal")
+	if stopSignal == "" {
+		stopSignal = img.StopSignal
+	}
+	if stopSignal != "" {
+		if _, err := util.ParseSignal(stopSignal); err != nil {
+			return nil, fmt.Errorf("invalid --stop-signal: %v", err)
+		}
+	}
+	stopTimeout := ctx.Int("stop-timeout")
+	if stopTimeout < 0 {
+		return nil, fmt.Errorf("--stop-timeout must be >= 0")
+	}
+
 	storageDriver := ctx.String("storage-driver")
 	driverConfig, ok := DriverConfigs[storageDriver]
 	if !ok {
@@ -76,9 +116,20 @@ func NewContainer(ctx *cli.Context) (*Container, error) {
 			"kernel-4.0.0+", storageDriver)
 	}
 
+	// the whiteouts in image layers must be understood by the driver.
+	if whiteout := img.Whiteout(); whiteout != "" && whiteout != DriverWhiteouts[storageDriver] {
+		return nil, fmt.Errorf("the layers of image %s are extracted for %s, "+
+			"which can't be mounted by the driver %s", img.RepoTag, whiteout, storageDriver)
+	}
+
+	imageDirs, err := img.LowerDirs()
+	if err != nil {
+		return nil, err
+	}
+
 	rootfs := &Rootfs{
 		ContainerDir: path.Join(ContainersDir, uuid),
-		ImageDir:     img.RootDir(),
+		ImageDirs:    imageDirs,
 		WriteDir:     path.Join(ContainersDir, uuid, driverConfig["writeDir"]),
 		MergeDir:     path.Join(ContainersDir, uuid, driverConfig["mergeDir"]),
 	}
@@ -133,23 +184,25 @@ func NewContainer(ctx *cli.Context) (*Container, error) {
 		}
 	}
 
-	if err := image.ChangeCounts(img.RepoTag, "create"); err != nil {
-		return nil, err
-	}
-
-	return &Container{
+	c := &Container{
 		Detach:        detach,
 		Uuid:          uuid,
 		Name:          name,
 		Hostname:      hostname,
 		Dns:           dns,
 		Image:         imgNameOrUuid,
+		ImageUuid:     img.Uuid,
 		Commands:      commands,
+		WorkingDir:    img.WorkingDir,
+		User:          img.User,
 		Rootfs:        rootfs,
 		Volumes:       volumes,
 		Envs:          envs,
 		Ports:         ports,
 		Endpoints:     endpoints,
+		RestartPolicy: restartPolicy,
+		StopSignal:    stopSignal,
+		StopTimeout:   &stopTimeout,
 		Status:        Creating,
 		CreateTime:    time.Now().Format("2006-01-02 15:04:05"),
 		StorageDriver: storageDriver,
@@ -157,7 +210,27 @@ func NewContainer(ctx *cli.Context) (*Container, error) {
 			Path:      fmt.Sprintf("/%s/%s", MyDocker, uuid),
 			Resources: resources,
 		},
-	}, nil
+		undo:         &undoLog{},
+		unlockImages: unlockImages,
+	}
+
+	// the ips allocated for the container are released if it
+	// failed to be started, e.g. the image's rootfs is broken.
+	c.undo.add("release the ips of endpoints", func() error {
+		c.cleanNetwork()
+		return nil
+	})
+	return c, nil
+}
+
+// releaseImages releases the lock of images held since NewContainer,
+// once the config of the container is written, or it failed to be
+// created, so that its image can be deleted from now on.
+func (c *Container) releaseImages() {
+	if c.unlockImages != nil {
+		c.unlockImages()
+		c.unlockImages = nil
+	}
 }
 
 func parsePortMaps(ctx *cli.Context) (map[string]string, error) {
@@ -209,21 +282,24 @@ func CreateEndpoints(cName string, nwNames []string, ports map[string]string) ([
 	for _, nwName := range nwNames {
 		nw, ok := network.Networks[nwName]
 		if !ok {
+			releaseIPs(endpoints)
 			return nil, fmt.Errorf("no such network %s, please create it first", nwName)
 		}
 
-		ipaddr, err := network.IPAllocator.Allocate(nw)
-		if err != nil {
-			return nil, fmt.Errorf("failed to allocate new ip from the network %s: %v",
-				nwName, err)
-		}
-
 		br, err := netlink.LinkByName(nw.Name)
 		if err != nil {
+			releaseIPs(endpoints)
 			return nil, fmt.Errorf("failed to get the bridge of the network %s: %v",
 				nw.Name, err)
 		}
 
+		ipaddr, err := network.IPAllocator.Allocate(nw)
+		if err != nil {
+			releaseIPs(endpoints)
+			return nil, fmt.Errorf("failed to allocate new ip from the network %s: %v",
+				nwName, err)
+		}
+
 		// the sha256sum has 64 decimal digits.
 		hashed := util.Sha256Sum(nwName + "/" + cName)
 
@@ -246,3 +322,13 @@ func CreateEndpoints(cName string, nwNames []string, ports map[string]string) ([
 
 	return endpoints, nil
 }
+
+// releaseIPs releases the ips of the endpoints created so far,
+// when failed to create all the endpoints of a container.
+func releaseIPs(endpoints []*network.Endpoint) {
+	for _, ep := range endpoints {
+		if err := network.IPAllocator.Release(ep.Network, &ep.IPAddr); err != nil {
+			log.Warnf("failed to release ip %s: %v", ep.IPAddr, err)
+		}
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 
+	"weike.sh/mydocker/pkg/image"
 	"weike.sh/mydocker/util"
 )
 
@@ -23,9 +25,13 @@ func (overlay2 *Overlay2Driver) Allowed() bool {
 func (overlay2 *Overlay2Driver) MountRootfs(c *Container) error {
 	workdir := path.Join(c.Rootfs.ContainerDir, DriverConfigs[Overlay2]["workDir"])
 
+	lowerDirs, relative := relativeDirs(c.Rootfs.LowerDirs(), image.LayersDir)
 	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
-		c.Rootfs.ImageDir, c.Rootfs.WriteDir, workdir)
+		strings.Join(lowerDirs, ":"), c.Rootfs.WriteDir, workdir)
 	cmd := exec.Command("mount", "-t", "overlay", "-o", options, "overlay", c.Rootfs.MergeDir)
+	if relative {
+		cmd.Dir = image.LayersDir
+	}
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to mount overlay2: %v", err)
 	}
@@ -7,7 +7,6 @@ import (
 	"path"
 	"syscall"
 
-	"weike.sh/mydocker/pkg/image"
 	"weike.sh/mydocker/util"
 )
 
@@ -49,7 +48,7 @@ func (c *Container) NewParentProcess() (*exec.Cmd, *os.File, error) {
 		}
 	}
 
-	if c.Detach {
+	if c.Detach && !c.attached {
 		cmd.Stdout = logFile
 		cmd.Stderr = logFile
 	} else {
@@ -61,7 +60,7 @@ func (c *Container) NewParentProcess() (*exec.Cmd, *os.File, error) {
 	cmd.Dir = c.Rootfs.MergeDir
 	cmd.ExtraFiles = []*os.File{readPipe}
 
-	img, err := image.GetImageByNameOrUuid(c.Image)
+	img, err := c.getImage()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -71,6 +70,7 @@ func (c *Container) NewParentProcess() (*exec.Cmd, *os.File, error) {
 		envs = append(envs, fmt.Sprintf("%s=%s", key, value))
 	}
 	cmd.Env = append(os.Environ(), envs...)
+	cmd.Env = append(cmd.Env, EnvWorkDir+"="+c.WorkingDir, EnvUser+"="+c.User)
 
 	return cmd, writePipe, nil
 }
@@ -0,0 +1,70 @@
+package container
+
+import (
+	"fmt"
+
+	"weike.sh/mydocker/pkg/cgroups"
+)
+
+// Pause suspends all the processes of the container by freezing its
+// cgroup, they aren't aware of being frozen until they're unpaused.
+func (c *Container) Pause() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status == Paused {
+		return fmt.Errorf("the container %s is already paused", c.Name)
+	}
+	if c.Status != Running {
+		return fmt.Errorf("the container %s is %s, not running", c.Name, c.Status)
+	}
+
+	if err := c.Cgroups.Freeze(cgroups.Frozen); err != nil {
+		return fmt.Errorf("failed to pause container %s: %v", c.Uuid, err)
+	}
+
+	c.Status = Paused
+	if err := c.Dump(); err != nil {
+		c.Cgroups.Freeze(cgroups.Thawed)
+		return err
+	}
+
+	fmt.Println(c.Uuid)
+	return nil
+}
+
+// Unpause resumes all the processes of the paused container.
+func (c *Container) Unpause() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status != Paused {
+		return fmt.Errorf("the container %s is %s, not paused", c.Name, c.Status)
+	}
+
+	if err := c.thaw(); err != nil {
+		return err
+	}
+	if err := c.Dump(); err != nil {
+		return err
+	}
+
+	fmt.Println(c.Uuid)
+	return nil
+}
+
+// thaw resumes the processes of the paused container, e.g. before
+// stopping it, since a frozen process can't handle the signals.
+func (c *Container) thaw() error {
+	if err := c.Cgroups.Freeze(cgroups.Thawed); err != nil {
+		return fmt.Errorf("failed to unpause container %s: %v", c.Uuid, err)
+	}
+	c.Status = Running
+	return nil
+}
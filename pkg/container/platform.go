@@ -0,0 +1,133 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/pkg/image"
+)
+
+const BinfmtMiscDir = "/proc/sys/fs/binfmt_misc"
+
+// the names of qemu user emulators for the architectures,
+// e.g. /usr/bin/qemu-aarch64-static registered by binfmt_misc.
+var qemuArchs = map[string]string{
+	"amd64":    "x86_64",
+	"386":      "i386",
+	"arm64":    "aarch64",
+	"arm":      "arm",
+	"ppc64le":  "ppc64le",
+	"s390x":    "s390x",
+	"riscv64":  "riscv64",
+	"mips64le": "mips64el",
+}
+
+// checkPlatform checks whether the image can be run on the host, the
+// image's platform must match the platform if it's given, e.g. by the
+// --platform of run, and the binaries of the image for a foreign
+// architecture must be run by an emulator registered in binfmt_misc.
+func checkPlatform(img *image.Image, platform string) error {
+	imgPlatform := img.Platform()
+	if platform != "" {
+		p, err := image.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+		// the images added by old versions of mydocker have no platform.
+		if imgPlatform == nil || !p.Match(imgPlatform) {
+			return fmt.Errorf("the image %s is for platform %s, which doesn't match "+
+				"the requested platform %s, please pull it with --platform %s",
+				img.RepoTag, imgPlatform, p, p)
+		}
+	}
+
+	if imgPlatform == nil {
+		return nil
+	}
+
+	host := image.HostPlatform()
+	if imgPlatform.OS != host.OS {
+		return fmt.Errorf("the image %s is for %s, which can't be run on %s",
+			img.RepoTag, imgPlatform.OS, host.OS)
+	}
+	// the 32-bit binaries of x86 can be run on amd64 natively.
+	if imgPlatform.Architecture == host.Architecture ||
+		imgPlatform.Architecture == "386" && host.Architecture == "amd64" {
+		return nil
+	}
+
+	handler, err := binfmtHandler(imgPlatform.Architecture)
+	if err != nil {
+		return fmt.Errorf("the image %s is for platform %s, which doesn't match the host %s, "+
+			"and %v, please register qemu-user-static into binfmt_misc first",
+			img.RepoTag, imgPlatform, host, err)
+	}
+	log.Debugf("the binaries of image %s are emulated by %s", img.RepoTag, handler)
+	return nil
+}
+
+// binfmtHandler returns the name of an enabled binfmt_misc handler
+// whose interpreter is the qemu user emulator for the architecture.
+func binfmtHandler(arch string) (string, error) {
+	qemuArch, ok := qemuArchs[arch]
+	if !ok {
+		return "", fmt.Errorf("the architecture %s can't be emulated", arch)
+	}
+
+	entries, err := ioutil.ReadDir(BinfmtMiscDir)
+	if err != nil {
+		return "", fmt.Errorf("binfmt_misc isn't mounted on %s", BinfmtMiscDir)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == "register" || entry.Name() == "status" {
+			continue
+		}
+
+		enabled, interpreter, flags := readBinfmtEntry(path.Join(BinfmtMiscDir, entry.Name()))
+		if !enabled || !strings.HasPrefix(path.Base(interpreter), "qemu-"+qemuArch) {
+			continue
+		}
+		// the interpreter is opened when registered if the flag F is
+		// set, otherwise it must exist in the rootfs of containers.
+		if !strings.Contains(flags, "F") {
+			log.Warnf("the binfmt_misc handler %s is registered without the flag F, "+
+				"so %s must exist in the container", entry.Name(), interpreter)
+		}
+		return entry.Name(), nil
+	}
+
+	return "", fmt.Errorf("no binfmt_misc handler is registered for %s", arch)
+}
+
+// e.g. the contents of /proc/sys/fs/binfmt_misc/qemu-aarch64:
+//
+//	enabled
+//	interpreter /usr/bin/qemu-aarch64-static
+//	flags: OCF
+func readBinfmtEntry(fileName string) (enabled bool, interpreter, flags string) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return false, "", ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "enabled":
+			enabled = true
+		case strings.HasPrefix(line, "interpreter "):
+			interpreter = strings.TrimPrefix(line, "interpreter ")
+		case strings.HasPrefix(line, "flags:"):
+			flags = strings.TrimSpace(strings.TrimPrefix(line, "flags:"))
+		}
+	}
+	return enabled, interpreter, flags
+}
@@ -0,0 +1,100 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the delay before restarting the container is doubled after each
+// restart, and reset if the container has run long enough.
+const (
+	restartDelayMin   = 100 * time.Millisecond
+	restartDelayMax   = time.Minute
+	restartResetAfter = 10 * time.Second
+)
+
+// ParseRestartPolicy parses the policy in the form of docker, i.e.
+// no, on-failure[:max-retries], always or unless-stopped.
+func ParseRestartPolicy(policy string) (*RestartPolicy, error) {
+	parts := strings.SplitN(policy, ":", 2)
+	p := &RestartPolicy{Name: parts[0]}
+
+	switch p.Name {
+	case "", RestartNo:
+		if len(parts) == 2 {
+			return nil, fmt.Errorf("the restart policy %s doesn't accept max retries", RestartNo)
+		}
+		return nil, nil
+	case RestartOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return nil, fmt.Errorf("invalid max retries of restart policy: %s", policy)
+			}
+			p.MaximumRetryCount = count
+		}
+		return p, nil
+	case RestartAlways, RestartUnlessStopped:
+		if len(parts) == 2 {
+			return nil, fmt.Errorf("the restart policy %s doesn't accept max retries", p.Name)
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("invalid restart policy: %s, should be one of "+
+			"no, on-failure[:max-retries], always and unless-stopped", policy)
+	}
+}
+
+func (p *RestartPolicy) String() string {
+	if p == nil {
+		return RestartNo
+	}
+	if p.Name == RestartOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return p.Name
+}
+
+// shouldRestart returns true if the container should be restarted
+// after its process exited, rather than stopped by the user, e.g.
+// killed by `mydocker kill`, whatever its restart policy is.
+func (p *RestartPolicy) shouldRestart(exitCode, restartCount int, manuallyStopped bool) bool {
+	if p == nil || manuallyStopped {
+		return false
+	}
+
+	switch p.Name {
+	case RestartAlways, RestartUnlessStopped:
+		return true
+	case RestartOnFailure:
+		return exitCode != 0 && (p.MaximumRetryCount == 0 ||
+			restartCount < p.MaximumRetryCount)
+	default:
+		return false
+	}
+}
+
+// startOnBoot returns true if the stopped container should be
+// started again by `mydocker system restore` after rebooting.
+func (p *RestartPolicy) startOnBoot(manuallyStopped bool) bool {
+	if p == nil {
+		return false
+	}
+	return p.Name == RestartAlways ||
+		p.Name == RestartUnlessStopped && !manuallyStopped
+}
+
+// restartDelay returns the delay before restarting the container,
+// which is doubled from the last delay up to restartDelayMax, or
+// reset to restartDelayMin if the container has run long enough.
+func restartDelay(last, ran time.Duration) time.Duration {
+	if last == 0 || ran > restartResetAfter {
+		return restartDelayMin
+	}
+	if delay := last * 2; delay < restartDelayMax {
+		return delay
+	}
+	return restartDelayMax
+}
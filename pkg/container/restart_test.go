@@ -0,0 +1,118 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected *RestartPolicy
+		err      string
+	}{
+		{policy: "", expected: nil},
+		{policy: "no", expected: nil},
+		{policy: "always", expected: &RestartPolicy{Name: RestartAlways}},
+		{policy: "unless-stopped", expected: &RestartPolicy{Name: RestartUnlessStopped}},
+		{policy: "on-failure", expected: &RestartPolicy{Name: RestartOnFailure}},
+		{policy: "on-failure:3", expected: &RestartPolicy{Name: RestartOnFailure, MaximumRetryCount: 3}},
+		{policy: "on-failure:0", expected: &RestartPolicy{Name: RestartOnFailure}},
+		{policy: "on-failure:-1", err: "invalid max retries of restart policy: on-failure:-1"},
+		{policy: "on-failure:x", err: "invalid max retries of restart policy: on-failure:x"},
+		{policy: "always:3", err: "the restart policy always doesn't accept max retries"},
+		{policy: "unless-stopped:3", err: "the restart policy unless-stopped doesn't accept max retries"},
+		{policy: "no:3", err: "the restart policy no doesn't accept max retries"},
+		{policy: "sometimes", err: "invalid restart policy: sometimes, should be one of " +
+			"no, on-failure[:max-retries], always and unless-stopped"},
+	}
+
+	for _, test := range tests {
+		p, err := ParseRestartPolicy(test.policy)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("expected error %q when parsing %q, got %v", test.err, test.policy, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to parse %q: %v", test.policy, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, test.expected) {
+			t.Errorf("expected %+v when parsing %q, got %+v", test.expected, test.policy, p)
+		}
+		// the policy is shown as it's given, except the default ones.
+		if expected := test.policy; expected != "" && expected != "on-failure:0" && p.String() != expected {
+			t.Errorf("expected %q to be shown as it is, got %q", test.policy, p.String())
+		}
+	}
+}
+
+func TestShouldRestart(t *testing.T) {
+	tests := []struct {
+		policy          string
+		exitCode        int
+		restartCount    int
+		manuallyStopped bool
+		restart         bool
+		startOnBoot     bool
+	}{
+		{policy: "no", exitCode: 1, restart: false, startOnBoot: false},
+		{policy: "always", exitCode: 0, restart: true, startOnBoot: true},
+		{policy: "always", exitCode: 137, manuallyStopped: true, restart: false, startOnBoot: true},
+		{policy: "unless-stopped", exitCode: 0, restart: true, startOnBoot: true},
+		// the container stopped or killed by the user stays stopped.
+		{policy: "unless-stopped", exitCode: 143, manuallyStopped: true, restart: false, startOnBoot: false},
+		{policy: "on-failure", exitCode: 0, restart: false, startOnBoot: false},
+		{policy: "on-failure", exitCode: 1, restartCount: 100, restart: true, startOnBoot: false},
+		{policy: "on-failure", exitCode: 1, manuallyStopped: true, restart: false, startOnBoot: false},
+		{policy: "on-failure:3", exitCode: 1, restartCount: 2, restart: true, startOnBoot: false},
+		{policy: "on-failure:3", exitCode: 1, restartCount: 3, restart: false, startOnBoot: false},
+		{policy: "on-failure:3", exitCode: 0, restartCount: 0, restart: false, startOnBoot: false},
+	}
+
+	for _, test := range tests {
+		p, err := ParseRestartPolicy(test.policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restart := p.shouldRestart(test.exitCode, test.restartCount, test.manuallyStopped); restart != test.restart {
+			t.Errorf("expected the container with policy %s, exit code %d, restart count %d "+
+				"and manually stopped %v to be restarted: %v, got %v", test.policy, test.exitCode,
+				test.restartCount, test.manuallyStopped, test.restart, restart)
+		}
+		if start := p.startOnBoot(test.manuallyStopped); start != test.startOnBoot {
+			t.Errorf("expected the container with policy %s and manually stopped %v "+
+				"to be started on boot: %v, got %v", test.policy, test.manuallyStopped, test.startOnBoot, start)
+		}
+	}
+}
+
+func TestRestartDelay(t *testing.T) {
+	// the delay is doubled after each quick exit until the cap.
+	var delays []time.Duration
+	var delay time.Duration
+	for i := 0; i < 12; i++ {
+		delay = restartDelay(delay, time.Second)
+		delays = append(delays, delay)
+	}
+	expected := []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, 1600 * time.Millisecond, 3200 * time.Millisecond,
+		6400 * time.Millisecond, 12800 * time.Millisecond, 25600 * time.Millisecond,
+		51200 * time.Millisecond, time.Minute, time.Minute,
+	}
+	if !reflect.DeepEqual(delays, expected) {
+		t.Errorf("expected the delays %v, got %v", expected, delays)
+	}
+
+	// the delay is reset once the container has run long enough.
+	if delay := restartDelay(time.Minute, restartResetAfter+time.Second); delay != restartDelayMin {
+		t.Errorf("expected the delay to be reset to %s, got %s", restartDelayMin, delay)
+	}
+	if delay := restartDelay(time.Minute, restartResetAfter); delay != time.Minute {
+		t.Errorf("expected the delay to be kept at %s, got %s", time.Minute, delay)
+	}
+}
@@ -0,0 +1,105 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Restore restores the containers after the host rebooted, while the
+// bridges and iptables rules of networks are recreated by network.Init()
+// before each command. it should be called before any other command,
+// e.g. by a systemd unit, because the containers killed by the reboot
+// are marked as stopped once they are loaded by other commands.
+func Restore() error {
+	dirs, err := ioutil.ReadDir(ContainersDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dir %s: %v", ContainersDir, err)
+	}
+
+	var failed []string
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		c := &Container{Uuid: dir.Name()}
+		if err := c.load(); err != nil {
+			log.Errorf("failed to get the info of container %s: %v", c.Uuid, err)
+			continue
+		}
+		if err := c.restore(); err != nil {
+			log.Errorf("failed to restore container %s: %v", c.Name, err)
+			failed = append(failed, c.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore containers: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restore cleans up what's left by the dead process of the container,
+// e.g. the port maps and mounts, and starts it again if it was running
+// or its restart policy requires.
+func (c *Container) restore() error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status != Running && c.Status != Restarting && c.Status != Paused {
+		// the rootfs of a stopped container is left mounted
+		// if mydocker was killed while stopping it.
+		if err := c.umountRootfsVolume(); err != nil {
+			return err
+		}
+		if (c.Status == Stopped || c.Status == Exited) &&
+			c.RestartPolicy.startOnBoot(c.ManuallyStopped) {
+			return c.Start()
+		}
+		return nil
+	}
+
+	if c.processExists() {
+		return nil
+	}
+	if c.shimIsAlive() {
+		return nil
+	}
+
+	log.Debugf("restore container %s whose process %d doesn't exist",
+		c.Uuid, c.Cgroups.Pid)
+	if len(c.Endpoints) > 0 {
+		// the veths are removed with the netns of the dead process,
+		// only the port maps are left, which are deleted firstly.
+		if err := c.handleNetwork(Delete); err != nil {
+			log.Debugf("failed to cleanup networks of container %s: %v",
+				c.Uuid, err)
+		}
+	}
+	c.Cgroups.Destory()
+	if err := c.umountRootfsVolume(); err != nil {
+		return err
+	}
+
+	c.recordLost()
+	if err := c.Dump(); err != nil {
+		return err
+	}
+
+	// the container running in foreground is removed after its
+	// process exits, which can't be attached again by restore.
+	if !c.Detach {
+		return c.Delete()
+	}
+	return c.Start()
+}
@@ -4,15 +4,22 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strings"
 
 	"weike.sh/mydocker/util"
 )
 
+// prepareRootfs creates and mounts the rootfs of the container, which
+// are recorded into the undo log to be undone if failed to start it.
 func (c *Container) prepareRootfs() error {
+	if exist, _ := util.FileOrDirExists(c.Rootfs.ContainerDir); !exist {
+		c.undo.add("remove the dir of container", c.deleteRootfs)
+	}
 	if err := c.createRootfs(); err != nil {
 		return err
 	}
 
+	c.undo.add("umount the rootfs and volumes", c.umountRootfsVolume)
 	if err := c.mountRootfsVolume(); err != nil {
 		return err
 	}
@@ -84,3 +91,25 @@ func (c *Container) umountRootfsVolume() error {
 
 	return util.Umount(c.Rootfs.MergeDir)
 }
+
+// LowerDirs returns the read-only dirs of the rootfs from top to bottom.
+func (r *Rootfs) LowerDirs() []string {
+	if len(r.ImageDirs) > 0 {
+		return r.ImageDirs
+	}
+	return []string{r.ImageDir}
+}
+
+// relativeDirs converts the dirs to the paths relative to base if
+// all of them are under base, so that the mount options of images
+// with many layers don't exceed the limit of a page size.
+func relativeDirs(dirs []string, base string) ([]string, bool) {
+	var relDirs []string
+	for _, dir := range dirs {
+		if !strings.HasPrefix(dir, base+"/") {
+			return dirs, false
+		}
+		relDirs = append(relDirs, strings.TrimPrefix(dir, base+"/"))
+	}
+	return relDirs, true
+}
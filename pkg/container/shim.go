@@ -0,0 +1,297 @@
+package container
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// shimStarted is reported by the shim once the container is started.
+const shimStarted = "started"
+
+// startShim starts `mydocker shim` for the detached container, which
+// starts the container as its child and reports the result through a
+// pipe, so that `run` still returns the errors of starting it. if it
+// failed, the side effects of creating the container are undone here,
+// while the ones of starting it are undone by the shim.
+func (c *Container) startShim() (err error) {
+	defer func() {
+		if err != nil && c.undo != nil {
+			c.undo.rollback()
+		}
+		c.undo = nil
+	}()
+
+	configRead, configWrite, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("failed to create pipe: %v", err)
+	}
+	defer configWrite.Close()
+
+	resultRead, resultWrite, err := os.Pipe()
+	if err != nil {
+		configRead.Close()
+		return fmt.Errorf("failed to create pipe: %v", err)
+	}
+	defer resultRead.Close()
+
+	var args []string
+	if os.Getenv("debug") == "true" {
+		args = append(args, "--debug")
+	}
+	cmd := exec.Command("/proc/self/exe", append(args, "shim")...)
+	cmd.Dir = "/"
+	cmd.ExtraFiles = []*os.File{configRead, resultWrite}
+	// the shim runs in its own session, so that it isn't
+	// killed with the terminal after `run` returns.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	configRead.Close()
+	resultWrite.Close()
+	if err != nil {
+		return fmt.Errorf("failed to start the shim of container %s: %v", c.Uuid, err)
+	}
+	defer cmd.Process.Release()
+
+	jsonBytes, err := json.Marshal(c)
+	if err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("failed to json-encode container %s: %v", c.Uuid, err)
+	}
+	if _, err := configWrite.Write(jsonBytes); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("failed to send the config to the shim: %v", err)
+	}
+	configWrite.Close()
+
+	result, err := ioutil.ReadAll(resultRead)
+	if err != nil {
+		return fmt.Errorf("failed to read the result of the shim: %v", err)
+	}
+
+	switch string(result) {
+	case shimStarted:
+		return nil
+	case "":
+		return fmt.Errorf("the shim of container %s exited unexpectedly", c.Uuid)
+	default:
+		return errors.New(string(result))
+	}
+}
+
+// RunShim is called by `mydocker shim`, which owns the init process of
+// the detached container, so that it can wait for the process, record
+// its exit code, restart it by the restart policy, and clean up its
+// networks, cgroups and mounts after it exited.
+func RunShim() error {
+	// the init process of the container mustn't inherit the pipes.
+	syscall.CloseOnExec(3)
+	syscall.CloseOnExec(4)
+	configPipe := os.NewFile(uintptr(3), "config")
+	resultPipe := os.NewFile(uintptr(4), "result")
+
+	c := &Container{ShimPid: os.Getpid()}
+	c.ShimStartTime, _ = util.ProcessStartTime(c.ShimPid)
+	parentCmd, err := c.startFromPipe(configPipe)
+	configPipe.Close()
+	if err != nil {
+		resultPipe.WriteString(err.Error())
+		resultPipe.Close()
+		return err
+	}
+	resultPipe.WriteString(shimStarted)
+	resultPipe.Close()
+
+	// the logs of the shim are written into the dir of the
+	// container, since nobody reads its stdout any longer.
+	logFileName := path.Join(c.Rootfs.ContainerDir, ShimLogName)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if logFile, err := os.OpenFile(logFileName, flags, 0644); err == nil {
+		defer logFile.Close()
+		log.SetOutput(logFile)
+	}
+
+	return c.supervise(parentCmd)
+}
+
+func (c *Container) startFromPipe(configPipe *os.File) (*exec.Cmd, error) {
+	jsonBytes, err := ioutil.ReadAll(configPipe)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the config from pipe: %v", err)
+	}
+	shimPid, shimStartTime := c.ShimPid, c.ShimStartTime
+	if err := json.Unmarshal(jsonBytes, c); err != nil {
+		return nil, fmt.Errorf("failed to json-decode container: %v", err)
+	}
+	c.ShimPid, c.ShimStartTime = shimPid, shimStartTime
+
+	return c.start()
+}
+
+// supervise waits for the process of the container, and restarts it
+// with an exponential backoff delay while the restart policy allows.
+func (c *Container) supervise(parentCmd *exec.Cmd) error {
+	var delay time.Duration
+	for {
+		startedAt := time.Now()
+		pid := parentCmd.Process.Pid
+		parentCmd.Wait()
+
+		log.Infof("the process %d of container %s exited with code %d",
+			pid, c.Uuid, exitCodeOf(parentCmd.ProcessState))
+
+		restart, err := c.handleExit(pid, parentCmd.ProcessState)
+		if err != nil || !restart {
+			return err
+		}
+
+		delay = restartDelay(delay, time.Since(startedAt))
+		log.Infof("restart container %s in %s", c.Uuid, delay)
+		time.Sleep(delay)
+
+		parentCmd, err = c.restartByPolicy()
+		if err != nil || parentCmd == nil {
+			return err
+		}
+	}
+}
+
+// handleExit records the exit code of the process, and cleans up the
+// container unless it's been stopped by others, e.g. `mydocker stop`,
+// returns true if it should be restarted by the restart policy.
+func (c *Container) handleExit(pid int, state *os.ProcessState) (bool, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if c.Cgroups.Pid != pid {
+		// the container stopped by others is cleaned up by them,
+		// unless it's been started again by another shim.
+		if c.Status != Stopped {
+			return false, nil
+		}
+		c.ExitCode = exitCodeOf(state)
+		c.ShimPid = 0
+		return false, c.Dump()
+	}
+
+	c.recordExit(state)
+	if len(c.Endpoints) > 0 {
+		if err := c.handleNetwork(Delete); err != nil {
+			log.Debugf("failed to cleanup networks of container %s: %v",
+				c.Uuid, err)
+		}
+	}
+	c.Cgroups.Destory()
+	if err := c.umountRootfsVolume(); err != nil {
+		log.Warnf("failed to umount the rootfs of container %s: %v", c.Uuid, err)
+	}
+
+	restart := c.RestartPolicy.shouldRestart(c.ExitCode, c.RestartCount, c.ManuallyStopped)
+	if restart {
+		c.Status = Restarting
+	} else {
+		c.ShimPid = 0
+	}
+	return restart, c.Dump()
+}
+
+// restartByPolicy starts the container again after the delay, unless
+// it's been stopped or removed by the user during the delay.
+func (c *Container) restartByPolicy() (*exec.Cmd, error) {
+	unlock, err := c.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if c.Status != Restarting {
+		log.Infof("container %s is %s, give up restarting it", c.Uuid, c.Status)
+		return nil, nil
+	}
+
+	c.RestartCount++
+	parentCmd, err := c.start()
+	if err != nil {
+		// the status is reset to stopped by start().
+		c.Error = err.Error()
+		c.ShimPid = 0
+		if err := c.Dump(); err != nil {
+			log.Warnf("failed to record the error of container %s: %v", c.Uuid, err)
+		}
+		return nil, err
+	}
+	return parentCmd, nil
+}
+
+// shimIsAlive returns true if the shim of the container is alive,
+// which cleans up the container after its process exited, so that
+// the others needn't mark it as stopped.
+func (c *Container) shimIsAlive() bool {
+	if c.ShimPid <= 0 {
+		return false
+	}
+	// the pid maybe reused by another process, e.g. after a reboot.
+	if c.ShimStartTime != 0 {
+		if startTime, err := util.ProcessStartTime(c.ShimPid); err != nil || startTime != c.ShimStartTime {
+			return false
+		}
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", c.ShimPid))
+	if err != nil {
+		return false
+	}
+	return util.Contains(strings.Split(string(cmdline), "\x00"), "shim")
+}
+
+// processExists returns true if the init process of the container exists,
+// including a zombie, the pid maybe reused by another process after it
+// exited, e.g. after a reboot, so it's identified by its start time, or
+// by the cgroup of the container if it's started by old versions.
+func (c *Container) processExists() bool {
+	if c.Cgroups.Pid <= 0 {
+		return false
+	}
+
+	startTime, err := util.ProcessStartTime(c.Cgroups.Pid)
+	if err != nil {
+		return false
+	}
+	if c.PidStartTime != 0 {
+		return startTime == c.PidStartTime
+	}
+
+	pids, err := c.Cgroups.Pids()
+	if err != nil {
+		return false
+	}
+	for _, pid := range pids {
+		if pid == c.Cgroups.Pid {
+			return true
+		}
+	}
+	return false
+}
+
+// exitCodeOf returns the exit code of the process in the form of
+// shells, i.e. 128+signal if the process is killed by a signal.
+func exitCodeOf(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// ExitError is returned if the process of the container or executed in
+// the container exited with a non-zero code, which is used as the exit
+// code of mydocker, since it's a cli.ExitCoder.
+type ExitError struct {
+	Code int
+}
+
+// Error returns nothing, so that nothing is printed by cli before exiting.
+func (e *ExitError) Error() string {
+	return ""
+}
+
+func (e *ExitError) ExitCode() int {
+	return e.Code
+}
+
+// recordExit records the state of the container after its process
+// exited, which must be called before destroying its cgroups.
+func (c *Container) recordExit(state *os.ProcessState) {
+	oomKilled, err := c.Cgroups.OOMKilled()
+	if err != nil {
+		log.Debugf("failed to check if container %s is oom killed: %v", c.Uuid, err)
+	}
+
+	c.Cgroups.Pid = 0
+	c.Status = Exited
+	c.ExitCode = exitCodeOf(state)
+	c.OOMKilled = oomKilled
+	c.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+}
+
+// recordLost records the container whose process disappeared without
+// its exit recorded, e.g. its shim was killed, as exited with an
+// unknown exit code, i.e. 255 like docker.
+func (c *Container) recordLost() {
+	c.Cgroups.Pid = 0
+	c.Status = Exited
+	c.ExitCode = 255
+	c.Error = "the process exited without its exit code recorded"
+	c.FinishedAt = time.Now().Format("2006-01-02 15:04:05")
+}
+
+// StateString returns the state of the container in the form of
+// docker, e.g. "Up 5 minutes" or "Exited (137) 5 minutes ago".
+func (c *Container) StateString() string {
+	now := time.Now()
+	switch c.Status {
+	case Running, Paused:
+		if startedAt, err := parseTime(c.StartedAt); err == nil {
+			state := "Up " + util.HumanDuration(now.Sub(startedAt))
+			if c.Status == Paused {
+				state += " (Paused)"
+			}
+			return state
+		}
+	case Exited, Stopped, Restarting:
+		if finishedAt, err := parseTime(c.FinishedAt); err == nil {
+			return fmt.Sprintf("%s (%d) %s ago", strings.Title(c.Status),
+				c.ExitCode, util.HumanDuration(now.Sub(finishedAt)))
+		}
+	}
+	return strings.Title(c.Status)
+}
+
+func parseTime(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+}
+
+// Wait blocks until the process of the container exits, and returns
+// its exit code, which is recorded by the shim of the container, or
+// by `run` for the container in foreground, which is removed after
+// the waiters holding the shared lock of WaitName read its exit code.
+// the container created or restarting is waited until it exits.
+func (c *Container) Wait() (int, error) {
+	configFileName := path.Join(ContainersDir, c.Uuid, ConfigName)
+	unlock, err := util.RLockFile(path.Join(ContainersDir, c.Uuid, WaitName))
+	if err != nil {
+		return 0, fmt.Errorf("the container %s has been removed", c.Name)
+	}
+	defer unlock()
+
+	for c.Status == Created || c.Status == Running ||
+		c.Status == Paused || c.Status == Restarting {
+		// the shim giving up restarting the container is gone.
+		if c.Status == Restarting && !c.shimIsAlive() {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+		if exist, _ := util.FileOrDirExists(configFileName); !exist {
+			return 0, fmt.Errorf("the container %s has been removed", c.Name)
+		}
+		if err := c.Load(); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.ExitCode, nil
+}
@@ -0,0 +1,80 @@
+package container
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Stats is a sample of the resource usages of a running container.
+type Stats struct {
+	Uuid string `json:"Uuid"`
+	Name string `json:"Name"`
+	// the cpu percent is relative to a single cpu, so that it
+	// maybe above 100% if the container uses multiple cpus.
+	CpuPercent    float64 `json:"CpuPercent"`
+	MemoryUsage   uint64  `json:"MemoryUsage"`
+	MemoryLimit   uint64  `json:"MemoryLimit"`
+	MemoryPercent float64 `json:"MemoryPercent"`
+	NetworkRx     uint64  `json:"NetworkRx"`
+	NetworkTx     uint64  `json:"NetworkTx"`
+	BlockRead     uint64  `json:"BlockRead"`
+	BlockWrite    uint64  `json:"BlockWrite"`
+	Pids          uint64  `json:"Pids"`
+
+	// the time of the sample and the cpu time consumed by then,
+	// which are kept to compute the cpu percent of next sample.
+	read     time.Time
+	cpuUsage uint64
+}
+
+// Stats reads the usages of the container from its cgroup and the veths
+// of its endpoints, the cpu percent is computed since the previous sample
+// prev, it's 0 if prev is nil.
+func (c *Container) Stats(prev *Stats) (*Stats, error) {
+	if c.Status != Running && c.Status != Paused {
+		return nil, fmt.Errorf("the container %s is %s, not running", c.Name, c.Status)
+	}
+
+	cgStats, err := c.Cgroups.Stats()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the stats of container %s: %v", c.Name, err)
+	}
+
+	stats := &Stats{
+		Uuid:        c.Uuid,
+		Name:        c.Name,
+		MemoryUsage: cgStats.MemoryUsage,
+		MemoryLimit: cgStats.MemoryLimit,
+		BlockRead:   cgStats.BlkioRead,
+		BlockWrite:  cgStats.BlkioWrite,
+		Pids:        cgStats.PidsCurrent,
+		read:        time.Now(),
+		cpuUsage:    cgStats.CpuUsage,
+	}
+
+	if stats.MemoryLimit > 0 {
+		stats.MemoryPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+	}
+
+	// the cpu time is reset if the container is restarted.
+	if prev != nil && prev.Uuid == c.Uuid && stats.cpuUsage >= prev.cpuUsage {
+		if elapsed := stats.read.Sub(prev.read); elapsed > 0 {
+			stats.CpuPercent = float64(stats.cpuUsage-prev.cpuUsage) / float64(elapsed.Nanoseconds()) * 100
+		}
+	}
+
+	for _, ep := range c.Endpoints {
+		rx, tx, err := ep.Stats()
+		if err != nil {
+			log.Debugf("failed to read the stats of endpoint %s of container %s: %v",
+				ep.Network.Name, c.Uuid, err)
+			continue
+		}
+		stats.NetworkRx += rx
+		stats.NetworkTx += tx
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,117 @@
+package container
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// DefaultStopTimeout is the seconds to wait for the container to exit
+// after sending the stop signal, before killing it by SIGKILL.
+const DefaultStopTimeout = 10
+
+// killTimeout is how long to wait for the processes killed by SIGKILL.
+const killTimeout = 5 * time.Second
+
+// Kill sends the signal to the init process of the container, the
+// container killed by SIGKILL or its stop signal isn't restarted by
+// its restart policy, like the one stopped by Stop(), while the other
+// signals, e.g. SIGHUP to reload its config, don't stop it manually.
+func (c *Container) Kill(sig syscall.Signal) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if c.Status == Paused {
+		return fmt.Errorf("the container %s is paused, unpause it first", c.Name)
+	}
+	if c.Status != Running || !util.ProcessIsAlive(c.Cgroups.Pid) {
+		return fmt.Errorf("the container %s is %s, not running", c.Name, c.Status)
+	}
+
+	if sig == syscall.SIGKILL || sig == c.stopSignal() {
+		c.ManuallyStopped = true
+		if err := c.Dump(); err != nil {
+			return err
+		}
+	}
+
+	if err := syscall.Kill(c.Cgroups.Pid, sig); err != nil {
+		return fmt.Errorf("failed to send signal %d to container %s: %v", sig, c.Uuid, err)
+	}
+
+	fmt.Println(c.Uuid)
+	return nil
+}
+
+// terminate sends the stop signal to the init process of the container
+// and waits for it to exit, all the processes in the cgroup are killed
+// by SIGKILL if it's still alive after the timeout. note that the init
+// process ignores the signals it doesn't handle, except SIGKILL.
+func (c *Container) terminate(timeout int) error {
+	pid := c.Cgroups.Pid
+	if !util.ProcessIsAlive(pid) {
+		return nil
+	}
+
+	sig := c.stopSignal()
+	log.Debugf("send signal %d to the process %d of container %s", sig, pid, c.Uuid)
+	if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+		return fmt.Errorf("failed to send signal %d to container %s: %v", sig, c.Uuid, err)
+	}
+	if waitProcess(pid, time.Duration(timeout)*time.Second) {
+		return nil
+	}
+
+	log.Warnf("container %s didn't exit within %d seconds after signal %d, kill it",
+		c.Uuid, timeout, sig)
+	pids, err := c.Cgroups.Pids()
+	if err != nil {
+		log.Debugf("failed to get the processes of container %s: %v", c.Uuid, err)
+	}
+	for _, p := range append(pids, pid) {
+		if err := syscall.Kill(p, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			log.Warnf("failed to kill the process %d of container %s: %v", p, c.Uuid, err)
+		}
+	}
+	if !waitProcess(pid, killTimeout) {
+		return fmt.Errorf("failed to kill the process %d of container %s", pid, c.Uuid)
+	}
+
+	return nil
+}
+
+// stopSignal returns the signal to stop the container, the container
+// created by old versions of mydocker is stopped by SIGTERM.
+func (c *Container) stopSignal() syscall.Signal {
+	if c.StopSignal != "" {
+		if sig, err := util.ParseSignal(c.StopSignal); err == nil {
+			return sig
+		}
+	}
+	return syscall.SIGTERM
+}
+
+func (c *Container) stopTimeout() int {
+	if c.StopTimeout != nil {
+		return *c.StopTimeout
+	}
+	return DefaultStopTimeout
+}
+
+// waitProcess returns false if the process is still alive after the timeout.
+func waitProcess(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for util.ProcessIsAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,180 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// clockTicks is the USER_HZ of linux, i.e. the unit of the cpu times
+// in /proc/<pid>/stat, which is 100 on all the common architectures.
+const clockTicks = 100
+
+// Process is a process of the container read from /proc.
+type Process struct {
+	User string `json:"User"`
+	// the pid on the host, and the pid in the pid namespace of the container.
+	Pid   int `json:"Pid"`
+	NsPid int `json:"NsPid"`
+	Ppid  int `json:"Ppid"`
+	// the cpu time consumed in user and kernel mode.
+	Time    time.Duration `json:"Time"`
+	Rss     uint64        `json:"Rss"`
+	Command string        `json:"Command"`
+}
+
+// Top returns the processes in the cgroup of the container, the users
+// are named by /etc/passwd in the container, since they're seen by it.
+func (c *Container) Top() ([]*Process, error) {
+	pids, err := c.topPids()
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := util.UserNames(fmt.Sprintf("/proc/%d/root", c.Cgroups.Pid))
+	if err != nil {
+		log.Debugf("failed to read the users of container %s: %v", c.Uuid, err)
+	}
+
+	var processes []*Process
+	for _, pid := range pids {
+		p, err := readProcess(pid)
+		if err != nil {
+			// the process maybe exited after listing.
+			log.Debugf("failed to read process %d: %v", pid, err)
+			continue
+		}
+		if name, ok := names[uidOf(p.User)]; ok {
+			p.User = name
+		}
+		processes = append(processes, p)
+	}
+
+	return processes, nil
+}
+
+// Ps runs `ps` with the options on the host, and returns the header and
+// the lines of the processes in the container, which are found by the
+// PID column, e.g. `ps -ef` or `ps aux`.
+func (c *Container) Ps(options []string) ([]string, error) {
+	pids, err := c.topPids()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := exec.Command("ps", options...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run ps %s: %v", strings.Join(options, " "), err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	pidIndex := -1
+	for i, field := range strings.Fields(lines[0]) {
+		if field == "PID" {
+			pidIndex = i
+			break
+		}
+	}
+	if pidIndex < 0 {
+		return nil, fmt.Errorf("no PID column in the output of ps %s", strings.Join(options, " "))
+	}
+
+	inContainer := map[string]bool{}
+	for _, pid := range pids {
+		inContainer[strconv.Itoa(pid)] = true
+	}
+
+	result := []string{lines[0]}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) > pidIndex && inContainer[fields[pidIndex]] {
+			result = append(result, line)
+		}
+	}
+	return result, nil
+}
+
+func (c *Container) topPids() ([]int, error) {
+	if c.Status != Running && c.Status != Paused {
+		return nil, fmt.Errorf("the container %s is %s, not running", c.Name, c.Status)
+	}
+	return c.Cgroups.Pids()
+}
+
+// readProcess reads the process from /proc/<pid>/status, stat and cmdline.
+func readProcess(pid int) (*Process, error) {
+	p := &Process{Pid: pid}
+
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// e.g. Uid:	0	0	0	0, NSpid:	1234	1, VmRSS:	1024 kB
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			p.User = fields[1]
+		case "PPid:":
+			p.Ppid, _ = strconv.Atoi(fields[1])
+		case "NSpid:":
+			// the last one is the pid in the innermost pid namespace.
+			p.NsPid, _ = strconv.Atoi(fields[len(fields)-1])
+		case "VmRSS:":
+			rss, _ := strconv.ParseUint(fields[1], 10, 64)
+			p.Rss = rss * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// e.g. 1234 (sh) S 1 ... utime stime ..., the comm maybe contains spaces.
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	statStr := string(stat)
+	comm := statStr[strings.Index(statStr, "(")+1 : strings.LastIndex(statStr, ")")]
+	// the fields after comm, i.e. from the 3rd field state.
+	fields := strings.Fields(statStr[strings.LastIndex(statStr, ")")+1:])
+	if len(fields) > 12 {
+		utime, _ := strconv.ParseUint(fields[11], 10, 64)
+		stime, _ := strconv.ParseUint(fields[12], 10, 64)
+		p.Time = time.Duration(utime+stime) * time.Second / clockTicks
+	}
+
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return nil, err
+	}
+	p.Command = strings.TrimSpace(strings.Replace(string(cmdline), "\x00", " ", -1))
+	// the cmdline of zombies and kernel threads is empty.
+	if p.Command == "" {
+		p.Command = "[" + comm + "]"
+	}
+
+	return p, nil
+}
+
+func uidOf(user string) int {
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		return -1
+	}
+	return uid
+}
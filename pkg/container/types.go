@@ -9,28 +9,70 @@ import (
 
 type Rootfs struct {
 	ContainerDir string `json:"ContainerDir"`
-	ImageDir     string `json:"ImageDir"`
-	WriteDir     string `json:"WriteDir"`
-	MergeDir     string `json:"MergeDir"`
+	// the flattened rootfs of legacy images, replaced by ImageDirs.
+	ImageDir string `json:"ImageDir,omitempty"`
+	// the dirs of image's layers, from top to bottom.
+	ImageDirs []string `json:"ImageDirs,omitempty"`
+	WriteDir  string   `json:"WriteDir"`
+	MergeDir  string   `json:"MergeDir"`
 }
 
 type Container struct {
-	Detach        bool                `json:"Detach"`
-	Uuid          string              `json:"Uuid"`
-	Name          string              `json:"Name"`
-	Hostname      string              `json:"Hostname"`
-	Dns           []string            `json:"Dns"`
-	Image         string              `json:"Image"`
+	Detach   bool     `json:"Detach"`
+	Uuid     string   `json:"Uuid"`
+	Name     string   `json:"Name"`
+	Hostname string   `json:"Hostname"`
+	Dns      []string `json:"Dns"`
+	Image    string   `json:"Image"`
+	// the uuid of the image, while Image is the name given by the
+	// user, which maybe untagged or tagged to another image later.
+	ImageUuid     string              `json:"ImageUuid,omitempty"`
 	CreateTime    string              `json:"CreateTime"`
 	Status        string              `json:"Status"`
 	StorageDriver string              `json:"StorageDriver"`
 	Rootfs        *Rootfs             `json:"Rootfs"`
 	Commands      []string            `json:"Commands"`
+	WorkingDir    string              `json:"WorkingDir,omitempty"`
+	User          string              `json:"User,omitempty"`
 	Cgroups       *cgroups.Cgroups    `json:"Cgroups"`
 	Volumes       map[string]string   `json:"Volumes"`
 	Envs          map[string]string   `json:"Envs"`
 	Ports         map[string]string   `json:"Ports"`
 	Endpoints     []*network.Endpoint `json:"Endpoints"`
+
+	// the restart policy applied by the shim of the detached
+	// container, the start times of the shim and the process
+	// telling them from the later ones reusing their pids, the signal and seconds to wait before killing
+	// it by stop, and the state of its latest run, e.g. the exit
+	// code of its process, which is 128+signal if it's killed.
+	RestartPolicy   *RestartPolicy `json:"RestartPolicy,omitempty"`
+	RestartCount    int            `json:"RestartCount"`
+	ShimPid         int            `json:"ShimPid,omitempty"`
+	ShimStartTime   uint64         `json:"ShimStartTime,omitempty"`
+	PidStartTime    uint64         `json:"PidStartTime,omitempty"`
+	ManuallyStopped bool           `json:"ManuallyStopped,omitempty"`
+	StopSignal      string         `json:"StopSignal,omitempty"`
+	StopTimeout     *int           `json:"StopTimeout,omitempty"`
+	ExitCode        int            `json:"ExitCode"`
+	OOMKilled       bool           `json:"OOMKilled"`
+	Error           string         `json:"Error,omitempty"`
+	StartedAt       string         `json:"StartedAt,omitempty"`
+	FinishedAt      string         `json:"FinishedAt,omitempty"`
+
+	// the side effects of creating and starting the container,
+	// which are undone if it failed to be created or started.
+	undo *undoLog
+	// the stdio of the process is attached to the terminal
+	// even if it's detached, e.g. by `mydocker start -a`.
+	attached bool
+	// releases the lock of images held by NewContainer, see releaseImages().
+	unlockImages func()
+}
+
+type RestartPolicy struct {
+	Name string `json:"Name"`
+	// the max times to restart the container for on-failure, 0 means no limit.
+	MaximumRetryCount int `json:"MaximumRetryCount,omitempty"`
 }
 
 type Driver interface {
@@ -0,0 +1,37 @@
+package container
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// undoLog records how to undo each side effect of creating and starting
+// a container, e.g. allocating ips, mounting the rootfs and creating the
+// cgroups, which are undone in the reverse order if any step failed.
+type undoLog struct {
+	steps []undoStep
+}
+
+type undoStep struct {
+	name string
+	undo func() error
+}
+
+// add records the step, it should be called before the side effect is
+// made if it maybe made partially, e.g. mkdir -p, and the undo must be
+// harmless if the side effect hasn't been made.
+func (u *undoLog) add(name string, undo func() error) {
+	u.steps = append(u.steps, undoStep{name: name, undo: undo})
+}
+
+// rollback undoes all the recorded steps in the reverse order, the
+// failed steps are logged and skipped, so the others still get undone.
+func (u *undoLog) rollback() {
+	for i := len(u.steps) - 1; i >= 0; i-- {
+		step := u.steps[i]
+		log.Debugf("rollback: %s", step.name)
+		if err := step.undo(); err != nil {
+			log.Warnf("failed to rollback (%s): %v", step.name, err)
+		}
+	}
+	u.steps = nil
+}
@@ -0,0 +1,29 @@
+package container
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+// Update changes the resource limits of the container, which are applied
+// to its cgroup at once if it's running, and persisted in its config, so
+// that they're still applied after it's restarted.
+func (c *Container) Update(ctx *cli.Context) error {
+	unlock, err := c.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	live := (c.Status == Running || c.Status == Paused) && c.Cgroups.Pid > 0
+	if err := c.Cgroups.Update(ctx, live); err != nil {
+		return fmt.Errorf("failed to update container %s: %v", c.Name, err)
+	}
+	if err := c.Dump(); err != nil {
+		return err
+	}
+
+	fmt.Println(c.Uuid)
+	return nil
+}
@@ -8,9 +8,56 @@ import (
 	"strings"
 
 	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/pkg/image"
 	"weike.sh/mydocker/util"
 )
 
+func init() {
+	// the image package can't import this package, so the
+	// references of images are counted by this function.
+	image.CountRefs = imageRefs
+}
+
+// imageRefs returns the number of containers using each image,
+// which is counted from the configs of all containers.
+func imageRefs() (map[string]int, error) {
+	allContainers, err := GetAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	return countImageRefs(allContainers), nil
+}
+
+// getImage returns the image of the container by its uuid, since its
+// name maybe tagged to another image since the container was created,
+// or by the name for the containers created by old versions.
+func (c *Container) getImage() (*image.Image, error) {
+	if c.ImageUuid != "" {
+		return image.GetImageByNameOrUuid(c.ImageUuid)
+	}
+	return image.GetImageByNameOrUuid(c.Image)
+}
+
+func countImageRefs(containers []*Container) map[string]int {
+	refs := make(map[string]int)
+	for _, c := range containers {
+		imgUuid := c.ImageUuid
+		// the containers created by old versions of
+		// mydocker only have the image name or uuid.
+		if imgUuid == "" {
+			img, err := image.GetImageByNameOrUuid(c.Image)
+			if err != nil {
+				log.Warnf("failed to get the image %s of container %s: %v", c.Image, c.Uuid, err)
+				continue
+			}
+			imgUuid = img.Uuid
+		}
+		refs[imgUuid]++
+	}
+
+	return refs
+}
+
 func sendInitCommand(cmds []string, writePipe *os.File) {
 	cmdsStr := strings.Join(cmds, "\u0000")
 	log.Debugf("runCommand sends user-defined command: %s",
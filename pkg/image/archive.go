@@ -0,0 +1,762 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"weike.sh/mydocker/util"
+)
+
+// the manifest.json of the tarballs created by `docker save`.
+type archiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// LoadArchive loads the images from the tarball read from r, which is
+// created by `docker save` (docker-archive), or an OCI image layout.
+// the loaded images are registered, and their repotags are returned.
+func LoadArchive(r io.Reader) ([]string, error) {
+	if err := os.MkdirAll(LayersDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to mkdir %s: %v", LayersDir, err)
+	}
+
+	// the entries of the tarball can be in any order, e.g. the
+	// manifest.json is the last one, so extract the whole first.
+	dir, err := ioutil.TempDir(LayersDir, "load-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir for loading: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy, err := LoadPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := extractArchive(dir, r); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case archiveFileExists(dir, "manifest.json"):
+		return loadDockerArchive(dir, policy)
+	case archiveFileExists(dir, "index.json"):
+		return loadOCILayout(dir, policy)
+	default:
+		return nil, fmt.Errorf("neither manifest.json nor index.json " +
+			"is found, the tarball isn't an image archive")
+	}
+}
+
+func loadDockerArchive(dir string, policy *Policy) ([]string, error) {
+	contents, err := readArchiveFile(dir, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []*archiveManifest
+	if err := json.Unmarshal(contents, &manifests); err != nil {
+		return nil, fmt.Errorf("failed to json-decode manifest.json: %v", err)
+	}
+
+	var loaded []string
+	for _, m := range manifests {
+		contents, err := readArchiveFile(dir, m.Config)
+		if err != nil {
+			return loaded, err
+		}
+		config, err := ParseConfig(contents)
+		if err != nil {
+			return loaded, err
+		}
+
+		diffIDs := config.RootFS.DiffIDs
+		if len(diffIDs) != len(m.Layers) {
+			return loaded, fmt.Errorf("the image %s has %d layers but %d diff_ids",
+				m.Config, len(m.Layers), len(diffIDs))
+		}
+
+		id := digestOf(contents)
+		repoTags, err := normalizeRepoTags(m.RepoTags)
+		if err != nil {
+			return loaded, err
+		}
+
+		for _, repoTag := range repoTags {
+			// the docker-archive has no manifests to be signed,
+			// so only the policy without signatures is allowed.
+			if _, err := policy.Verify(policyRef(repoTag), nil, nil); err != nil {
+				return loaded, err
+			}
+
+			img := NewImage(repoTag, id, config)
+			err := AddImage(img, contents, func() (int64, error) {
+				return img.makeLayers(diffIDs, shortDigests(diffIDs), "Loading layer",
+					func(idx int) (io.ReadCloser, error) {
+						return openArchiveFile(dir, m.Layers[idx])
+					})
+			})
+			if err != nil {
+				return loaded, fmt.Errorf("failed to load image %s: %v", repoTag, err)
+			}
+			loaded = append(loaded, repoTag)
+		}
+	}
+
+	return loaded, nil
+}
+
+func loadOCILayout(dir string, policy *Policy) ([]string, error) {
+	contents, err := readArchiveFile(dir, "index.json")
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Index{}
+	if err := json.Unmarshal(contents, index); err != nil {
+		return nil, fmt.Errorf("failed to json-decode index.json: %v", err)
+	}
+
+	var loaded []string
+	for _, desc := range index.Manifests {
+		repoTag := NoneRepoTag
+		name := ociRefName(desc)
+		// the signatures saved by cosign aren't images.
+		if signatureTagRegexp.MatchString(name) {
+			continue
+		}
+		// the ref.name annotation maybe only a tag without repo.
+		if strings.ContainsAny(name, ":/") {
+			ref, err := ParseReference(name)
+			if err != nil {
+				return loaded, err
+			}
+			repoTag = ref.RepoTag()
+		}
+
+		signer, err := policy.Verify(policyRef(repoTag), []string{desc.Digest},
+			func(digest string) ([]*Signature, error) {
+				return ociSignatures(dir, index, digest)
+			})
+		if err != nil {
+			return loaded, err
+		}
+
+		manifest, err := readOCIManifest(dir, desc)
+		if err != nil {
+			return loaded, err
+		}
+
+		contents, err := readBlob(dir, manifest.Config)
+		if err != nil {
+			return loaded, err
+		}
+		config, err := ParseConfig(contents)
+		if err != nil {
+			return loaded, err
+		}
+
+		diffIDs := config.RootFS.DiffIDs
+		if len(diffIDs) != len(manifest.Layers) {
+			return loaded, fmt.Errorf("the image %s has %d layers but %d diff_ids",
+				manifest.Config.Digest, len(manifest.Layers), len(diffIDs))
+		}
+
+		img := NewImage(repoTag, manifest.Config.Digest, config)
+		img.Digest, img.Signer = desc.Digest, signer
+		err = AddImage(img, contents, func() (int64, error) {
+			return img.makeLayers(diffIDs, shortDigests(diffIDs), "Loading layer",
+				func(idx int) (io.ReadCloser, error) {
+					return openBlob(dir, manifest.Layers[idx])
+				})
+		})
+		if err != nil {
+			return loaded, fmt.Errorf("failed to load image %s: %v", repoTag, err)
+		}
+		loaded = append(loaded, repoTag)
+	}
+
+	return loaded, nil
+}
+
+// ociRefName returns the name of the image in an OCI image layout,
+// which maybe only a tag without repo, e.g. the signatures of cosign.
+func ociRefName(desc *Descriptor) string {
+	if name := desc.Annotations[AnnotationContainerdRef]; name != "" {
+		return name
+	}
+	return desc.Annotations[AnnotationRefName]
+}
+
+// ociSignatures returns the cosign signatures of the digest in an
+// OCI image layout, which are saved as the image tagged by digest.
+func ociSignatures(dir string, index *Index, digest string) ([]*Signature, error) {
+	tag := SignatureTag(digest)
+	for _, desc := range index.Manifests {
+		name := ociRefName(desc)
+		if name != tag && !strings.HasSuffix(name, ":"+tag) {
+			continue
+		}
+
+		contents, err := readBlob(dir, desc)
+		if err != nil {
+			return nil, err
+		}
+		manifest := &Manifest{}
+		if err := json.Unmarshal(contents, manifest); err != nil {
+			return nil, fmt.Errorf("failed to json-decode signature manifest: %v", err)
+		}
+
+		return parseSignatures(manifest, func(layer *Descriptor) ([]byte, error) {
+			return readBlob(dir, layer)
+		})
+	}
+	return nil, nil
+}
+
+// policyRef returns the reference of repoTag checked by the
+// policy, which is nil if the image is unnamed.
+func policyRef(repoTag string) *Reference {
+	if repoTag == NoneRepoTag {
+		return nil
+	}
+	ref, _ := ParseReference(repoTag)
+	return ref
+}
+
+// readOCIManifest reads the manifest of the descriptor in an OCI
+// image layout, the manifest of current platform is selected if
+// the descriptor points to an image index.
+func readOCIManifest(dir string, desc *Descriptor) (*Manifest, error) {
+	contents, err := readBlob(dir, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	if desc.MediaType == MediaTypeOCIIndex || desc.MediaType == MediaTypeDockerManifestList {
+		index := &Index{}
+		if err := json.Unmarshal(contents, index); err != nil {
+			return nil, fmt.Errorf("failed to json-decode index %s: %v", desc.Digest, err)
+		}
+		selected, err := selectManifest(index, HostPlatform())
+		if err != nil {
+			return nil, err
+		}
+		return readOCIManifest(dir, selected)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("failed to json-decode manifest %s: %v", desc.Digest, err)
+	}
+	if manifest.Config == nil {
+		return nil, fmt.Errorf("the manifest %s has no image config", desc.Digest)
+	}
+	return manifest, nil
+}
+
+// openBlob opens the blob of the descriptor in an OCI image layout,
+// whose digest is verified when the whole contents are read.
+func openBlob(dir string, desc *Descriptor) (io.ReadCloser, error) {
+	if !digestRegexp.MatchString(desc.Digest) {
+		return nil, fmt.Errorf("unsupported digest: %s", desc.Digest)
+	}
+
+	file, err := openArchiveFile(dir, blobName(desc.Digest))
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := NewDigestReader(file, desc.Digest)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{verifier, file}, nil
+}
+
+func readBlob(dir string, desc *Descriptor) ([]byte, error) {
+	blob, err := openBlob(dir, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	contents, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %v", desc.Digest, err)
+	}
+	return contents, nil
+}
+
+// SaveArchive writes the images to w as a tarball of the format,
+// which is either docker-archive or OCI image layout.
+func SaveArchive(w io.Writer, identifiers []string, format string) error {
+	if format != FormatDocker && format != FormatOCI {
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+
+	// key is the image id, value is the repotags to be saved.
+	var ids []string
+	repoTags := map[string][]string{}
+	images := map[string]*Image{}
+	for _, identifier := range identifiers {
+		img, err := GetImageByNameOrUuid(identifier)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := images[img.Id]; !ok {
+			ids = append(ids, img.Id)
+			images[img.Id] = img
+		}
+
+		// save all the repotags if the image is specified by uuid.
+		for _, other := range Images {
+			if other.Uuid != img.Uuid || other.RepoTag == NoneRepoTag {
+				continue
+			}
+			if util.Contains(repoTags[img.Id], other.RepoTag) {
+				continue
+			}
+			if other == img || img.Uuid == identifier {
+				repoTags[img.Id] = append(repoTags[img.Id], other.RepoTag)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(LayersDir, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir %s: %v", LayersDir, err)
+	}
+	tmpDir, err := ioutil.TempDir(LayersDir, "save-")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir for saving: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	aw := &archiveWriter{
+		tw:      tar.NewWriter(w),
+		tmpDir:  tmpDir,
+		written: map[string]bool{},
+		packed:  map[string]string{},
+		diffIDs: map[string]string{},
+	}
+
+	var manifests []*archiveManifest
+	index := &Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex}
+	for _, id := range ids {
+		img := images[id]
+		config, diffIDs, err := aw.packImage(img)
+		if err != nil {
+			return err
+		}
+		configDigest := digestOf(config)
+
+		switch format {
+		case FormatDocker:
+			m := &archiveManifest{
+				Config:   strings.TrimPrefix(configDigest, "sha256:") + ".json",
+				RepoTags: repoTags[id],
+			}
+			if err := aw.writeBytes(m.Config, config); err != nil {
+				return err
+			}
+			for idx, diffID := range diffIDs {
+				name := path.Join(strings.TrimPrefix(diffID, "sha256:"), "layer.tar")
+				if err := aw.writeFile(name, aw.packed[img.Layers[idx]]); err != nil {
+					return err
+				}
+				m.Layers = append(m.Layers, name)
+			}
+			manifests = append(manifests, m)
+
+		case FormatOCI:
+			manifest := &Manifest{
+				SchemaVersion: 2,
+				MediaType:     MediaTypeOCIManifest,
+				Config: &Descriptor{
+					MediaType: MediaTypeOCIConfig,
+					Digest:    configDigest,
+					Size:      int64(len(config)),
+				},
+			}
+			if err := aw.writeBytes(blobName(configDigest), config); err != nil {
+				return err
+			}
+			for idx, diffID := range diffIDs {
+				file := aw.packed[img.Layers[idx]]
+				fi, err := os.Stat(file)
+				if err != nil {
+					return err
+				}
+				if err := aw.writeFile(blobName(diffID), file); err != nil {
+					return err
+				}
+				manifest.Layers = append(manifest.Layers, &Descriptor{
+					MediaType: MediaTypeOCILayer,
+					Digest:    diffID,
+					Size:      fi.Size(),
+				})
+			}
+
+			contents, err := json.Marshal(manifest)
+			if err != nil {
+				return fmt.Errorf("failed to json-encode manifest: %v", err)
+			}
+			if err := aw.writeBytes(blobName(digestOf(contents)), contents); err != nil {
+				return err
+			}
+
+			desc := &Descriptor{
+				MediaType: MediaTypeOCIManifest,
+				Digest:    digestOf(contents),
+				Size:      int64(len(contents)),
+			}
+			if len(repoTags[id]) == 0 {
+				index.Manifests = append(index.Manifests, desc)
+			}
+			for _, repoTag := range repoTags[id] {
+				tagged := *desc
+				tagged.Annotations = map[string]string{
+					AnnotationContainerdRef: repoTag,
+					AnnotationRefName:       repoTag[strings.LastIndex(repoTag, ":")+1:],
+				}
+				index.Manifests = append(index.Manifests, &tagged)
+			}
+		}
+	}
+
+	switch format {
+	case FormatDocker:
+		contents, err := json.Marshal(manifests)
+		if err != nil {
+			return fmt.Errorf("failed to json-encode manifest.json: %v", err)
+		}
+		if err := aw.writeBytes("manifest.json", contents); err != nil {
+			return err
+		}
+	case FormatOCI:
+		contents, err := json.Marshal(index)
+		if err != nil {
+			return fmt.Errorf("failed to json-encode index.json: %v", err)
+		}
+		if err := aw.writeBytes("index.json", contents); err != nil {
+			return err
+		}
+		if err := aw.writeBytes("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+			return err
+		}
+	}
+
+	return aw.tw.Close()
+}
+
+type archiveWriter struct {
+	tw     *tar.Writer
+	tmpDir string
+	// the names which have been written into the tarball.
+	written map[string]bool
+	// key is the chain id, value is the packed layer tarball.
+	packed map[string]string
+	// key is the chain id, value is the diffID of packed tarball.
+	diffIDs map[string]string
+}
+
+// packImage packs the layers of the image into temp tarballs, and
+// returns the image config and diffIDs of the packed tarballs.
+// the packed tarballs maybe differ from the original ones, e.g. the
+// order of entries, then the diff_ids of the config are replaced,
+// and the image id changes consequently.
+func (aw *archiveWriter) packImage(img *Image) ([]byte, []string, error) {
+	config, contents, err := img.LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := LoadLayers(); err != nil {
+		return nil, nil, err
+	}
+
+	var diffIDs []string
+	for _, chainID := range img.Layers {
+		l, ok := Layers[chainID]
+		if !ok {
+			return nil, nil, fmt.Errorf("the layer %s of image %s doesn't exist",
+				chainID, img.RepoTag)
+		}
+
+		if _, ok := aw.packed[chainID]; !ok {
+			file := path.Join(aw.tmpDir, strings.TrimPrefix(chainID, "sha256:")+".tar")
+			diffID, err := packLayerFile(l, file)
+			if err != nil {
+				return nil, nil, err
+			}
+			aw.packed[chainID] = file
+			aw.diffIDs[chainID] = diffID
+		}
+		diffIDs = append(diffIDs, aw.diffIDs[chainID])
+	}
+
+	if strings.Join(diffIDs, ",") == strings.Join(config.RootFS.DiffIDs, ",") {
+		return contents, diffIDs, nil
+	}
+
+	// only replace the diff_ids to keep the unknown fields.
+	aux := map[string]json.RawMessage{}
+	if err := json.Unmarshal(contents, &aux); err != nil {
+		return nil, nil, fmt.Errorf("failed to json-decode image config: %v", err)
+	}
+	rootfs, err := json.Marshal(&RootFS{Type: "layers", DiffIDs: diffIDs})
+	if err != nil {
+		return nil, nil, err
+	}
+	aux["rootfs"] = rootfs
+	if contents, err = json.Marshal(aux); err != nil {
+		return nil, nil, fmt.Errorf("failed to json-encode image config: %v", err)
+	}
+
+	return contents, diffIDs, nil
+}
+
+func packLayerFile(l *Layer, file string) (string, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %v", file, err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if err := PackLayer(l.Dir(), io.MultiWriter(f, hash), l.Whiteout); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), f.Close()
+}
+
+func (aw *archiveWriter) writeBytes(name string, contents []byte) error {
+	if aw.written[name] {
+		return nil
+	}
+	if err := aw.writeDirs(name); err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(contents)),
+	}
+	if err := aw.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s into the tarball: %v", name, err)
+	}
+	if _, err := aw.tw.Write(contents); err != nil {
+		return fmt.Errorf("failed to write %s into the tarball: %v", name, err)
+	}
+
+	aw.written[name] = true
+	return nil
+}
+
+func (aw *archiveWriter) writeFile(name, file string) error {
+	if aw.written[name] {
+		return nil
+	}
+	if err := aw.writeDirs(name); err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     fi.Size(),
+	}
+	if err := aw.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s into the tarball: %v", name, err)
+	}
+	if _, err := io.Copy(aw.tw, f); err != nil {
+		return fmt.Errorf("failed to write %s into the tarball: %v", name, err)
+	}
+
+	aw.written[name] = true
+	return nil
+}
+
+// writeDirs writes the parent dirs of name into the tarball.
+func (aw *archiveWriter) writeDirs(name string) error {
+	dir := path.Dir(name)
+	if dir == "." || aw.written[dir+"/"] {
+		return nil
+	}
+	if err := aw.writeDirs(dir); err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:     dir + "/",
+		Typeflag: tar.TypeDir,
+		Mode:     0755,
+	}
+	if err := aw.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s into the tarball: %v", dir, err)
+	}
+
+	aw.written[dir+"/"] = true
+	return nil
+}
+
+// extractArchive extracts the regular files, dirs and symlinks of the
+// tarball into dir, e.g. the duplicated layers maybe symlinks.
+func extractArchive(dir string, r io.Reader) error {
+	reader, err := Decompress(r)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(reader)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read the tarball: %v", err)
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+
+		parent, base := path.Split(name)
+		parentDir, err := util.SecureJoin(dir, parent)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return fmt.Errorf("failed to mkdir %s: %v", parentDir, err)
+		}
+
+		target := path.Join(parentDir, base)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeFile(target, tr)
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, target)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to extract %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func writeFile(target string, r io.Reader) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// openArchiveFile opens the file in the extracted archive, the
+// symlinks are resolved in the scope of the archive's dir.
+func openArchiveFile(dir, name string) (*os.File, error) {
+	file, err := util.SecureJoin(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s in the archive: %v", name, err)
+	}
+	return f, nil
+}
+
+func readArchiveFile(dir, name string) ([]byte, error) {
+	f, err := openArchiveFile(dir, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	contents, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in the archive: %v", name, err)
+	}
+	return contents, nil
+}
+
+func archiveFileExists(dir, name string) bool {
+	file, err := util.SecureJoin(dir, name)
+	if err != nil {
+		return false
+	}
+	exist, _ := util.FileOrDirExists(file)
+	return exist
+}
+
+func normalizeRepoTags(names []string) ([]string, error) {
+	if len(names) == 0 {
+		return []string{NoneRepoTag}, nil
+	}
+
+	var repoTags []string
+	for _, name := range names {
+		ref, err := ParseReference(name)
+		if err != nil {
+			return nil, err
+		}
+		repoTags = append(repoTags, ref.RepoTag())
+	}
+	return repoTags, nil
+}
+
+// e.g. sha256:8ee3...f0e9 => blobs/sha256/8ee3...f0e9
+func blobName(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+func digestOf(contents []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(contents))
+}
+
+func shortDigests(digests []string) []string {
+	var shorts []string
+	for _, digest := range digests {
+		shorts = append(shorts, shortDigest(digest))
+	}
+	return shorts
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoadArchive(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("loading images requires root")
+	}
+
+	for _, format := range []string{FormatDocker, FormatOCI} {
+		t.Run(format, func(t *testing.T) {
+			setupImagesDir(t)
+			server, _ := newTestRegistry(t, "test/app", "v1")
+			defer server.Close()
+
+			imageName := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+			if err := Pull(imageName, ""); err != nil {
+				t.Fatalf("failed to pull image: %v", err)
+			}
+			pulled, err := GetImageByNameOrUuid(imageName)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			buf := &bytes.Buffer{}
+			if err := SaveArchive(buf, []string{imageName}, format); err != nil {
+				t.Fatalf("failed to save image: %v", err)
+			}
+			if err := Delete(imageName, false); err != nil {
+				t.Fatal(err)
+			}
+
+			repoTags, err := LoadArchive(buf)
+			if err != nil {
+				t.Fatalf("failed to load image: %v", err)
+			}
+			if len(repoTags) != 1 || repoTags[0] != imageName {
+				t.Fatalf("unexpected loaded images: %v", repoTags)
+			}
+
+			loaded, err := GetImageByNameOrUuid(imageName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.WorkingDir != pulled.WorkingDir || len(loaded.Layers) != 2 {
+				t.Errorf("unexpected loaded image: %+v", loaded)
+			}
+
+			dirs, err := loaded.LowerDirs()
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents, err := ioutil.ReadFile(path.Join(dirs[0], "opt/app/new"))
+			if err != nil || string(contents) != "new" {
+				t.Errorf("unexpected contents of opt/app/new: %q, %v", contents, err)
+			}
+			if !IsOpaque(path.Join(dirs[0], "opt/app")) && loaded.Whiteout() == WhiteoutOverlay {
+				t.Errorf("the opaque whiteout of opt/app is lost")
+			}
+		})
+	}
+}
+
+func TestLoadInvalidArchive(t *testing.T) {
+	setupImagesDir(t)
+
+	blob, _ := makeLayer(t, []testFile{{name: "hello", contents: "world"}})
+	if _, err := LoadArchive(bytes.NewReader(blob)); err == nil {
+		t.Errorf("expected error when loading a non-image tarball")
+	}
+}
@@ -0,0 +1,337 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"weike.sh/mydocker/util"
+)
+
+// ApplyChanges applies the dockerfile-like instructions onto the
+// container config, e.g. `CMD ["nginx", "-g", "daemon off;"]`, only
+// CMD, ENTRYPOINT, ENV, WORKDIR, USER, LABEL, EXPOSE and STOPSIGNAL
+// are supported.
+func ApplyChanges(config *ContainerConfig, changes []string) error {
+	for _, change := range changes {
+		change = strings.TrimSpace(change)
+		fields := strings.SplitN(change, " ", 2)
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			return fmt.Errorf("invalid change %q, should be 'INSTRUCTION args'", change)
+		}
+		args := strings.TrimSpace(fields[1])
+
+		switch strings.ToUpper(fields[0]) {
+		case "CMD":
+			config.Cmd = ParseCommand(args)
+		case "ENTRYPOINT":
+			config.Entrypoint = ParseCommand(args)
+		case "WORKDIR":
+			// the relative path is relative to the previous WORKDIR.
+			config.WorkingDir = path.Join("/", config.WorkingDir, args)
+		case "USER":
+			config.User = args
+		case "ENV":
+			envs, err := ParseEnvs(args)
+			if err != nil {
+				return err
+			}
+			config.Env = MergeEnvs(config.Env, envs)
+		case "LABEL":
+			labels, err := ParseEnvs(args)
+			if err != nil {
+				return err
+			}
+			if config.Labels == nil {
+				config.Labels = map[string]string{}
+			}
+			for _, label := range labels {
+				kv := strings.SplitN(label, "=", 2)
+				config.Labels[kv[0]] = kv[1]
+			}
+		case "EXPOSE":
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = map[string]struct{}{}
+			}
+			for _, port := range strings.Fields(args) {
+				port, err := parsePort(port)
+				if err != nil {
+					return err
+				}
+				config.ExposedPorts[port] = struct{}{}
+			}
+		case "STOPSIGNAL":
+			if _, err := util.ParseSignal(args); err != nil {
+				return err
+			}
+			config.StopSignal = args
+		default:
+			return fmt.Errorf("unsupported change instruction: %s", fields[0])
+		}
+	}
+
+	return nil
+}
+
+// ParseCommand parses the args of CMD or ENTRYPOINT, both the exec
+// form `["executable", "param"]` and the shell form are supported.
+func ParseCommand(args string) []string {
+	var cmds []string
+	if strings.HasPrefix(args, "[") {
+		if err := json.Unmarshal([]byte(args), &cmds); err == nil {
+			return cmds
+		}
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// ParseEnvs parses the args of ENV or LABEL, which are either
+// `key=value ...` or the legacy form `key value`, e.g. ENV PATH /bin
+// the values maybe quoted, e.g. ENV MSG="hello world"
+func ParseEnvs(args string) ([]string, error) {
+	fields := strings.Fields(args)
+	if !strings.Contains(fields[0], "=") {
+		value := strings.TrimSpace(strings.TrimPrefix(args, fields[0]))
+		if value == "" {
+			return nil, fmt.Errorf("missing value of env %s", fields[0])
+		}
+		return []string{fields[0] + "=" + value}, nil
+	}
+
+	words, err := SplitWords(args)
+	if err != nil {
+		return nil, err
+	}
+
+	var envs []string
+	for _, word := range words {
+		if strings.Index(word, "=") <= 0 {
+			return nil, fmt.Errorf("invalid env %q, should be key=value", word)
+		}
+		envs = append(envs, word)
+	}
+	return envs, nil
+}
+
+// SplitWords splits the args by whitespaces like shell, the quotes
+// are removed, and the whitespaces in quotes or escaped are kept.
+func SplitWords(args string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(args)
+	for idx := 0; idx < len(runes); idx++ {
+		ch := runes[idx]
+		switch {
+		case ch == '\\' && quote != '\'' && idx+1 < len(runes):
+			idx++
+			word.WriteRune(runes[idx])
+			inWord = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				word.WriteRune(ch)
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+			inWord = true
+		case unicode.IsSpace(ch):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", args)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// parsePort normalizes the port of EXPOSE, e.g. 80 to 80/tcp.
+func parsePort(port string) (string, error) {
+	fields := strings.SplitN(port, "/", 2)
+	proto := "tcp"
+	if len(fields) == 2 {
+		proto = strings.ToLower(fields[1])
+	}
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", fmt.Errorf("invalid protocol of port %s", port)
+	}
+	if portNum, err := strconv.Atoi(fields[0]); err != nil || portNum <= 0 || portNum > 65535 {
+		return "", fmt.Errorf("invalid port %s", port)
+	}
+	return fields[0] + "/" + proto, nil
+}
+
+// MergeEnvs overrides the envs with the same keys in order,
+// and appends the envs whose keys don't exist.
+func MergeEnvs(envs, overrides []string) []string {
+	merged := append([]string{}, envs...)
+	for _, override := range overrides {
+		key := strings.SplitN(override, "=", 2)[0]
+		found := false
+		for idx, env := range merged {
+			if strings.SplitN(env, "=", 2)[0] == key {
+				merged[idx] = override
+				found = true
+			}
+		}
+		if !found {
+			merged = append(merged, override)
+		}
+	}
+	return merged
+}
+
+// NewConfig derives a new image config from the base, the container
+// config is replaced and the layer of diffID is appended onto the
+// rootfs, a history entry is recorded with createdBy and comment.
+// the unknown fields of the base config are kept as they are.
+func NewConfig(base []byte, config *ContainerConfig, diffID, createdBy, comment string) ([]byte, error) {
+	// the base is empty if the image is imported from scratch.
+	aux := map[string]json.RawMessage{}
+	baseConfig := &ImageConfig{Config: &ContainerConfig{}, RootFS: &RootFS{}}
+	if len(base) > 0 {
+		if err := json.Unmarshal(base, &aux); err != nil {
+			return nil, fmt.Errorf("failed to json-decode image config: %v", err)
+		}
+		var err error
+		if baseConfig, err = ParseConfig(base); err != nil {
+			return nil, err
+		}
+	}
+
+	// only replace the fields of the container config known by
+	// mydocker, e.g. the Labels of the base image are inherited.
+	containerConfig := map[string]interface{}{}
+	if raw, ok := aux["config"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &containerConfig); err != nil {
+			return nil, fmt.Errorf("failed to json-decode container config: %v", err)
+		}
+	}
+	containerConfig["User"] = config.User
+	containerConfig["Env"] = config.Env
+	containerConfig["Entrypoint"] = config.Entrypoint
+	containerConfig["Cmd"] = config.Cmd
+	containerConfig["WorkingDir"] = config.WorkingDir
+	// the labels and ports are kept if they aren't changed.
+	if config.Labels != nil {
+		containerConfig["Labels"] = config.Labels
+	}
+	if config.ExposedPorts != nil {
+		containerConfig["ExposedPorts"] = config.ExposedPorts
+	}
+	if config.StopSignal != "" {
+		containerConfig["StopSignal"] = config.StopSignal
+	}
+
+	created := time.Now().UTC().Format(time.RFC3339Nano)
+	var history []json.RawMessage
+	if raw, ok := aux["history"]; ok {
+		if err := json.Unmarshal(raw, &history); err != nil {
+			return nil, fmt.Errorf("failed to json-decode image history: %v", err)
+		}
+	}
+
+	entry := map[string]interface{}{
+		"created":    created,
+		"created_by": createdBy,
+	}
+	if comment != "" {
+		entry["comment"] = comment
+	}
+	if diffID == "" {
+		entry["empty_layer"] = true
+	}
+
+	diffIDs := baseConfig.RootFS.DiffIDs
+	if diffID != "" {
+		diffIDs = append(diffIDs, diffID)
+	}
+
+	fields := map[string]interface{}{
+		"created": created,
+		"config":  containerConfig,
+		"rootfs":  &RootFS{Type: "layers", DiffIDs: diffIDs},
+		"history": append(history, mustMarshal(entry)),
+	}
+	for key, value := range fields {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to json-encode %s of image config: %v", key, err)
+		}
+		aux[key] = raw
+	}
+	if _, ok := aux["architecture"]; !ok {
+		aux["architecture"] = mustMarshal(baseConfig.Architecture)
+		aux["os"] = mustMarshal(baseConfig.OS)
+	}
+
+	contents, err := json.Marshal(aux)
+	if err != nil {
+		return nil, fmt.Errorf("failed to json-encode image config: %v", err)
+	}
+	return contents, nil
+}
+
+// CreateImage registers the image named repoTag with the config,
+// all the layers of the config must exist in the layer store.
+func CreateImage(repoTag string, contents []byte) (*Image, error) {
+	config, err := ParseConfig(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if repoTag != NoneRepoTag {
+		ref, err := ParseReference(repoTag)
+		if err != nil {
+			return nil, err
+		}
+		repoTag = ref.RepoTag()
+	}
+
+	img := NewImage(repoTag, digestOf(contents), config)
+	err = AddImage(img, contents, func() (int64, error) {
+		if err := LoadLayers(); err != nil {
+			return 0, err
+		}
+
+		var size int64
+		for _, chainID := range ChainIDs(config.RootFS.DiffIDs) {
+			l, ok := Layers[chainID]
+			if !ok {
+				return 0, fmt.Errorf("no such layer: %s", chainID)
+			}
+			size += l.Size
+		}
+
+		img.Layers = ChainIDs(config.RootFS.DiffIDs)
+		return size, AcquireLayers(img.Layers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	raw, _ := json.Marshal(v)
+	return raw
+}
@@ -0,0 +1,155 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestApplyChanges(t *testing.T) {
+	config := &ContainerConfig{
+		Env: []string{"PATH=/bin", "HOME=/root"},
+		Cmd: []string{"sh"},
+	}
+	changes := []string{
+		`CMD ["nginx", "-g", "daemon off;"]`,
+		"ENTRYPOINT /entrypoint.sh",
+		"ENV PATH=/usr/bin LANG=C",
+		"env TZ Asia/Shanghai",
+		"WORKDIR /app",
+		"WORKDIR www",
+		`ENV MSG="hello world" QUOTE=it\'s`,
+		`LABEL version=1.0 "description"="a web app"`,
+		"EXPOSE 80 53/udp",
+		"STOPSIGNAL SIGQUIT",
+	}
+	if err := ApplyChanges(config, changes); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ContainerConfig{
+		Env: []string{"PATH=/usr/bin", "HOME=/root", "LANG=C",
+			"TZ=Asia/Shanghai", "MSG=hello world", "QUOTE=it's"},
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Entrypoint:   []string{"/bin/sh", "-c", "/entrypoint.sh"},
+		WorkingDir:   "/app/www",
+		Labels:       map[string]string{"version": "1.0", "description": "a web app"},
+		ExposedPorts: map[string]struct{}{"80/tcp": {}, "53/udp": {}},
+		StopSignal:   "SIGQUIT",
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("unexpected config: %+v", config)
+	}
+
+	for _, change := range []string{"CMD", "RUN make", "ENV =1", "EXPOSE 80/icmp", `ENV A="b`, "STOPSIGNAL SIGFOO"} {
+		if err := ApplyChanges(config, []string{change}); err == nil {
+			t.Errorf("expected error when applying %q", change)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	words, err := SplitWords(`a=1  b="x y" c='$z' d=e\ f`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a=1", "b=x y", "c=$z", "d=e f"}
+	if !reflect.DeepEqual(words, expected) {
+		t.Errorf("unexpected words: %q", words)
+	}
+
+	if _, err := SplitWords(`a='b`); err == nil {
+		t.Errorf("expected error with unterminated quote")
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	base := []byte(`{"architecture":"amd64","os":"linux",
+		"config":{"Cmd":["sh"],"Labels":{"a":"b"}},
+		"rootfs":{"type":"layers","diff_ids":["sha256:1"]},
+		"history":[{"created_by":"base"}]}`)
+
+	contents, err := NewConfig(base, &ContainerConfig{Cmd: []string{"top"}}, "sha256:2", "commit", "msg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aux := &struct {
+		Architecture string
+		Config       struct {
+			Cmd    []string
+			Labels map[string]string
+		}
+		RootFS  *RootFS `json:"rootfs"`
+		History []map[string]interface{}
+	}{}
+	if err := json.Unmarshal(contents, aux); err != nil {
+		t.Fatal(err)
+	}
+
+	if aux.Architecture != "amd64" || aux.Config.Cmd[0] != "top" || aux.Config.Labels["a"] != "b" {
+		t.Errorf("the base config isn't inherited: %s", contents)
+	}
+	if !reflect.DeepEqual(aux.RootFS.DiffIDs, []string{"sha256:1", "sha256:2"}) {
+		t.Errorf("unexpected diff_ids: %v", aux.RootFS.DiffIDs)
+	}
+	if len(aux.History) != 2 || aux.History[1]["comment"] != "msg" {
+		t.Errorf("unexpected history: %v", aux.History)
+	}
+}
+
+func TestPackLayer(t *testing.T) {
+	// the whiteouts of overlay are char devices and trusted xattrs.
+	if os.Geteuid() != 0 {
+		t.Skip("creating overlay whiteouts requires root")
+	}
+
+	dir, err := ioutil.TempDir("", "mydocker-layer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(path.Join(dir, "etc"), 0755)
+	os.MkdirAll(path.Join(dir, "opt/app"), 0755)
+	ioutil.WriteFile(path.Join(dir, "etc/hosts"), []byte("127.0.0.1 localhost"), 0644)
+	ioutil.WriteFile(path.Join(dir, "opt/app/new"), []byte("new"), 0644)
+	if err := syscall.Mknod(path.Join(dir, "etc/deleted"), syscall.S_IFCHR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(path.Join(dir, "opt/app"), OverlayOpaque, []byte("y"), 0); err != nil {
+		t.Skipf("the fs doesn't support trusted xattrs: %v", err)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := PackLayer(dir, buf, WhiteoutOverlay, "etc/hosts"); err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+
+	expected := []string{"etc/", "etc/.wh.deleted", "opt/", "opt/app/",
+		"opt/app/.wh..wh..opq", "opt/app/new"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("unexpected entries of packed layer: %v", names)
+	}
+}
@@ -6,9 +6,114 @@ const (
 	MyDockerDir = "/var/lib/mydocker"
 )
 
+const (
+	// the familiar image name `ubuntu` is short for
+	// the fully qualified name `docker.io/library/ubuntu`
+	DefaultDomain   = "docker.io"
+	DefaultRegistry = "registry-1.docker.io"
+	OfficialRepo    = "library"
+	DefaultTag      = "latest"
+)
+
+// only the following manifest formats of image-spec are supported.
+// ref: https://github.com/opencontainers/image-spec/blob/main/media-types.md
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	MediaTypeDockerConfig       = "application/vnd.docker.container.image.v1+json"
+	MediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar"
+)
+
+// the annotations of image names in OCI image layouts.
+const (
+	AnnotationRefName       = "org.opencontainers.image.ref.name"
+	AnnotationContainerdRef = "io.containerd.image.name"
+)
+
+// the formats of image archives supported by load and save.
+const (
+	FormatDocker = "docker"
+	FormatOCI    = "oci"
+)
+
+// the requirement types of the policy for images, which are
+// named like containers-policy.json(5) of containers/image.
+const (
+	PolicyAccept = "insecureAcceptAnything"
+	PolicyReject = "reject"
+	PolicySigned = "sigstoreSigned"
+)
+
+// the signatures of cosign are stored as an image tagged by the
+// signed digest, whose layers are the simple signing payloads.
+// ref: https://github.com/sigstore/cosign/blob/main/specs/SIGNATURE_SPEC.md
+const (
+	MediaTypeCosignPayload = "application/vnd.dev.cosign.simplesigning.v1+json"
+	AnnotationCosignSig    = "dev.cosignproject.cosign/signature"
+	CosignSignatureType    = "cosign container image signature"
+	CosignSignatureSuffix  = ".sig"
+)
+
+// the repotag of images whose names are unknown or taken by others.
+const NoneRepoTag = "<none>:<none>"
+
+// whiteout files mark the files deleted from the lower layers.
+// ref: https://github.com/opencontainers/image-spec/blob/main/layer.md#whiteouts
+const (
+	WhiteoutPrefix = ".wh."
+	WhiteoutOpaque = ".wh..wh..opq"
+)
+
+// the formats of whiteouts in the extracted layers, aufs uses
+// the same format as the layer tarballs, while overlay uses a
+// 0/0 char device for a whiteout file, and an xattr for the
+// opaque dir. ref: https://docs.kernel.org/filesystems/overlayfs.html
+const (
+	WhiteoutAufs    = "aufs"
+	WhiteoutOverlay = "overlay"
+	OverlayOpaque   = "trusted.overlay.opaque"
+)
+
 var (
 	ImagesDir        = path.Join(MyDockerDir, "images")
 	ImagesConfigFile = path.Join(ImagesDir, "repositories.json")
+	LayersDir        = path.Join(MyDockerDir, "layers")
+	LayersConfigFile = path.Join(LayersDir, "layers.json")
+	// the original image configs, named by their sha256 digests.
+	ImageDBDir = path.Join(MyDockerDir, "imagedb")
+	// the policy of the images which can be pulled or loaded,
+	// all images are accepted if the policy file doesn't exist.
+	PolicyFile = "/etc/mydocker/policy.json"
 )
 
 var Images []*Image
+
+// key is the layer's chain id, value is a Layer instance.
+var Layers = map[string]*Layer{}
+
+// SetStoreDir moves the stores of images and layers into dir, which
+// is used by the tests of the packages creating images, and returns
+// the function to restore them.
+func SetStoreDir(dir string) func() {
+	oldImagesDir, oldConfigFile := ImagesDir, ImagesConfigFile
+	oldLayersDir, oldLayersFile := LayersDir, LayersConfigFile
+	oldImageDBDir := ImageDBDir
+	ImagesDir = path.Join(dir, "images")
+	ImagesConfigFile = path.Join(ImagesDir, "repositories.json")
+	LayersDir = path.Join(dir, "layers")
+	LayersConfigFile = path.Join(LayersDir, "layers.json")
+	ImageDBDir = path.Join(dir, "imagedb")
+	Images = nil
+	Layers = map[string]*Layer{}
+
+	return func() {
+		ImagesDir, ImagesConfigFile = oldImagesDir, oldConfigFile
+		LayersDir, LayersConfigFile = oldLayersDir, oldLayersFile
+		ImageDBDir = oldImageDBDir
+		Images = nil
+		Layers = map[string]*Layer{}
+	}
+}
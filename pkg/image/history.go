@@ -0,0 +1,97 @@
+package image
+
+import (
+	"fmt"
+	"time"
+)
+
+// HistoryEntry is a step of the image's history, Id is the uuid of
+// the image created by the step if it exists on this host, Size is
+// the size of the layer created by the step.
+type HistoryEntry struct {
+	Id        string
+	Created   string
+	CreatedBy string
+	Comment   string
+	Size      int64
+}
+
+// History returns the history of the image from top to bottom, the
+// layers are matched with the history entries which aren't empty.
+func (img *Image) History() ([]*HistoryEntry, error) {
+	config, _, err := img.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := LoadLayers(); err != nil {
+		return nil, err
+	}
+
+	history := config.History
+	// the history is optional in the image config, so
+	// make an anonymous entry for each layer if it's missing.
+	if len(history) == 0 {
+		for range config.RootFS.DiffIDs {
+			history = append(history, &History{})
+		}
+	}
+
+	// the intermediate images of build are the parents, each
+	// of which is created by a step, i.e. a history entry.
+	ids := map[int]string{}
+	parentId := img.Id
+	for idx := len(history) - 1; idx >= 0 && parentId != ""; idx-- {
+		parent := getImageById(parentId)
+		if parent == nil {
+			break
+		}
+		ids[idx] = parent.Uuid
+		parentId = parent.Parent
+	}
+
+	var entries []*HistoryEntry
+	layerIdx := 0
+	for idx, h := range history {
+		entry := &HistoryEntry{
+			Id:        ids[idx],
+			Created:   formatCreated(h.Created),
+			CreatedBy: h.CreatedBy,
+			Comment:   h.Comment,
+		}
+		if entry.Id == "" {
+			entry.Id = "<missing>"
+		}
+
+		if !h.EmptyLayer {
+			if layerIdx >= len(img.Layers) {
+				return nil, fmt.Errorf("the history of image %s doesn't match its layers", img.RepoTag)
+			}
+			if l, ok := Layers[img.Layers[layerIdx]]; ok {
+				entry.Size = l.Size
+			}
+			layerIdx++
+		}
+
+		entries = append([]*HistoryEntry{entry}, entries...)
+	}
+
+	return entries, nil
+}
+
+func getImageById(id string) *Image {
+	for _, img := range Images {
+		if img.Id == id {
+			return img
+		}
+	}
+	return nil
+}
+
+// e.g. 2019-03-01T02:03:04.123456789Z => 2019-03-01 10:03:04 (UTC+8)
+func formatCreated(created string) string {
+	t, err := time.Parse(time.RFC3339Nano, created)
+	if err != nil {
+		return created
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
@@ -1,53 +1,203 @@
 package image
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
+	"strings"
 
-	"github.com/Pallinder/go-randomdata"
 	"weike.sh/mydocker/util"
 )
 
+// ParseConfig json-decodes the image config, the optional
+// fields used by mydocker are ensured to be non-nil.
+func ParseConfig(contents []byte) (*ImageConfig, error) {
+	config := &ImageConfig{}
+	if err := json.Unmarshal(contents, config); err != nil {
+		return nil, fmt.Errorf("failed to json-decode image config: %v", err)
+	}
+	if config.Config == nil {
+		config.Config = &ContainerConfig{}
+	}
+	if config.RootFS == nil {
+		config.RootFS = &RootFS{}
+	}
+	return config, nil
+}
+
+// RootDir returns the flattened rootfs of the image, which is
+// only used by the images pulled before the layer store exists.
 func (img *Image) RootDir() string {
 	return path.Join(ImagesDir, img.Uuid)
 }
 
-func (img *Image) MakeRootfs() error {
-	var cmd *exec.Cmd
+// ConfigFile returns the file of the original image config,
+// which is kept to save the image with the same image id.
+func (img *Image) ConfigFile() string {
+	return path.Join(ImageDBDir, strings.TrimPrefix(img.Id, "sha256:")+".json")
+}
 
-	containerName := randomdata.SillyName()
-	cmd = exec.Command("docker", "run", "-d", "--name", containerName, img.Uuid)
-	defer func() {
-		exec.Command("docker", "rm", "-f", containerName).Run()
-	}()
+// LoadConfig returns the original image config and its contents.
+func (img *Image) LoadConfig() (*ImageConfig, []byte, error) {
+	if img.Id == "" {
+		return nil, nil, fmt.Errorf("the image %s was pulled by an old version "+
+			"of mydocker, please remove and pull it again", img.RepoTag)
+	}
+
+	contents, err := ioutil.ReadFile(img.ConfigFile())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config of image %s: %v", img.RepoTag, err)
+	}
+
+	config, err := ParseConfig(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, contents, nil
+}
 
-	if err := cmd.Run(); err != nil {
-		return err
+func (img *Image) saveConfig(contents []byte) error {
+	if err := os.MkdirAll(ImageDBDir, 0755); err != nil {
+		return fmt.Errorf("failed to mkdir %s: %v", ImageDBDir, err)
+	}
+	if err := util.WriteFileAtomic(img.ConfigFile(), contents, 0644); err != nil {
+		return fmt.Errorf("failed to write config of image %s: %v", img.RepoTag, err)
 	}
+	return nil
+}
 
-	rootfsTarFile := fmt.Sprintf("/tmp/%s.tar", containerName)
-	cmd = exec.Command("docker", "export", "-o", rootfsTarFile, containerName)
-	defer func() {
-		os.Remove(rootfsTarFile)
-	}()
+// LowerDirs returns the dirs of the image's layers from top to
+// bottom, which is the order of lowerdirs of the union mount.
+func (img *Image) LowerDirs() ([]string, error) {
+	if img.Id == "" {
+		return []string{img.RootDir()}, nil
+	}
+	// e.g. the image built from scratch without any files.
+	if len(img.Layers) == 0 {
+		return nil, fmt.Errorf("the image %s has no layers", img.RepoTag)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return err
+	if err := LoadLayers(); err != nil {
+		return nil, err
 	}
 
-	if exist, _ := util.FileOrDirExists(img.RootDir()); !exist {
-		if err := os.MkdirAll(img.RootDir(), 0755); err != nil {
-			return fmt.Errorf("failed to mkdir %s for image %s: %v",
-				img.RootDir(), img.RepoTag, err)
+	var dirs []string
+	for i := len(img.Layers) - 1; i >= 0; i-- {
+		l, ok := Layers[img.Layers[i]]
+		if !ok {
+			return nil, fmt.Errorf("the layer %s of image %s doesn't exist",
+				img.Layers[i], img.RepoTag)
 		}
+		dirs = append(dirs, l.Dir())
 	}
+	return dirs, nil
+}
 
-	cmd = exec.Command("tar", "-xf", rootfsTarFile, "-C", img.RootDir())
-	if err := cmd.Run(); err != nil {
-		return err
+// Whiteout returns the whiteout format of the image's layers,
+// it's empty if the image has a flattened rootfs.
+func (img *Image) Whiteout() string {
+	if len(img.Layers) == 0 {
+		return ""
+	}
+	if err := LoadLayers(); err != nil {
+		return ""
+	}
+	if l, ok := Layers[img.Layers[len(img.Layers)-1]]; ok {
+		return l.Whiteout
 	}
+	return ""
+}
 
-	return nil
+// MakeLayers downloads the layers of the image which don't exist in
+// the layer store from the registry, and references all of them.
+func (img *Image) MakeLayers(reg *Registry, repo string, manifest *Manifest, config *ImageConfig) (int64, error) {
+	if len(config.RootFS.DiffIDs) != len(manifest.Layers) {
+		return 0, fmt.Errorf("the image %s has %d layers but %d diff_ids",
+			img.RepoTag, len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+
+	var names []string
+	for _, layer := range manifest.Layers {
+		names = append(names, shortDigest(layer.Digest))
+	}
+
+	// the blob's digest is verified by GetBlob(), but the diffID
+	// is verified by CreateLayer() with the uncompressed tarball.
+	return img.makeLayers(config.RootFS.DiffIDs, names, "Pull complete",
+		func(idx int) (io.ReadCloser, error) {
+			return reg.GetBlob(repo, manifest.Layers[idx])
+		})
+}
+
+// makeLayers creates the layers which don't exist in the layer store
+// from the tarballs returned by open(), and references all of them.
+// the names of layers and the status are only used to print progress.
+func (img *Image) makeLayers(diffIDs, names []string, status string,
+	open func(idx int) (io.ReadCloser, error)) (int64, error) {
+	var size int64
+	var acquired []string
+	parent := ""
+	for idx, diffID := range diffIDs {
+		// each layer is referenced once it's found or created, so
+		// that it won't be removed if another pull sharing it fails.
+		l, err := acquireLayer(ChainID(parent, diffID))
+		if err == nil && l != nil {
+			fmt.Printf("%s: Already exists\n", names[idx])
+		} else if err == nil {
+			l, err = pullLayer(parent, diffID, func() (io.ReadCloser, error) {
+				return open(idx)
+			})
+			if err == nil {
+				fmt.Printf("%s: %s\n", names[idx], status)
+			}
+		}
+		if err != nil {
+			// only the references acquired by this image are released.
+			ReleaseLayers(acquired)
+			return 0, fmt.Errorf("failed to apply layer %s: %v", names[idx], err)
+		}
+
+		acquired = append(acquired, l.ChainID)
+		size += l.Size
+		parent = l.ChainID
+	}
+
+	img.Layers = acquired
+	return size, nil
+}
+
+// pullLayer creates the layer from the blob returned by open(), and
+// references it, see createLayer().
+func pullLayer(parent, diffID string, open func() (io.ReadCloser, error)) (*Layer, error) {
+	blob, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	l, _, err := createLayer(parent, diffID, blob, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// CreateLayer() only drains the decompressed stream, the
+	// compressed blob must also be drained for verification.
+	if _, err := io.Copy(ioutil.Discard, blob); err != nil {
+		ReleaseLayers([]string{l.ChainID})
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// e.g. sha256:8ee3...f0e9 => 8ee3f2a9b1d0
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
 }
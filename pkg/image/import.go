@@ -0,0 +1,50 @@
+package image
+
+import (
+	"io"
+	"runtime"
+)
+
+// Import creates an image with a single layer from the flat rootfs
+// tarball read from r, which maybe compressed by gzip, e.g. the one
+// created by `mydocker export`, debootstrap or buildroot.
+func Import(r io.Reader, repoTag string, changes []string, comment string) (*Image, error) {
+	config := &ContainerConfig{}
+	if err := ApplyChanges(config, changes); err != nil {
+		return nil, err
+	}
+
+	layer, created, err := CreateLayer("", "", r)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := NewConfig(ScratchConfig(), config, layer.DiffID, "mydocker import", comment)
+	if err != nil {
+		return nil, err
+	}
+
+	if repoTag == "" {
+		repoTag = NoneRepoTag
+	}
+	img, err := CreateImage(repoTag, contents)
+	if err != nil {
+		// the layer isn't referenced if it's created just now.
+		if created {
+			ReleaseLayers([]string{layer.ChainID})
+		}
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// ScratchConfig returns the config of an empty image, i.e. scratch,
+// whose platform is the same as the host.
+func ScratchConfig() []byte {
+	return mustMarshal(&ImageConfig{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		RootFS:       &RootFS{Type: "layers"},
+	})
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("importing images requires root")
+	}
+	setupImagesDir(t)
+
+	blob, diffID := makeLayer(t, []testFile{
+		{name: "./", dir: true},
+		{name: "./etc/", dir: true},
+		{name: "./etc/os-release", contents: "ID=test"},
+	})
+
+	changes := []string{"ENV a=b", `CMD ["sh"]`}
+	img, err := Import(bytes.NewReader(blob), "rootfs:v1", changes, "imported")
+	if err != nil {
+		t.Fatalf("failed to import image: %v", err)
+	}
+	if !Exist("rootfs:v1") {
+		t.Errorf("the image isn't tagged as rootfs:v1: %s", img.RepoTag)
+	}
+
+	config, _, err := img.LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Architecture != runtime.GOARCH || config.OS != runtime.GOOS {
+		t.Errorf("unexpected platform: %s/%s", config.OS, config.Architecture)
+	}
+	if !reflect.DeepEqual(config.RootFS.DiffIDs, []string{diffID}) {
+		t.Errorf("unexpected diff_ids: %v", config.RootFS.DiffIDs)
+	}
+	if !reflect.DeepEqual(img.Envs, []string{"a=b"}) || !reflect.DeepEqual(img.Command, []string{"sh"}) {
+		t.Errorf("the changes aren't applied: %+v", img)
+	}
+
+	dirs, err := img.LowerDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadFile(path.Join(dirs[0], "etc/os-release"))
+	if err != nil || string(contents) != "ID=test" {
+		t.Errorf("unexpected contents of etc/os-release: %q, %v", contents, err)
+	}
+}
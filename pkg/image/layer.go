@@ -0,0 +1,263 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+// ChainID returns the chain id of a layer, which identifies the
+// layer with all its parents, i.e. the snapshot of the rootfs.
+// ref: https://github.com/opencontainers/image-spec/blob/main/config.md#layer-chainid
+func ChainID(parent, diffID string) string {
+	if parent == "" {
+		return diffID
+	}
+	return "sha256:" + util.Sha256Sum(parent+" "+diffID)
+}
+
+// ChainIDs converts the diffIDs of an image to its chain ids.
+func ChainIDs(diffIDs []string) []string {
+	var chainIDs []string
+	parent := ""
+	for _, diffID := range diffIDs {
+		parent = ChainID(parent, diffID)
+		chainIDs = append(chainIDs, parent)
+	}
+	return chainIDs
+}
+
+// WhiteoutFormat returns the format of whiteouts used to extract
+// the layers, it must be understood by the storage driver.
+func WhiteoutFormat() string {
+	if util.ModuleIsLoaded("aufs") && !util.ModuleIsLoaded("overlay") {
+		return WhiteoutAufs
+	}
+	return WhiteoutOverlay
+}
+
+func (l *Layer) Dir() string {
+	return path.Join(LayersDir, strings.TrimPrefix(l.ChainID, "sha256:"))
+}
+
+// CreateLayer extracts the layer tarball read from r onto the parent
+// layer and registers it into the layer store. the diffID will be
+// verified if it's not empty, or it will be calculated.
+// if the layer already exists, it's returned directly. created is true
+// only if the layer is moved into the store by this call, rather than
+// by another mydocker, e.g. pulling the same image meanwhile, so that
+// the caller only releases the layer created by itself if it failed.
+func CreateLayer(parent, diffID string, r io.Reader) (_ *Layer, created bool, err error) {
+	return createLayer(parent, diffID, r, false)
+}
+
+// createLayer creates the layer like CreateLayer(), and references
+// the layer at once under the lock of layers if acquire is true, so
+// that the layer can't be removed by others before it's referenced.
+func createLayer(parent, diffID string, r io.Reader, acquire bool) (*Layer, bool, error) {
+	if err := LoadLayers(); err != nil {
+		return nil, false, err
+	}
+
+	if diffID != "" && !acquire {
+		if l, ok := Layers[ChainID(parent, diffID)]; ok {
+			return l, false, nil
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir(LayersDir, "tmp-")
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create temp dir for layer: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	reader, err := Decompress(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var verifier *DigestReader
+	if diffID != "" {
+		verifier, err = NewDigestReader(reader, diffID)
+	} else {
+		verifier, err = NewDigestCalculator(reader)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	format := WhiteoutFormat()
+	size, err := ApplyLayer(tmpDir, verifier, format)
+	if err != nil {
+		return nil, false, err
+	}
+
+	l := &Layer{
+		DiffID:   verifier.Digest(),
+		Parent:   parent,
+		Size:     size,
+		Refs:     0,
+		Whiteout: format,
+	}
+	l.ChainID = ChainID(parent, l.DiffID)
+
+	unlock, err := util.LockFile(LayersConfigFile)
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	// the layer maybe created by another mydocker meanwhile.
+	if err := LoadLayers(); err != nil {
+		return nil, false, err
+	}
+
+	created := false
+	if existLayer, ok := Layers[l.ChainID]; ok {
+		l = existLayer
+	} else {
+		if err := os.Rename(tmpDir, l.Dir()); err != nil {
+			return nil, false, fmt.Errorf("failed to move layer %s into the store: %v", l.ChainID, err)
+		}
+		log.Debugf("create the layer %s in %s", l.ChainID, l.Dir())
+		Layers[l.ChainID] = l
+		created = true
+	}
+
+	if !created && !acquire {
+		return l, false, nil
+	}
+	if acquire {
+		l.Refs++
+	}
+	if err := DumpLayers(); err != nil {
+		return nil, false, err
+	}
+	return l, created, nil
+}
+
+// acquireLayer increases the references of the layer if it exists,
+// or returns nil if it doesn't exist in the layer store.
+func acquireLayer(chainID string) (*Layer, error) {
+	unlock, err := util.LockFile(LayersConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := LoadLayers(); err != nil {
+		return nil, err
+	}
+
+	l, ok := Layers[chainID]
+	if !ok {
+		return nil, nil
+	}
+	l.Refs++
+	return l, DumpLayers()
+}
+
+// AcquireLayers increases the references of layers used by an image.
+func AcquireLayers(chainIDs []string) error {
+	unlock, err := util.LockFile(LayersConfigFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := LoadLayers(); err != nil {
+		return err
+	}
+
+	for _, chainID := range chainIDs {
+		l, ok := Layers[chainID]
+		if !ok {
+			return fmt.Errorf("no such layer: %s", chainID)
+		}
+		l.Refs++
+	}
+
+	return DumpLayers()
+}
+
+// ReleaseLayers decreases the references of layers used by an
+// image, and removes the layers which are no longer referenced.
+func ReleaseLayers(chainIDs []string) error {
+	unlock, err := util.LockFile(LayersConfigFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := LoadLayers(); err != nil {
+		return err
+	}
+
+	for _, chainID := range chainIDs {
+		l, ok := Layers[chainID]
+		if !ok {
+			log.Warnf("the layer %s doesn't exist in the store", chainID)
+			continue
+		}
+
+		l.Refs--
+		if l.Refs > 0 {
+			continue
+		}
+
+		log.Debugf("remove the unreferenced layer %s", chainID)
+		if err := os.RemoveAll(l.Dir()); err != nil {
+			return fmt.Errorf("failed to remove layer %s: %v", chainID, err)
+		}
+		delete(Layers, chainID)
+	}
+
+	return DumpLayers()
+}
+
+func DumpLayers() error {
+	if err := util.EnSureFileExists(LayersConfigFile); err != nil {
+		return err
+	}
+
+	jsonBytes, err := json.Marshal(Layers)
+	if err != nil {
+		return fmt.Errorf("failed to json-encode layers: %v", err)
+	}
+
+	if err := util.WriteFileAtomic(LayersConfigFile, jsonBytes, 0644); err != nil {
+		return fmt.Errorf("failed to write layers configs to file %s: %v",
+			LayersConfigFile, err)
+	}
+
+	return nil
+}
+
+func LoadLayers() error {
+	if err := util.EnSureFileExists(LayersConfigFile); err != nil {
+		return err
+	}
+
+	jsonBytes, err := ioutil.ReadFile(LayersConfigFile)
+	if len(jsonBytes) == 0 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read layers configFile %s: %v",
+			LayersConfigFile, err)
+	}
+
+	Layers = map[string]*Layer{}
+	if err := json.Unmarshal(jsonBytes, &Layers); err != nil {
+		return fmt.Errorf("failed to json-decode layers: %v", err)
+	}
+
+	return nil
+}
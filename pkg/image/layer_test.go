@@ -0,0 +1,93 @@
+package image
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCreateLayer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("creating layers requires root")
+	}
+	setupImagesDir(t)
+
+	blob, diffID := makeLayer(t, []testFile{
+		{name: "./", dir: true},
+		{name: "./hello", contents: "hello"},
+	})
+
+	l, created, err := CreateLayer("", "", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || l.DiffID != diffID {
+		t.Errorf("unexpected layer created: %+v, %v", l, created)
+	}
+
+	// the existing layer is returned, but not created by the caller,
+	// whether it's found before or after the tarball is extracted.
+	for _, id := range []string{diffID, ""} {
+		existing, created, err := CreateLayer("", id, bytes.NewReader(blob))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created || existing.ChainID != l.ChainID {
+			t.Errorf("unexpected layer created with diffID %q: %+v, %v", id, existing, created)
+		}
+	}
+}
+
+func TestMakeLayersSharing(t *testing.T) {
+	setupImagesDir(t)
+
+	base, baseDiffID := makeLayer(t, []testFile{{name: "base", contents: "base"}})
+	top, topDiffID := makeLayer(t, []testFile{{name: "top", contents: "top"}})
+	blobs := [][]byte{base, top}
+
+	// the first pull creates the base layer, and another pull sharing
+	// the base layer finds it meanwhile, then the first pull fails.
+	other := &Image{}
+	first := &Image{}
+	_, err := first.makeLayers([]string{baseDiffID, topDiffID}, []string{"base", "top"}, "Pull complete",
+		func(idx int) (io.ReadCloser, error) {
+			if idx == 1 {
+				if _, err := other.makeLayers([]string{baseDiffID}, []string{"base"}, "Pull complete",
+					func(int) (io.ReadCloser, error) {
+						t.Errorf("the existing base layer is downloaded again")
+						return nil, errors.New("unexpected download")
+					}); err != nil {
+					t.Fatal(err)
+				}
+				return nil, errors.New("network error")
+			}
+			return ioutil.NopCloser(bytes.NewReader(blobs[idx])), nil
+		})
+	if err == nil {
+		t.Fatal("expected the first pull to fail")
+	}
+
+	if err := LoadLayers(); err != nil {
+		t.Fatal(err)
+	}
+	l, ok := Layers[baseDiffID]
+	if !ok {
+		t.Fatal("the base layer used by another image is removed")
+	}
+	if l.Refs != 1 {
+		t.Errorf("expected the base layer to be referenced once, got %d", l.Refs)
+	}
+	if _, err := os.Stat(l.Dir()); err != nil {
+		t.Errorf("the dir of the base layer is removed: %v", err)
+	}
+
+	if err := ReleaseLayers(other.Layers); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Layers[baseDiffID]; ok {
+		t.Errorf("the base layer isn't removed after all the images released it")
+	}
+}
@@ -0,0 +1,183 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// PackLayer writes the contents of the layer dir to w as an uncompressed
+// tarball, and converts the whiteouts of the given format to the portable
+// .wh. files, i.e. it's the reverse of ApplyLayer. the entries are written
+// in lexical order, so that packing the same dir twice gets the same diffID.
+// the excludes are the relative paths which aren't packed, e.g. etc/hosts.
+func PackLayer(dir string, w io.Writer, whiteout string, excludes ...string) error {
+	tw := tar.NewWriter(w)
+	// key is the inode of hard links, value is the first name.
+	inodes := map[uint64]string{}
+
+	err := filepath.Walk(dir, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+		for _, exclude := range excludes {
+			if name == exclude {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		stat, ok := fi.Sys().(*syscall.Stat_t)
+		if !ok {
+			return fmt.Errorf("failed to get the stat of %s", file)
+		}
+
+		switch whiteout {
+		case WhiteoutOverlay:
+			if fi.Mode()&os.ModeCharDevice != 0 && stat.Rdev == 0 {
+				parent, base := path.Split(name)
+				return tw.WriteHeader(&tar.Header{
+					Name:     path.Join(parent, WhiteoutPrefix+base),
+					Typeflag: tar.TypeReg,
+					ModTime:  fi.ModTime(),
+				})
+			}
+		case WhiteoutAufs:
+			// the aufs metadata files, e.g. .wh..wh.plnk, aren't
+			// the contents of the layer and must be skipped.
+			base := path.Base(name)
+			if strings.HasPrefix(base, WhiteoutPrefix+WhiteoutPrefix) && base != WhiteoutOpaque {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// keep the tarball reproducible, the owners are kept
+		// by uid and gid, and the atime/ctime are meaningless.
+		hdr.Uname, hdr.Gname = "", ""
+		hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+
+		if fi.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := inodes[stat.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				inodes[stat.Ino] = name
+			}
+		}
+
+		// the xattrs of symlinks can't be read without following them.
+		if link == "" {
+			if xattrs, err := listXattrs(file); err == nil && len(xattrs) > 0 {
+				hdr.PAXRecords = map[string]string{}
+				for attr, value := range xattrs {
+					hdr.PAXRecords["SCHILY.xattr."+attr] = value
+				}
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+
+		if fi.IsDir() && whiteout == WhiteoutOverlay && IsOpaque(file) {
+			return tw.WriteHeader(&tar.Header{
+				Name:     path.Join(name, WhiteoutOpaque),
+				Typeflag: tar.TypeReg,
+				ModTime:  fi.ModTime(),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pack layer %s: %v", dir, err)
+	}
+
+	return tw.Close()
+}
+
+// IsOpaque returns true if the dir is an opaque dir of overlay.
+func IsOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, OverlayOpaque, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// listXattrs returns the xattrs of the file except for the
+// ones used by overlay, which are converted to whiteouts.
+func listXattrs(file string) (map[string]string, error) {
+	size, err := syscall.Listxattr(file, nil)
+	if err != nil || size <= 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	if size, err = syscall.Listxattr(file, buf); err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+	for _, attr := range bytes.Split(buf[:size], []byte{0}) {
+		name := string(attr)
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+
+		vsize, err := syscall.Getxattr(file, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = syscall.Getxattr(file, name, value); err != nil {
+			continue
+		}
+		xattrs[name] = string(value[:vsize])
+	}
+
+	return xattrs, nil
+}
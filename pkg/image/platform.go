@@ -0,0 +1,120 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// the aliases of architectures used by uname and distros.
+var archAliases = map[string]string{
+	"x86_64":  "amd64",
+	"x86-64":  "amd64",
+	"aarch64": "arm64",
+	"i386":    "386",
+	"i686":    "386",
+	"armhf":   "arm",
+	"armel":   "arm",
+}
+
+var knownOS = map[string]bool{
+	"linux": true, "windows": true, "darwin": true, "freebsd": true,
+}
+
+// ParsePlatform parses the platform like `os/arch[/variant]`, e.g.
+// linux/arm64 or linux/arm/v7, the os can be omitted, i.e. linux.
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(strings.ToLower(s), "/")
+	if len(parts) == 1 || len(parts) == 2 && !knownOS[parts[0]] {
+		parts = append([]string{"linux"}, parts...)
+	}
+	if len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid platform %q, should be os/arch[/variant]", s)
+	}
+
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	switch p.Architecture {
+	case "armhf":
+		p.Variant = "v7"
+	case "armel":
+		p.Variant = "v6"
+	}
+	if arch, ok := archAliases[p.Architecture]; ok {
+		p.Architecture = arch
+	}
+	p.Variant = normalizeVariant(p.Architecture, p.Variant)
+	return p, nil
+}
+
+// HostPlatform returns the platform of the host, the variant of
+// arm is read from /proc/cpuinfo, which is empty if it's unknown.
+func HostPlatform() *Platform {
+	p := &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	if p.Architecture == "arm" {
+		p.Variant = armVariant()
+	}
+	p.Variant = normalizeVariant(p.Architecture, p.Variant)
+	return p
+}
+
+func (p *Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Match returns true if the image of platform other can be used as
+// p, the variants are only compared if both of them are specified.
+func (p *Platform) Match(other *Platform) bool {
+	if p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	variant := normalizeVariant(other.Architecture, other.Variant)
+	return p.Variant == "" || variant == "" || p.Variant == variant
+}
+
+// e.g. the variant of arm64 is always v8, like containerd does.
+func normalizeVariant(arch, variant string) string {
+	switch {
+	case arch == "arm64" && (variant == "8" || variant == "v8"):
+		return ""
+	case arch == "arm" && len(variant) == 1:
+		return "v" + variant
+	}
+	return variant
+}
+
+// e.g. `CPU architecture: 7` => v7
+func armVariant() string {
+	file, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "CPU architecture" {
+			return "v" + strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// Platform returns the platform of the image, which is
+// nil if the image was added by an old version of mydocker.
+func (img *Image) Platform() *Platform {
+	if img.Architecture == "" {
+		return nil
+	}
+	return &Platform{OS: img.OS, Architecture: img.Architecture, Variant: img.Variant}
+}
@@ -0,0 +1,67 @@
+package image
+
+import (
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	for s, expected := range map[string]string{
+		"linux/amd64":   "linux/amd64",
+		"arm64":         "linux/arm64",
+		"linux/arm64/8": "linux/arm64",
+		"arm/7":         "linux/arm/v7",
+		"armhf":         "linux/arm/v7",
+		"linux/armel":   "linux/arm/v6",
+		"x86_64":        "linux/amd64",
+		"Linux/AARCH64": "linux/arm64",
+	} {
+		p, err := ParsePlatform(s)
+		if err != nil {
+			t.Errorf("unexpected error when parsing %s: %v", s, err)
+			continue
+		}
+		if p.String() != expected {
+			t.Errorf("expected %s of %s, got %s", expected, s, p)
+		}
+	}
+
+	for _, s := range []string{"", "linux/", "linux/arm/v7/extra"} {
+		if _, err := ParsePlatform(s); err == nil {
+			t.Errorf("expected error when parsing %q", s)
+		}
+	}
+}
+
+func TestSelectManifest(t *testing.T) {
+	index := &Index{Manifests: []*Descriptor{
+		{Digest: "amd64", Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "arm-v6", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: "arm-v7", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "arm64", Platform: &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{Digest: "attestation"},
+	}}
+
+	for s, expected := range map[string]string{
+		"linux/amd64":  "amd64",
+		"linux/arm/v7": "arm-v7",
+		"linux/arm":    "arm-v6",
+		"linux/arm64":  "arm64",
+	} {
+		p, _ := ParsePlatform(s)
+		desc, err := selectManifest(index, p)
+		if err != nil {
+			t.Errorf("unexpected error when selecting %s: %v", s, err)
+			continue
+		}
+		if desc.Digest != expected {
+			t.Errorf("expected %s for %s, got %s", expected, s, desc.Digest)
+		}
+	}
+
+	for _, s := range []string{"linux/s390x", "windows/amd64", "linux/arm/v5"} {
+		p, _ := ParsePlatform(s)
+		if _, err := selectManifest(index, p); err == nil {
+			t.Errorf("expected error when selecting %s", s)
+		}
+	}
+}
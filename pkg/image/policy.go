@@ -0,0 +1,124 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LoadPolicy reads the policy from PolicyFile, nil is returned
+// if the file doesn't exist, i.e. all images are accepted.
+func LoadPolicy() (*Policy, error) {
+	contents, err := ioutil.ReadFile(PolicyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy %s: %v", PolicyFile, err)
+	}
+
+	policy := &Policy{}
+	if err := json.Unmarshal(contents, policy); err != nil {
+		return nil, fmt.Errorf("failed to json-decode policy %s: %v", PolicyFile, err)
+	}
+
+	// the images not matching any scope are rejected if
+	// the default is missing, rather than accepted silently.
+	if policy.Default == nil {
+		policy.Default = &PolicyRequirement{Type: PolicyReject}
+	}
+
+	requirements := []*PolicyRequirement{policy.Default}
+	for scope, requirement := range policy.Scopes {
+		if requirement == nil {
+			return nil, fmt.Errorf("missing the requirement of scope %s in policy", scope)
+		}
+		requirements = append(requirements, requirement)
+	}
+	for _, requirement := range requirements {
+		switch requirement.Type {
+		case PolicyAccept, PolicyReject:
+		case PolicySigned:
+			if len(requirement.KeyPaths) == 0 {
+				return nil, fmt.Errorf("the requirement %s must have keyPaths", requirement.Type)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported requirement type %q in policy", requirement.Type)
+		}
+	}
+
+	return policy, nil
+}
+
+// Requirement returns the requirement of the image named by ref,
+// i.e. the one of the longest scope matching the image, or the
+// default requirement if the image is unnamed, i.e. ref is nil.
+func (p *Policy) Requirement(ref *Reference) *PolicyRequirement {
+	if ref == nil {
+		return p.Default
+	}
+
+	// e.g. docker.io/library/ubuntu, docker.io/library, docker.io
+	scope := ref.Domain + "/" + ref.Repository
+	for {
+		if requirement, ok := p.Scopes[scope]; ok {
+			return requirement
+		}
+		idx := strings.LastIndex(scope, "/")
+		if idx < 0 {
+			return p.Default
+		}
+		scope = scope[:idx]
+	}
+}
+
+// Verify checks whether the image named by ref (nil if it's unnamed)
+// is allowed by the policy, digests are the digests of its manifests,
+// whose signatures are returned by lookup. if the image is required to
+// be signed, the path of the public key verifying it is returned.
+func (p *Policy) Verify(ref *Reference, digests []string,
+	lookup func(digest string) ([]*Signature, error)) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+
+	name := "the unnamed image"
+	if ref != nil {
+		name = ref.Domain + "/" + ref.Repository
+	}
+
+	requirement := p.Requirement(ref)
+	switch requirement.Type {
+	case PolicyAccept:
+		return "", nil
+	case PolicyReject:
+		return "", fmt.Errorf("%s is rejected by policy %s", name, PolicyFile)
+	}
+
+	keys, err := loadPublicKeys(requirement.KeyPaths)
+	if err != nil {
+		return "", err
+	}
+
+	for _, digest := range digests {
+		signatures, err := lookup(digest)
+		if err != nil {
+			return "", fmt.Errorf("failed to get signatures of %s: %v", digest, err)
+		}
+
+		for _, sig := range signatures {
+			signer, err := sig.verify(keys, ref, digest)
+			if err != nil {
+				log.Debugf("the signature of %s is invalid: %v", digest, err)
+				continue
+			}
+			return signer, nil
+		}
+	}
+
+	return "", fmt.Errorf("%s isn't signed by any key of policy %s", name, PolicyFile)
+}
@@ -0,0 +1,186 @@
+package image
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func writePolicy(t *testing.T, dir, contents string) *Policy {
+	oldPolicyFile := PolicyFile
+	PolicyFile = path.Join(dir, "policy.json")
+	t.Cleanup(func() { PolicyFile = oldPolicyFile })
+
+	if err := ioutil.WriteFile(PolicyFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
+func writePublicKey(t *testing.T, dir, name string) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := path.Join(dir, name)
+	contents := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	if err := ioutil.WriteFile(keyPath, contents, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return key, keyPath
+}
+
+// signImage pushes a cosign signature of the digest into the registry.
+func signImage(t *testing.T, reg *testRegistry, key *ecdsa.PrivateKey, identity, digest string) {
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},`+
+		`"image":{"docker-manifest-digest":%q},"type":%q},"optional":null}`,
+		identity, digest, CosignSignatureType))
+	hashed := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, _ := json.Marshal(&Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        &Descriptor{Digest: digestOf([]byte("{}")), Size: 2},
+		Layers: []*Descriptor{{
+			MediaType:   MediaTypeCosignPayload,
+			Digest:      digestOf(payload),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{AnnotationCosignSig: base64.StdEncoding.EncodeToString(signature)},
+		}},
+	})
+	reg.blobs[digestOf(payload)] = payload
+	reg.manifests[SignatureTag(digest)] = manifest
+	reg.types[SignatureTag(digest)] = MediaTypeOCIManifest
+}
+
+func TestPolicyRequirement(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-policy-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	policy := writePolicy(t, dir, `{
+		"default": {"type": "reject"},
+		"scopes": {
+			"docker.io": {"type": "insecureAcceptAnything"},
+			"docker.io/library/ubuntu": {"type": "reject"}
+		}
+	}`)
+
+	for name, expected := range map[string]string{
+		"ubuntu:18.04":        PolicyReject,
+		"busybox":             PolicyAccept,
+		"weikeit/mydocker:v1": PolicyAccept,
+		"quay.io/coreos/etcd": PolicyReject,
+	} {
+		ref, err := ParseReference(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := policy.Requirement(ref).Type; actual != expected {
+			t.Errorf("expected %s of %s, got %s", expected, name, actual)
+		}
+	}
+	if policy.Requirement(nil).Type != PolicyReject {
+		t.Errorf("the unnamed image should use the default requirement")
+	}
+
+	// the policy without keys for signatures is invalid.
+	PolicyFile = path.Join(dir, "invalid.json")
+	ioutil.WriteFile(PolicyFile, []byte(`{"default": {"type": "sigstoreSigned"}}`), 0644)
+	if _, err := LoadPolicy(); err == nil {
+		t.Errorf("expected error when loading the invalid policy")
+	}
+
+	// all images are accepted without the policy file.
+	PolicyFile = path.Join(dir, "missing.json")
+	if policy, err := LoadPolicy(); err != nil || policy != nil {
+		t.Errorf("unexpected policy: %+v, %v", policy, err)
+	}
+}
+
+func TestVerifySignatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-policy-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, reg := newTestRegistry(t, "test/app", "v1")
+	defer server.Close()
+	domain := strings.TrimPrefix(server.URL, "http://")
+
+	trusted, trustedPath := writePublicKey(t, dir, "trusted.pub")
+	untrusted, _ := writePublicKey(t, dir, "untrusted.pub")
+	policy := writePolicy(t, dir, fmt.Sprintf(`{
+		"default": {"type": "reject"},
+		"scopes": {"%s/test": {"type": "sigstoreSigned", "keyPaths": [%q]}}
+	}`, domain, trustedPath))
+
+	ref, err := ParseReference(domain + "/test/app:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(ref.Domain)
+	_, desc, listDesc, err := registry.getManifest(ref, HostPlatform())
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := []string{listDesc.Digest, desc.Digest}
+	verify := func() (string, error) {
+		return policy.Verify(ref, digests, func(digest string) ([]*Signature, error) {
+			return registry.GetSignatures(ref.Repository, digest)
+		})
+	}
+
+	if _, err := verify(); err == nil {
+		t.Errorf("expected error when verifying the unsigned image")
+	}
+
+	// the signatures of other keys or images aren't accepted.
+	signImage(t, reg, untrusted, domain+"/test/app", listDesc.Digest)
+	signImage(t, reg, trusted, domain+"/test/other", desc.Digest)
+	if _, err := verify(); err == nil {
+		t.Errorf("expected error when verifying the image signed by others")
+	}
+
+	signImage(t, reg, trusted, domain+"/test/app", desc.Digest)
+	signer, err := verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer != trustedPath {
+		t.Errorf("unexpected signer: %s", signer)
+	}
+
+	// the images out of the scope are rejected by default.
+	other, _ := ParseReference(domain + "/other/app:v1")
+	if _, err := policy.Verify(other, digests, nil); err == nil {
+		t.Errorf("expected error when verifying the image out of scopes")
+	}
+}
@@ -0,0 +1,184 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"weike.sh/mydocker/util"
+)
+
+type PruneReport struct {
+	// the repotags removed from the images.
+	Untagged []string
+	// the ids of the removed images, or uuids of legacy images.
+	Deleted []string
+	// the space in bytes reclaimed from the layers and rootfs dirs.
+	Reclaimed int64
+}
+
+// Prune removes the images which aren't used by any container, only the
+// dangling images are removed unless all is true, and the images created
+// after until are kept if it isn't zero. the dirs under ImagesDir which
+// aren't the rootfs of any legacy image are also removed as orphans.
+func Prune(all bool, until time.Time) (*PruneReport, error) {
+	unlock, err := util.LockFile(ImagesConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := UpdateCounts(); err != nil {
+		return nil, err
+	}
+	before, err := layersSize()
+	if err != nil {
+		return nil, err
+	}
+
+	// the ids of existing images keyed by their uuids, the ids
+	// of legacy images are empty, so their uuids are used instead.
+	var uuids []string
+	ids := map[string]string{}
+	for _, img := range Images {
+		if _, ok := ids[img.Uuid]; ok {
+			continue
+		}
+		uuids = append(uuids, img.Uuid)
+		ids[img.Uuid] = img.Id
+		if img.Id == "" {
+			ids[img.Uuid] = img.Uuid
+		}
+	}
+
+	report := &PruneReport{}
+	pruned := map[string]bool{}
+	for {
+		img := pruneCandidate(all, until, pruned)
+		if img == nil {
+			break
+		}
+
+		repoTags := RepoTags(img.Uuid)
+		if img.Id == "" {
+			report.Reclaimed += dirSize(img.RootDir())
+		}
+		// an image is pruned at most once, even if it's failed.
+		pruned[img.Uuid] = true
+		if err := Delete(img.Uuid, true); err != nil {
+			log.Warnf("failed to remove image %s: %v", img.Uuid, err)
+			continue
+		}
+		report.Untagged = append(report.Untagged, repoTags...)
+	}
+
+	// the parents of the pruned images maybe removed as well.
+	remained := map[string]bool{}
+	for _, img := range Images {
+		remained[img.Uuid] = true
+	}
+	for _, uuid := range uuids {
+		if !remained[uuid] {
+			report.Deleted = append(report.Deleted, ids[uuid])
+		}
+	}
+
+	orphans, err := removeOrphans()
+	if err != nil {
+		return report, err
+	}
+
+	after, err := layersSize()
+	if err != nil {
+		return report, err
+	}
+	report.Reclaimed += before - after + orphans
+	return report, nil
+}
+
+// pruneCandidate returns an image to be pruned, the images which are
+// parents of others are skipped, which are removed with their children.
+func pruneCandidate(all bool, until time.Time, pruned map[string]bool) *Image {
+	parents := map[string]bool{}
+	for _, img := range Images {
+		if img.Parent != "" {
+			parents[img.Parent] = true
+		}
+	}
+
+	for _, img := range Images {
+		if pruned[img.Uuid] || img.Counts > 0 || parents[img.Id] && img.Id != "" {
+			continue
+		}
+		if !all && img.RepoTag != NoneRepoTag {
+			continue
+		}
+		if !until.IsZero() {
+			created, err := time.ParseInLocation("2006-01-02 15:04:05", img.CreateTime, time.Local)
+			if err != nil || created.After(until) {
+				continue
+			}
+		}
+		return img
+	}
+
+	return nil
+}
+
+// removeOrphans removes the dirs under ImagesDir which aren't
+// the rootfs of any legacy image, and returns their sizes.
+func removeOrphans() (int64, error) {
+	legacy := map[string]bool{}
+	for _, img := range Images {
+		if img.Id == "" {
+			legacy[img.Uuid] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(ImagesDir)
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, file := range files {
+		if !file.IsDir() || legacy[file.Name()] {
+			continue
+		}
+
+		dir := filepath.Join(ImagesDir, file.Name())
+		dirBytes := dirSize(dir)
+		log.Debugf("remove the orphaned rootfs %s", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return size, err
+		}
+		size += dirBytes
+	}
+
+	return size, nil
+}
+
+func layersSize() (int64, error) {
+	if err := LoadLayers(); err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, l := range Layers {
+		size += l.Size
+	}
+	return size, nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPrune(t *testing.T) {
+	setupImagesDir(t)
+
+	createImage := func(repoTag, cmd string) *Image {
+		contents, err := NewConfig(ScratchConfig(), &ContainerConfig{Cmd: []string{cmd}}, "", cmd, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := CreateImage(repoTag, contents)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	tagged := createImage("app:v1", "app")
+	dangling := createImage(NoneRepoTag, "dangling")
+	used := createImage("used:v1", "used")
+
+	// the counts are rebuilt from the references of containers.
+	CountRefs = func() (map[string]int, error) {
+		return map[string]int{used.Uuid: 1}, nil
+	}
+	defer func() { CountRefs = nil }()
+
+	orphan := path.Join(ImagesDir, "0123456789ab")
+	if err := os.MkdirAll(orphan, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(orphan, "file"), []byte("orphan"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Prune(false, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Deleted, []string{dangling.Id}) || len(report.Untagged) != 0 {
+		t.Errorf("unexpected report of pruning dangling images: %+v", report)
+	}
+	if report.Reclaimed != int64(len("orphan")) {
+		t.Errorf("unexpected reclaimed space: %d", report.Reclaimed)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("the orphaned rootfs isn't removed: %v", err)
+	}
+
+	// the images created after until are kept.
+	if report, err = Prune(true, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Deleted) != 0 {
+		t.Errorf("unexpected report of pruning with until: %+v", report)
+	}
+
+	if report, err = Prune(true, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Deleted, []string{tagged.Id}) ||
+		!reflect.DeepEqual(report.Untagged, []string{"app:v1"}) {
+		t.Errorf("unexpected report of pruning all images: %+v", report)
+	}
+	if !Exist("used:v1") || Images[0].Counts != 1 {
+		t.Errorf("the used image is removed or not counted: %+v", Images)
+	}
+}
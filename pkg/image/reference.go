@@ -0,0 +1,100 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	digestRegexp = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+	tagRegexp    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	// the tag of cosign signatures, e.g. sha256-8ee3...f0e9.sig
+	signatureTagRegexp = regexp.MustCompile(`(^|:)sha256-[0-9a-f]{64}\.sig$`)
+	repoRegexp         = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*` +
+		`(?:/[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*)*$`)
+)
+
+// ParseReference parses the image name like docker does, e.g.
+// ubuntu => docker.io/library/ubuntu:latest
+// weikeit/mydocker:v1 => docker.io/weikeit/mydocker:v1
+// localhost:5000/app@sha256:... => localhost:5000/app@sha256:...
+func ParseReference(name string) (*Reference, error) {
+	if name == "" {
+		return nil, fmt.Errorf("missing image's repo and tag")
+	}
+
+	ref := &Reference{}
+	remainder := name
+
+	if idx := strings.Index(remainder, "@"); idx >= 0 {
+		ref.Digest = remainder[idx+1:]
+		remainder = remainder[:idx]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return nil, fmt.Errorf("invalid digest %s of image %s", ref.Digest, name)
+		}
+	}
+
+	// the tag must be after the last slash, or the colon
+	// maybe the port of registry, e.g. localhost:5000/app
+	if idx := strings.LastIndex(remainder, ":"); idx > strings.LastIndex(remainder, "/") {
+		ref.Tag = remainder[idx+1:]
+		remainder = remainder[:idx]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return nil, fmt.Errorf("invalid tag %s of image %s", ref.Tag, name)
+		}
+	}
+
+	// the first component is a domain only if it contains
+	// a dot or a port, or it's exactly the word localhost.
+	parts := strings.SplitN(remainder, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.Domain, ref.Repository = parts[0], parts[1]
+	} else {
+		ref.Domain, ref.Repository = DefaultDomain, remainder
+	}
+
+	if ref.Domain == DefaultDomain && !strings.Contains(ref.Repository, "/") {
+		ref.Repository = OfficialRepo + "/" + ref.Repository
+	}
+
+	if !repoRegexp.MatchString(ref.Repository) {
+		return nil, fmt.Errorf("invalid repository name %s of image %s",
+			ref.Repository, name)
+	}
+
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+
+	return ref, nil
+}
+
+// Name returns the familiar name of the image, i.e. the
+// default domain and the official repo will be omitted.
+func (ref *Reference) Name() string {
+	if ref.Domain != DefaultDomain {
+		return ref.Domain + "/" + ref.Repository
+	}
+	return strings.TrimPrefix(ref.Repository, OfficialRepo+"/")
+}
+
+// RepoTag returns the familiar name with a tag, or with
+// a digest if the image is referenced by the digest only.
+func (ref *Reference) RepoTag() string {
+	if ref.Tag == "" {
+		return ref.Name() + "@" + ref.Digest
+	}
+	return ref.Name() + ":" + ref.Tag
+}
+
+func (ref *Reference) String() string {
+	s := ref.Domain + "/" + ref.Repository
+	if ref.Tag != "" {
+		s += ":" + ref.Tag
+	}
+	if ref.Digest != "" {
+		s += "@" + ref.Digest
+	}
+	return s
+}
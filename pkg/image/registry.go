@@ -0,0 +1,381 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var manifestMediaTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifestList,
+	MediaTypeDockerManifest,
+}
+
+// the error of requesting the manifests or blobs which don't exist.
+var errNotFound = errors.New("404 Not Found")
+
+// e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+var challengeRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func NewRegistry(domain string) *Registry {
+	host := domain
+	if domain == DefaultDomain {
+		host = DefaultRegistry
+	}
+
+	// like docker, the registry running on localhost
+	// is treated as an insecure (plain http) registry.
+	scheme := "https"
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "localhost" || net.ParseIP(hostname).IsLoopback() {
+		scheme = "http"
+	}
+
+	return &Registry{
+		Domain:   domain,
+		Endpoint: scheme + "://" + host,
+		tokens:   map[string]string{},
+	}
+}
+
+// GetManifest fetches the image manifest referenced by ref, if the
+// registry returns a manifest list (or an OCI index), the manifest
+// matching the platform will be selected and fetched then.
+func (reg *Registry) GetManifest(ref *Reference, platform *Platform) (*Manifest, *Descriptor, error) {
+	manifest, desc, _, err := reg.getManifest(ref, platform)
+	return manifest, desc, err
+}
+
+// getManifest is like GetManifest, but also returns the descriptor
+// of the manifest list, which is nil if ref points to a manifest.
+func (reg *Registry) getManifest(ref *Reference, platform *Platform) (*Manifest, *Descriptor, *Descriptor, error) {
+	reference := ref.Digest
+	if reference == "" {
+		reference = ref.Tag
+	}
+
+	desc, contents, err := reg.fetchManifest(ref.Repository, reference)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var listDesc *Descriptor
+	if desc.MediaType == MediaTypeDockerManifestList || desc.MediaType == MediaTypeOCIIndex {
+		listDesc = desc
+		index := &Index{}
+		if err := json.Unmarshal(contents, index); err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to json-decode manifest list: %v", err)
+		}
+
+		selected, err := selectManifest(index, platform)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("%v in image %s", err, ref.RepoTag())
+		}
+
+		log.Debugf("select the manifest %s of platform %s from the list",
+			selected.Digest, selected.Platform)
+		desc, contents, err = reg.fetchManifest(ref.Repository, selected.Digest)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	if desc.MediaType != MediaTypeDockerManifest && desc.MediaType != MediaTypeOCIManifest {
+		return nil, nil, nil, fmt.Errorf("unsupported manifest media type: %s", desc.MediaType)
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(contents, manifest); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to json-decode manifest: %v", err)
+	}
+	if manifest.Config == nil {
+		return nil, nil, nil, fmt.Errorf("the manifest %s has no image config", desc.Digest)
+	}
+
+	return manifest, desc, listDesc, nil
+}
+
+// GetBlob returns the contents of the blob, whose digest will be
+// verified when the whole contents are read, i.e. the last Read()
+// returns an error other than io.EOF if the digest is mismatched.
+func (reg *Registry) GetBlob(repo string, desc *Descriptor) (io.ReadCloser, error) {
+	resp, err := reg.request(repo, "/blobs/"+desc.Digest, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := NewDigestReader(resp.Body, desc.Digest)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{verifier, resp.Body}, nil
+}
+
+// GetConfig fetches and verifies the image config blob,
+// the raw contents of the blob are returned as well.
+func (reg *Registry) GetConfig(repo string, desc *Descriptor) (*ImageConfig, []byte, error) {
+	blob, err := reg.GetBlob(repo, desc)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer blob.Close()
+
+	contents, err := ioutil.ReadAll(blob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read image config %s: %v", desc.Digest, err)
+	}
+
+	config, err := ParseConfig(contents)
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, contents, nil
+}
+
+func (reg *Registry) fetchManifest(repo, reference string) (*Descriptor, []byte, error) {
+	headers := map[string]string{
+		"Accept": strings.Join(manifestMediaTypes, ", "),
+	}
+	resp, err := reg.request(repo, "/manifests/"+reference, headers)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read manifest %s: %v", reference, err)
+	}
+
+	desc := &Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(contents)),
+		Size:      int64(len(contents)),
+	}
+
+	// the manifest fetched by digest must be verified.
+	if strings.HasPrefix(reference, "sha256:") && reference != desc.Digest {
+		return nil, nil, fmt.Errorf("the digest of manifest %s is mismatched: %s",
+			reference, desc.Digest)
+	}
+
+	// some registries don't return the correct content type,
+	// so we fall back to the mediaType field of the manifest.
+	if i := strings.Index(desc.MediaType, ";"); i >= 0 {
+		desc.MediaType = desc.MediaType[:i]
+	}
+	if !isManifestMediaType(desc.MediaType) {
+		aux := &struct {
+			MediaType string            `json:"mediaType"`
+			Manifests []json.RawMessage `json:"manifests"`
+		}{}
+		if err := json.Unmarshal(contents, aux); err != nil {
+			return nil, nil, fmt.Errorf("failed to json-decode manifest: %v", err)
+		}
+		switch {
+		case aux.MediaType != "":
+			desc.MediaType = aux.MediaType
+		case aux.Manifests != nil:
+			desc.MediaType = MediaTypeOCIIndex
+		default:
+			desc.MediaType = MediaTypeOCIManifest
+		}
+	}
+
+	return desc, contents, nil
+}
+
+// request sends GET request to the registry's v2 api, and handles the
+// token authentication automatically if the registry requires it.
+// ref: https://docs.docker.com/registry/spec/auth/token/
+func (reg *Registry) request(repo, api string, headers map[string]string) (*http.Response, error) {
+	reqUrl := fmt.Sprintf("%s/v2/%s%s", reg.Endpoint, repo, api)
+	scope := fmt.Sprintf("repository:%s:pull", repo)
+
+	for retried := false; ; retried = true {
+		req, err := http.NewRequest(http.MethodGet, reqUrl, nil)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		if token, ok := reg.tokens[scope]; ok {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		log.Debugf("requesting %s", reqUrl)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to request %s: %v", reqUrl, err)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			return resp, nil
+		case resp.StatusCode == http.StatusNotFound:
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to request %s: %w", reqUrl, errNotFound)
+		case resp.StatusCode == http.StatusUnauthorized && !retried:
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if err := reg.fetchToken(challenge, scope); err != nil {
+				return nil, err
+			}
+		default:
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to request %s: %s", reqUrl, resp.Status)
+		}
+	}
+}
+
+func (reg *Registry) fetchToken(challenge, scope string) error {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return fmt.Errorf("unsupported authentication challenge: %q", challenge)
+	}
+
+	params := map[string]string{}
+	for _, match := range challengeRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("missing realm in authentication challenge: %q", challenge)
+	}
+
+	query := url.Values{}
+	query.Set("scope", scope)
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+
+	tokenUrl := params["realm"] + "?" + query.Encode()
+	log.Debugf("requesting an anonymous token from %s", tokenUrl)
+	resp, err := http.Get(tokenUrl)
+	if err != nil {
+		return fmt.Errorf("failed to request token from %s: %v", tokenUrl, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to request token from %s: %s", tokenUrl, resp.Status)
+	}
+
+	aux := &struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(aux); err != nil {
+		return fmt.Errorf("failed to json-decode token: %v", err)
+	}
+
+	if aux.Token == "" {
+		aux.Token = aux.AccessToken
+	}
+	reg.tokens[scope] = aux.Token
+	return nil
+}
+
+// selectManifest selects the manifest matching the platform, the one
+// with the same variant is preferred to those without any variant.
+func selectManifest(index *Index, platform *Platform) (*Descriptor, error) {
+	var selected *Descriptor
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil || !platform.Match(desc.Platform) {
+			continue
+		}
+		if platform.Variant == normalizeVariant(desc.Platform.Architecture, desc.Platform.Variant) {
+			return desc, nil
+		}
+		if selected == nil {
+			selected = desc
+		}
+	}
+
+	if selected == nil {
+		return nil, fmt.Errorf("no matching manifest for %s", platform)
+	}
+	return selected, nil
+}
+
+func isManifestMediaType(mediaType string) bool {
+	for _, mt := range manifestMediaTypes {
+		if mt == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+type DigestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	digest string
+}
+
+// NewDigestReader wraps the reader r to verify the digest of
+// all the contents read from r, only sha256 is supported now.
+func NewDigestReader(r io.Reader, digest string) (*DigestReader, error) {
+	if !digestRegexp.MatchString(digest) {
+		return nil, fmt.Errorf("unsupported digest: %s", digest)
+	}
+	return &DigestReader{
+		reader: r,
+		hash:   sha256.New(),
+		digest: digest,
+	}, nil
+}
+
+// NewDigestCalculator wraps the reader r to calculate the
+// digest of all the contents read from r without verifying.
+func NewDigestCalculator(r io.Reader) (*DigestReader, error) {
+	return &DigestReader{
+		reader: r,
+		hash:   sha256.New(),
+	}, nil
+}
+
+func (dr *DigestReader) Read(p []byte) (int, error) {
+	n, err := dr.reader.Read(p)
+	dr.hash.Write(p[:n])
+	if err == io.EOF && dr.digest != "" {
+		if actual := dr.calculated(); actual != dr.digest {
+			return n, fmt.Errorf("the digest %s is mismatched, got %s", dr.digest, actual)
+		}
+	}
+	return n, err
+}
+
+// Digest returns the expected digest, or the calculated digest
+// of the contents which have been read from the reader so far.
+func (dr *DigestReader) Digest() string {
+	if dr.digest != "" {
+		return dr.digest
+	}
+	return dr.calculated()
+}
+
+func (dr *DigestReader) calculated() string {
+	return "sha256:" + hex.EncodeToString(dr.hash.Sum(nil))
+}
@@ -0,0 +1,303 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"weike.sh/mydocker/util"
+)
+
+type testFile struct {
+	name     string
+	contents string
+	dir      bool
+}
+
+// testRegistry is an in-process stand-in of the OCI distribution api,
+// which only serves the manifests and blobs of the pull operations.
+type testRegistry struct {
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	types     map[string]string
+}
+
+func makeLayer(t *testing.T, files []testFile) (blob []byte, diffID string) {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.contents))}
+		if f.dir {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(f.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gzBuf := &bytes.Buffer{}
+	gz := gzip.NewWriter(gzBuf)
+	gz.Write(buf.Bytes())
+	gz.Close()
+
+	return gzBuf.Bytes(), digestOf(buf.Bytes())
+}
+
+func newTestRegistry(t *testing.T, repo, tag string) (*httptest.Server, *testRegistry) {
+	reg := &testRegistry{
+		blobs:     map[string][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+	}
+
+	base, baseDiffID := makeLayer(t, []testFile{
+		{name: "etc/", dir: true},
+		{name: "etc/hostname", contents: "base\n"},
+		{name: "tmp/", dir: true},
+		{name: "tmp/deleted", contents: "deleted"},
+		{name: "opt/app/", dir: true},
+		{name: "opt/app/old", contents: "old"},
+	})
+	top, topDiffID := makeLayer(t, []testFile{
+		{name: "tmp/.wh.deleted"},
+		{name: "opt/app/.wh..wh..opq"},
+		{name: "opt/app/new", contents: "new"},
+		{name: "etc/hostname", contents: "top\n"},
+	})
+
+	config, _ := json.Marshal(&ImageConfig{
+		Architecture: runtime.GOARCH,
+		OS:           runtime.GOOS,
+		Config: &ContainerConfig{
+			Entrypoint: []string{"/bin/app"},
+			Cmd:        []string{"--serve"},
+			Env:        []string{"PATH=/bin"},
+			WorkingDir: "/opt/app",
+		},
+		RootFS: &RootFS{Type: "layers", DiffIDs: []string{baseDiffID, topDiffID}},
+	})
+
+	manifest, _ := json.Marshal(&Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        &Descriptor{Digest: digestOf(config), Size: int64(len(config))},
+		Layers: []*Descriptor{
+			{Digest: digestOf(base), Size: int64(len(base))},
+			{Digest: digestOf(top), Size: int64(len(top))},
+		},
+	})
+
+	index, _ := json.Marshal(&Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIIndex,
+		Manifests: []*Descriptor{
+			{
+				MediaType: MediaTypeOCIManifest,
+				Digest:    digestOf([]byte("another platform")),
+				Platform:  &Platform{OS: "windows", Architecture: "386"},
+			},
+			{
+				MediaType: MediaTypeOCIManifest,
+				Digest:    digestOf(manifest),
+				Platform:  &Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH},
+			},
+		},
+	})
+
+	for _, blob := range [][]byte{config, base, top} {
+		reg.blobs[digestOf(blob)] = blob
+	}
+	reg.manifests[tag] = index
+	reg.types[tag] = MediaTypeOCIIndex
+	reg.manifests[digestOf(manifest)] = manifest
+	reg.types[digestOf(manifest)] = MediaTypeOCIManifest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if r.URL.Query().Get("scope") != "repository:"+repo+":pull" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"token": "secret"}`)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="http://%s/token",service="test"`, r.Host))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		prefix := "/v2/" + repo
+		switch {
+		case strings.HasPrefix(r.URL.Path, prefix+"/manifests/"):
+			reference := path.Base(r.URL.Path)
+			if contents, ok := reg.manifests[reference]; ok {
+				w.Header().Set("Content-Type", reg.types[reference])
+				w.Write(contents)
+				return
+			}
+		case strings.HasPrefix(r.URL.Path, prefix+"/blobs/"):
+			if contents, ok := reg.blobs[path.Base(r.URL.Path)]; ok {
+				w.Write(contents)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	return server, reg
+}
+
+func setupImagesDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydocker-images-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore := SetStoreDir(dir)
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+		restore()
+	})
+}
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	cases := []struct {
+		name, domain, repo, tag, digest, repoTag string
+	}{
+		{"ubuntu", "docker.io", "library/ubuntu", "latest", "", "ubuntu:latest"},
+		{"weikeit/mydocker:v1", "docker.io", "weikeit/mydocker", "v1", "", "weikeit/mydocker:v1"},
+		{"localhost:5000/app", "localhost:5000", "app", "latest", "", "localhost:5000/app:latest"},
+		{"quay.io/coreos/etcd:v3.3", "quay.io", "coreos/etcd", "v3.3", "", "quay.io/coreos/etcd:v3.3"},
+		{"alpine@" + digest, "docker.io", "library/alpine", "", digest, "alpine@" + digest},
+	}
+
+	for _, c := range cases {
+		ref, err := ParseReference(c.name)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", c.name, err)
+			continue
+		}
+		if ref.Domain != c.domain || ref.Repository != c.repo ||
+			ref.Tag != c.tag || ref.Digest != c.digest || ref.RepoTag() != c.repoTag {
+			t.Errorf("unexpected reference of %s: %+v", c.name, ref)
+		}
+	}
+
+	for _, name := range []string{"", "Ubuntu", "ubuntu:", "ubuntu@sha256:123"} {
+		if _, err := ParseReference(name); err == nil {
+			t.Errorf("expected error when parsing %q", name)
+		}
+	}
+}
+
+func TestPull(t *testing.T) {
+	// the whiteouts of overlay are char devices and trusted xattrs.
+	if os.Geteuid() != 0 {
+		t.Skip("pulling images requires root")
+	}
+
+	setupImagesDir(t)
+	server, _ := newTestRegistry(t, "test/app", "v1")
+	defer server.Close()
+
+	imageName := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	if err := Pull(imageName, ""); err != nil {
+		t.Fatalf("failed to pull image: %v", err)
+	}
+
+	img, err := GetImageByNameOrUuid(imageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.WorkingDir != "/opt/app" || img.Entrypoint[0] != "/bin/app" ||
+		img.Command[0] != "--serve" || img.Envs[0] != "PATH=/bin" {
+		t.Errorf("unexpected image config: %+v", img)
+	}
+
+	dirs, err := img.LowerDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 2 {
+		t.Fatalf("expected 2 layers, got %v", dirs)
+	}
+	top, base := dirs[0], dirs[1]
+
+	expected := map[string]string{
+		path.Join(base, "etc/hostname"): "base\n",
+		path.Join(base, "opt/app/old"):  "old",
+		path.Join(top, "etc/hostname"):  "top\n",
+		path.Join(top, "opt/app/new"):   "new",
+	}
+	for name, contents := range expected {
+		actual, err := ioutil.ReadFile(name)
+		if err != nil || string(actual) != contents {
+			t.Errorf("unexpected contents of %s: %q, %v", name, actual, err)
+		}
+	}
+
+	if img.Whiteout() == WhiteoutOverlay {
+		fi, err := os.Lstat(path.Join(top, "tmp/deleted"))
+		if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+			t.Errorf("the whiteout of tmp/deleted should be a char device: %v", err)
+		}
+		opaque := make([]byte, 1)
+		if _, err := syscall.Getxattr(path.Join(top, "opt/app"), OverlayOpaque, opaque); err != nil ||
+			opaque[0] != 'y' {
+			t.Errorf("the dir opt/app should be opaque: %v", err)
+		}
+	}
+
+	// the unreferenced layers are removed with the image.
+	if err := Delete(imageName, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if exist, _ := util.FileOrDirExists(dir); exist {
+			t.Errorf("the layer %s should be removed", dir)
+		}
+	}
+}
+
+func TestPullDigestMismatch(t *testing.T) {
+	setupImagesDir(t)
+	server, reg := newTestRegistry(t, "test/app", "v1")
+	defer server.Close()
+
+	// corrupt all the layers but keep their digests.
+	for digest, blob := range reg.blobs {
+		if blob[0] == 0x1f {
+			corrupted, _ := makeLayer(t, []testFile{{name: "evil", contents: "evil"}})
+			reg.blobs[digest] = corrupted
+		}
+	}
+
+	imageName := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	if err := Pull(imageName, ""); err == nil {
+		t.Fatalf("expected digest mismatch error")
+	}
+	if Exist(imageName) {
+		t.Errorf("the corrupted image shouldn't be registered")
+	}
+}
@@ -0,0 +1,182 @@
+package image
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// the payloads larger than it aren't simple signing payloads.
+const maxPayloadSize = 1 << 20
+
+type publicKey struct {
+	path string
+	key  crypto.PublicKey
+}
+
+// the simple signing payload signed by cosign, the optional
+// annotations aren't used, so they are omitted here.
+type simpleSigning struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+func loadPublicKeys(paths []string) ([]*publicKey, error) {
+	var keys []*publicKey
+	for _, keyPath := range paths {
+		contents, err := ioutil.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key %s: %v", keyPath, err)
+		}
+
+		block, _ := pem.Decode(contents)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			return nil, fmt.Errorf("the public key %s isn't PEM-encoded", keyPath)
+		}
+
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key %s: %v", keyPath, err)
+		}
+		keys = append(keys, &publicKey{path: keyPath, key: key})
+	}
+	return keys, nil
+}
+
+// verify verifies the signature by any of the keys, and checks the
+// payload is signed for the manifest digest of the image named by ref,
+// the identity isn't checked if ref is nil. the path of key is returned.
+func (sig *Signature) verify(keys []*publicKey, ref *Reference, digest string) (string, error) {
+	var signer *publicKey
+	for _, k := range keys {
+		if verifySignature(k.key, sig.Payload, sig.Signature) {
+			signer = k
+			break
+		}
+	}
+	if signer == nil {
+		return "", fmt.Errorf("no public key verifies the signature")
+	}
+
+	payload := &simpleSigning{}
+	if err := json.Unmarshal(sig.Payload, payload); err != nil {
+		return "", fmt.Errorf("failed to json-decode signature payload: %v", err)
+	}
+	if payload.Critical.Type != CosignSignatureType {
+		return "", fmt.Errorf("unsupported signature type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != digest {
+		return "", fmt.Errorf("the signature is for another digest %s",
+			payload.Critical.Image.DockerManifestDigest)
+	}
+
+	if ref != nil {
+		identity := payload.Critical.Identity.DockerReference
+		signed, err := ParseReference(identity)
+		if err != nil {
+			return "", fmt.Errorf("invalid identity of signature: %v", err)
+		}
+		// cosign names the docker hub as index.docker.io.
+		if signed.Domain == "index."+DefaultDomain {
+			signed.Domain = DefaultDomain
+		}
+		if signed.Domain != ref.Domain || signed.Repository != ref.Repository {
+			return "", fmt.Errorf("the signature is for another image %s", identity)
+		}
+	}
+
+	return signer.path, nil
+}
+
+func verifySignature(key crypto.PublicKey, payload, signature []byte) bool {
+	hashed := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hashed[:], signature)
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hashed[:], signature) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, hashed[:], signature, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, signature)
+	}
+	return false
+}
+
+// GetSignatures fetches the cosign signatures of the manifest digest
+// in the repo, no signature is returned if the digest isn't signed.
+func (reg *Registry) GetSignatures(repo, digest string) ([]*Signature, error) {
+	_, contents, err := reg.fetchManifest(repo, SignatureTag(digest))
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(contents, manifest); err != nil {
+		return nil, fmt.Errorf("failed to json-decode signature manifest: %v", err)
+	}
+
+	return parseSignatures(manifest, func(desc *Descriptor) ([]byte, error) {
+		blob, err := reg.GetBlob(repo, desc)
+		if err != nil {
+			return nil, err
+		}
+		defer blob.Close()
+		return ioutil.ReadAll(blob)
+	})
+}
+
+// SignatureTag returns the tag of cosign signatures of the digest,
+// e.g. sha256:8ee3...f0e9 => sha256-8ee3...f0e9.sig
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + CosignSignatureSuffix
+}
+
+// parseSignatures returns the signatures of the signature manifest,
+// the payloads are the layers, which are read by readBlob.
+func parseSignatures(manifest *Manifest, readBlob func(*Descriptor) ([]byte, error)) ([]*Signature, error) {
+	var signatures []*Signature
+	for _, layer := range manifest.Layers {
+		encoded, ok := layer.Annotations[AnnotationCosignSig]
+		if layer.MediaType != MediaTypeCosignPayload || !ok {
+			continue
+		}
+		if layer.Size > maxPayloadSize {
+			return nil, fmt.Errorf("the signature payload %s is too large", layer.Digest)
+		}
+
+		signature, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signature of %s: %v", layer.Digest, err)
+		}
+
+		payload, err := readBlob(layer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature payload %s: %v", layer.Digest, err)
+		}
+
+		signatures = append(signatures, &Signature{Payload: payload, Signature: signature})
+	}
+	return signatures, nil
+}
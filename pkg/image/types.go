@@ -1,6 +1,11 @@
 package image
 
+// each entry of repositories.json names an image by one RepoTag, the
+// image with multiple repotags has an entry for each of them, which
+// share the same Id, Uuid, Counts and Layers.
 type Image struct {
+	// the sha256 digest of the image config, Uuid is short for it.
+	Id         string   `json:"Id"`
 	Uuid       string   `json:"Uuid"`
 	Size       string   `json:"Size"`
 	Counts     int      `json:"Counts"`
@@ -10,4 +15,141 @@ type Image struct {
 	Entrypoint []string `json:"Entrypoint"`
 	Command    []string `json:"Command"`
 	Envs       []string `json:"Envs"`
+	// the metadata copied from the image config for inspect.
+	User         string            `json:"User,omitempty"`
+	Labels       map[string]string `json:"Labels,omitempty"`
+	ExposedPorts []string          `json:"ExposedPorts,omitempty"`
+	Volumes      []string          `json:"Volumes,omitempty"`
+	Architecture string            `json:"Architecture,omitempty"`
+	OS           string            `json:"OS,omitempty"`
+	Variant      string            `json:"Variant,omitempty"`
+	StopSignal   string            `json:"StopSignal,omitempty"`
+	// the verified digest of the manifest pulled from the registry by
+	// RepoTag or loaded from an OCI layout, it's empty if the image is
+	// loaded from a docker-archive, imported or built locally.
+	Digest string `json:"Digest,omitempty"`
+	// the public key verifying the signature of Digest, it's only set
+	// if the policy requires signatures for the image.
+	Signer string `json:"Signer,omitempty"`
+	// chain ids of the image's layers, from bottom to top.
+	Layers []string `json:"Layers"`
+	// the id of the image which this image is built from, and the
+	// hash of the build step, which are only set by `mydocker build`.
+	Parent   string `json:"Parent,omitempty"`
+	CacheKey string `json:"CacheKey,omitempty"`
+}
+
+// each layer is extracted into its own dir in the layer store,
+// and shared by all the images based on the same parent layers.
+type Layer struct {
+	ChainID  string `json:"ChainID"`
+	DiffID   string `json:"DiffID"`
+	Parent   string `json:"Parent"`
+	Size     int64  `json:"Size"`
+	Refs     int    `json:"Refs"`
+	Whiteout string `json:"Whiteout"`
+}
+
+// e.g. docker.io/library/ubuntu:18.04 or localhost:5000/app@sha256:...
+type Reference struct {
+	Domain     string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Policy decides whether an image can be pulled or loaded, by the
+// requirement of the longest scope matching the image's name, e.g.
+// docker.io, docker.io/library or docker.io/library/ubuntu.
+type Policy struct {
+	Default *PolicyRequirement            `json:"default"`
+	Scopes  map[string]*PolicyRequirement `json:"scopes,omitempty"`
+}
+
+type PolicyRequirement struct {
+	Type string `json:"type"`
+	// the PEM-encoded public keys, any of which verifies the
+	// signature is enough, only used by sigstoreSigned.
+	KeyPaths []string `json:"keyPaths,omitempty"`
+}
+
+// Signature is a cosign signature of the simple signing payload.
+type Signature struct {
+	Payload   []byte
+	Signature []byte
+}
+
+type Registry struct {
+	Domain   string
+	Endpoint string
+	// key is the scope of a token, e.g. repository:library/ubuntu:pull
+	tokens map[string]string
+}
+
+///////////////////////////////////////////////////////////////////
+// the following types are defined by the OCI image-spec, which  //
+// are compatible with docker's image manifest v2, schema 2.     //
+// ref: https://github.com/opencontainers/image-spec/tree/main   //
+///////////////////////////////////////////////////////////////////
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// docker's manifest list shares the same format with OCI's image index.
+type Index struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	MediaType     string        `json:"mediaType,omitempty"`
+	Manifests     []*Descriptor `json:"manifests"`
+}
+
+type Manifest struct {
+	SchemaVersion int           `json:"schemaVersion"`
+	MediaType     string        `json:"mediaType,omitempty"`
+	Config        *Descriptor   `json:"config"`
+	Layers        []*Descriptor `json:"layers"`
+}
+
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type History struct {
+	Created    string `json:"created,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	Comment    string `json:"comment,omitempty"`
+	EmptyLayer bool   `json:"empty_layer,omitempty"`
+}
+
+type ImageConfig struct {
+	Created      string           `json:"created,omitempty"`
+	Architecture string           `json:"architecture"`
+	OS           string           `json:"os"`
+	Variant      string           `json:"variant,omitempty"`
+	Config       *ContainerConfig `json:"config,omitempty"`
+	RootFS       *RootFS          `json:"rootfs"`
+	History      []*History       `json:"history,omitempty"`
 }
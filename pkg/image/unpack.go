@@ -0,0 +1,115 @@
+package image
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"weike.sh/mydocker/util"
+)
+
+// ApplyLayer extracts the uncompressed layer tarball read from r into
+// the empty dir, and converts the whiteout files of the layer into the
+// format understood by the storage driver, the layer dir is meant to be
+// stacked onto the dirs of its parent layers by the union mount.
+// it returns the total size of regular files in the layer.
+func ApplyLayer(dir string, r io.Reader, whiteout string) (int64, error) {
+	var size int64
+	// the mtime of dirs must be set after extracting their children.
+	dirTimes := map[string]time.Time{}
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read layer tarball: %v", err)
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+
+		parent, base := path.Split(name)
+		parentDir, err := util.SecureJoin(dir, parent)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return 0, fmt.Errorf("failed to mkdir %s: %v", parentDir, err)
+		}
+
+		// aufs understands the whiteout files of the tarball
+		// directly, so they are extracted as regular files.
+		if whiteout == WhiteoutOverlay {
+			switch {
+			case base == WhiteoutOpaque:
+				if err := syscall.Setxattr(parentDir, OverlayOpaque, []byte("y"), 0); err != nil {
+					return 0, fmt.Errorf("failed to apply opaque whiteout %s: %v", name, err)
+				}
+				continue
+			case strings.HasPrefix(base, WhiteoutPrefix):
+				target := path.Join(parentDir, strings.TrimPrefix(base, WhiteoutPrefix))
+				if err := syscall.Mknod(target, syscall.S_IFCHR, 0); err != nil {
+					return 0, fmt.Errorf("failed to apply whiteout %s: %v", name, err)
+				}
+				continue
+			}
+		}
+
+		target := path.Join(parentDir, base)
+		if err := util.ExtractEntry(dir, target, hdr, tr); err != nil {
+			return 0, fmt.Errorf("failed to extract %s: %v", name, err)
+		}
+
+		if hdr.Typeflag == tar.TypeReg {
+			size += hdr.Size
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirTimes[target] = hdr.ModTime
+		}
+	}
+
+	for target, mtime := range dirTimes {
+		os.Chtimes(target, mtime, mtime)
+	}
+
+	// drain the trailing paddings of the tarball, so that
+	// the digest of the whole contents can be verified.
+	if _, err := io.Copy(ioutil.Discard, r); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// Decompress returns the uncompressed stream of the layer tarball
+// read from r, only gzip is supported now.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read layer tarball: %v", err)
+	}
+
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress layer tarball: %v", err)
+		}
+		return gz, nil
+	}
+
+	return br, nil
+}
@@ -5,12 +5,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
-	"strconv"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/c2h5oh/datasize"
+	log "github.com/sirupsen/logrus"
 	"weike.sh/mydocker/util"
 )
 
@@ -27,102 +27,337 @@ func Exist(imageName string) bool {
 	return false
 }
 
-func Pull(imageName string) error {
+// Pull pulls the image from the registry, the manifest of the platform
+// is selected from the manifest list, e.g. linux/arm64, which is the
+// platform of the host if it's empty.
+func Pull(imageName, platform string) error {
 	if err := Load(); err != nil {
 		return err
 	}
 
-	var cmd *exec.Cmd
+	p := HostPlatform()
+	if platform != "" {
+		var err error
+		if p, err = ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
 
-	cmd = exec.Command("docker", "inspect", imageName)
-	imageExist := cmd.Run() == nil
+	policy, err := LoadPolicy()
+	if err != nil {
+		return err
+	}
 
-	cmd = exec.Command("docker", "pull", imageName)
-	if !imageExist {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+	ref, err := ParseReference(imageName)
+	if err != nil {
+		return err
 	}
-	if err := cmd.Run(); !imageExist && err != nil {
-		return fmt.Errorf("failed to pull image %s: %v",
-			imageName, err)
+
+	tagOrDigest := ref.Tag
+	if tagOrDigest == "" {
+		tagOrDigest = ref.Digest
 	}
 
-	fmtArgs := []string{
-		"{{.Id}}",
-		"{{.Size}}",
-		"{{.Config.WorkingDir}}",
-		"{{json .RepoTags}}",
-		"{{json .Config.Entrypoint}}",
-		"{{json .Config.Cmd}}",
-		"{{json .Config.Env}}",
+	fmt.Printf("%s: Pulling from %s\n", tagOrDigest, ref.Repository)
+	reg := NewRegistry(ref.Domain)
+	manifest, desc, listDesc, err := reg.getManifest(ref, p)
+	if err != nil {
+		return fmt.Errorf("failed to get manifest of image %s: %v", imageName, err)
 	}
 
-	format := strings.Join(fmtArgs, "#")
-	cmd = exec.Command("docker", "inspect", "-f", format, imageName)
-	outBytes, err := cmd.Output()
+	// the image is verified by the policy before its layers are
+	// made, the signature maybe of the manifest list or manifest.
+	digests := []string{desc.Digest}
+	if listDesc != nil {
+		digests = append([]string{listDesc.Digest}, digests...)
+	}
+	signer, err := policy.Verify(ref, digests, func(digest string) ([]*Signature, error) {
+		return reg.GetSignatures(ref.Repository, digest)
+	})
 	if err != nil {
 		return err
 	}
+	if signer != "" {
+		fmt.Printf("Verified: signed by %s\n", signer)
+	}
 
-	// notes: need to remove the tailing newline \n.
-	outs := strings.Split(strings.Trim(string(outBytes), "\n"), "#")
+	config, contents, err := reg.GetConfig(ref.Repository, manifest.Config)
+	if err != nil {
+		return fmt.Errorf("failed to get config of image %s: %v", imageName, err)
+	}
+
+	// the image of a single manifest maybe for another platform.
+	configPlatform := &Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	if !p.Match(configPlatform) {
+		if platform != "" {
+			return fmt.Errorf("the image %s is for platform %s, which doesn't match %s",
+				imageName, configPlatform, p)
+		}
+		log.Warnf("the image %s is for platform %s, which doesn't match the host %s",
+			imageName, configPlatform, p)
+	}
 
-	byteSize, err := strconv.Atoi(outs[1])
+	img := NewImage(ref.RepoTag(), manifest.Config.Digest, config)
+	img.Digest, img.Signer = desc.Digest, signer
+	err = AddImage(img, contents, func() (int64, error) {
+		return img.MakeLayers(reg, ref.Repository, manifest, config)
+	})
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to make layers for image %s: %v", imageName, err)
 	}
 
-	size := datasize.ByteSize(byteSize).HumanReadable()
+	fmt.Printf("Digest: %s\n", desc.Digest)
+	fmt.Printf("Status: Downloaded newer image for %s\n", img.RepoTag)
+	return nil
+}
 
-	var tags, epts, cmds, envs []string
-	if err := json.Unmarshal([]byte(outs[3]), &tags); err != nil {
-		return err
+// NewImage returns an image named repoTag, whose
+// config's digest is id, the layers aren't made yet.
+func NewImage(repoTag, id string, config *ImageConfig) *Image {
+	img := &Image{
+		Id: id,
+		// the first 12 chars of sha256 checksum of image config.
+		Uuid:       shortDigest(id),
+		Counts:     0,
+		RepoTag:    repoTag,
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
 	}
-	if err := json.Unmarshal([]byte(outs[4]), &epts); err != nil {
+	img.setMetadata(config)
+	return img
+}
+
+func (img *Image) setMetadata(config *ImageConfig) {
+	img.WorkingDir = config.Config.WorkingDir
+	img.Entrypoint = config.Config.Entrypoint
+	img.Command = config.Config.Cmd
+	img.Envs = config.Config.Env
+	img.User = config.Config.User
+	img.Labels = config.Config.Labels
+	img.ExposedPorts = sortedKeys(config.Config.ExposedPorts)
+	img.Volumes = sortedKeys(config.Config.Volumes)
+	img.Architecture = config.Architecture
+	img.OS = config.OS
+	img.Variant = normalizeVariant(config.Architecture, config.Variant)
+	img.StopSignal = config.Config.StopSignal
+}
+
+// AddImage registers the image into repositories.json, makeLayers is
+// called only if no image with the same uuid exists, and the original
+// image config is kept with the layers. the image which has the same
+// repotag but a different uuid is untagged, like docker does.
+func AddImage(img *Image, config []byte, makeLayers func() (int64, error)) error {
+	if err := Load(); err != nil {
 		return err
 	}
-	if err := json.Unmarshal([]byte(outs[5]), &cmds); err != nil {
+
+	// the layers are made without holding the lock of images, so
+	// that different images can be pulled by mydocker in parallel.
+	made := false
+	if sameImage(img.Uuid) == nil {
+		if err := img.create(config, makeLayers); err != nil {
+			return err
+		}
+		made = true
+	}
+
+	unlock, err := util.LockFile(ImagesConfigFile)
+	if err != nil {
+		if made {
+			ReleaseLayers(img.Layers)
+		}
 		return err
 	}
-	if err := json.Unmarshal([]byte(outs[6]), &envs); err != nil {
+	defer unlock()
+
+	if err := Load(); err != nil {
 		return err
 	}
 
-	// same image maybe have multiple repotags.
-	for idx, repoTag := range tags {
-		img := &Image{
-			// fetch the first 12 chars of sha256 checksum of image.
-			Uuid:       outs[0][7:19],
-			Size:       size,
-			Counts:     0,
-			WorkingDir: outs[2],
-			RepoTag:    repoTag,
-			CreateTime: time.Now().Format("2006-01-02 15:04:05"),
-			Entrypoint: epts,
-			Command:    cmds,
-			Envs:       envs,
+	// the same image maybe have multiple repotags, so
+	// call makeLayers only once for images with same uuid.
+	sameImg := sameImage(img.Uuid)
+	if sameImg != nil && made {
+		// the image was added by another mydocker meanwhile.
+		if err := ReleaseLayers(img.Layers); err != nil {
+			return err
+		}
+	}
+	if sameImg == nil && !made {
+		// the image was deleted by another mydocker meanwhile.
+		if err := img.create(config, makeLayers); err != nil {
+			return err
 		}
+	}
+
+	for _, existImg := range Images {
+		if existImg.Uuid == img.Uuid && existImg.RepoTag == img.RepoTag {
+			return nil
+		}
+	}
 
-		if idx == 0 {
-			// call MakeRootfs only once for images with same uuid.
-			if err := img.MakeRootfs(); err != nil {
-				return fmt.Errorf("failed to make rootfs for image %s: %v",
-					imageName, err)
+	// an untagged image is just named, rather than listed twice.
+	if sameImg != nil && img.RepoTag == NoneRepoTag {
+		return nil
+	}
+
+	if sameImg != nil {
+		img.Size = sameImg.Size
+		img.Counts = sameImg.Counts
+		img.Layers = sameImg.Layers
+	}
+
+	if img.RepoTag != NoneRepoTag {
+		for _, existImg := range Images {
+			if existImg.RepoTag == img.RepoTag {
+				existImg.RepoTag = NoneRepoTag
+				existImg.Digest, existImg.Signer = "", ""
 			}
 		}
+	}
 
+	if sameImg != nil && sameImg.RepoTag == NoneRepoTag {
+		sameImg.RepoTag = img.RepoTag
+		sameImg.Digest, sameImg.Signer = img.Digest, img.Signer
+	} else {
 		Images = append(Images, img)
 	}
 
+	removeUntagged()
 	return Dump()
 }
 
-func Delete(identifier string) error {
+// create makes the layers of the new image and saves its config.
+func (img *Image) create(config []byte, makeLayers func() (int64, error)) error {
+	byteSize, err := makeLayers()
+	if err != nil {
+		return err
+	}
+	if err := img.saveConfig(config); err != nil {
+		ReleaseLayers(img.Layers)
+		return err
+	}
+	img.Size = datasize.ByteSize(byteSize).HumanReadable()
+	return nil
+}
+
+// sameImage returns the first image whose uuid is uuid.
+func sameImage(uuid string) *Image {
+	for _, img := range Images {
+		if img.Uuid == uuid {
+			return img
+		}
+	}
+	return nil
+}
+
+// removeUntagged removes the untagged entries of the images
+// which are still named by other repotags.
+func removeUntagged() {
+	tagged := map[string]bool{}
+	for _, img := range Images {
+		if img.RepoTag != NoneRepoTag {
+			tagged[img.Uuid] = true
+		}
+	}
+
+	tmpImages := append(Images[:0:0], Images...)
+	Images = Images[:0]
+	for _, img := range tmpImages {
+		if img.RepoTag != NoneRepoTag || !tagged[img.Uuid] {
+			Images = append(Images, img)
+		}
+	}
+}
+
+// Tag names the image identified by identifier as repoTag.
+func Tag(identifier, repoTag string) error {
+	img, err := GetImageByNameOrUuid(identifier)
+	if err != nil {
+		return err
+	}
+
+	ref, err := ParseReference(repoTag)
+	if err != nil {
+		return err
+	}
+
+	newImg := *img
+	newImg.RepoTag = ref.RepoTag()
+	// the digest is only valid for the repotag which is pulled.
+	newImg.Digest, newImg.Signer = "", ""
+	return AddImage(&newImg, nil, nil)
+}
+
+// Untag removes the repotag from its image, the image is kept as
+// an untagged image if the repotag is the last one of it.
+func Untag(repoTag string) error {
+	unlock, err := util.LockFile(ImagesConfigFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := Load(); err != nil {
+		return err
+	}
+
+	ref, err := ParseReference(repoTag)
+	if err != nil {
+		return err
+	}
+
+	for _, img := range Images {
+		if img.RepoTag == ref.RepoTag() {
+			img.RepoTag = NoneRepoTag
+			img.Digest, img.Signer = "", ""
+			removeUntagged()
+			return Dump()
+		}
+	}
+
+	return fmt.Errorf("no such image: %s", repoTag)
+}
+
+// RepoTags returns all the repotags of the image with uuid.
+func RepoTags(uuid string) []string {
+	var repoTags []string
+	for _, img := range Images {
+		if img.Uuid == uuid && img.RepoTag != NoneRepoTag {
+			repoTags = append(repoTags, img.RepoTag)
+		}
+	}
+	return repoTags
+}
+
+// Delete removes the image identified by identifier. if it's one of
+// the repotags of the image, only the repotag is removed unless it's
+// the last one; if it's the uuid of an image with multiple repotags,
+// force must be true to remove all of them, like `docker rmi -f`.
+func Delete(identifier string, force bool) error {
+	unlock, err := util.LockFile(ImagesConfigFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := UpdateCounts(); err != nil {
+		return err
+	}
+
 	thisImg, err := GetImageByNameOrUuid(identifier)
 	if err != nil {
 		return err
 	}
 
+	repoTags := RepoTags(thisImg.Uuid)
+	if thisImg.Uuid != identifier && len(repoTags) > 1 {
+		return Untag(thisImg.RepoTag)
+	}
+	if len(repoTags) > 1 && !force {
+		return fmt.Errorf("unable to delete %s (must be forced), the image "+
+			"is referenced by multiple repotags: %s", identifier, strings.Join(repoTags, ", "))
+	}
+
 	if thisImg.Counts > 0 {
 		return fmt.Errorf("there still exist %d containers using the image %s",
 			thisImg.Counts, thisImg.RepoTag)
@@ -141,7 +376,40 @@ func Delete(identifier string) error {
 		return err
 	}
 
-	return os.RemoveAll(thisImg.RootDir())
+	if thisImg.Id == "" {
+		return os.RemoveAll(thisImg.RootDir())
+	}
+
+	if err := os.RemoveAll(thisImg.ConfigFile()); err != nil {
+		return err
+	}
+	if err := ReleaseLayers(thisImg.Layers); err != nil {
+		return err
+	}
+	return deleteParent(thisImg.Parent)
+}
+
+// deleteParent deletes the parent of a deleted image if it's an
+// intermediate image of build, i.e. untagged, unused and childless.
+func deleteParent(parentId string) error {
+	if parentId == "" {
+		return nil
+	}
+
+	var parent *Image
+	for _, img := range Images {
+		if img.Parent == parentId {
+			return nil
+		}
+		if img.Id == parentId {
+			parent = img
+		}
+	}
+
+	if parent == nil || parent.RepoTag != NoneRepoTag || parent.Counts > 0 {
+		return nil
+	}
+	return Delete(parent.Uuid, false)
 }
 
 func GetImageByNameOrUuid(identifier string) (*Image, error) {
@@ -164,24 +432,52 @@ func GetImageByNameOrUuid(identifier string) (*Image, error) {
 	return nil, fmt.Errorf("no such image: %s", identifier)
 }
 
-func ChangeCounts(identifier, action string) error {
-	thisImg, err := GetImageByNameOrUuid(identifier)
+// Lock locks repositories.json like the functions modifying it, e.g. it's
+// held while creating a container, so that its image isn't deleted before
+// the config of the container referencing the image is written.
+func Lock() (func(), error) {
+	return util.LockFile(ImagesConfigFile)
+}
+
+// CountRefs returns the number of containers using each image keyed
+// by the image's uuid, it's set by the container package, which can't
+// be imported by this package.
+var CountRefs func() (map[string]int, error)
+
+// UpdateCounts rebuilds the counts of images from the configs of
+// containers, rather than tracking them when containers are created
+// and removed, which drift if a container failed to be created.
+func UpdateCounts() error {
+	if CountRefs == nil {
+		return Load()
+	}
+
+	unlock, err := util.LockFile(ImagesConfigFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	refs, err := CountRefs()
 	if err != nil {
+		return fmt.Errorf("failed to count the references of images: %v", err)
+	}
+
+	if err := Load(); err != nil {
 		return err
 	}
 
-	// change all images with same uuid.
+	changed := false
 	for _, img := range Images {
-		if img.Uuid == thisImg.Uuid {
-			switch action {
-			case "create":
-				img.Counts++
-			case "delete":
-				img.Counts--
-			}
+		if img.Counts != refs[img.Uuid] {
+			img.Counts = refs[img.Uuid]
+			changed = true
 		}
 	}
 
+	if !changed {
+		return nil
+	}
 	return Dump()
 }
 
@@ -195,9 +491,9 @@ func Dump() error {
 		return fmt.Errorf("failed to json-encode images: %v", err)
 	}
 
-	// WriteFile will create the file if it doesn't exist,
-	// otherwise WriteFile will truncate it before writing
-	if err := ioutil.WriteFile(ImagesConfigFile, jsonBytes, 0644); err != nil {
+	// the file is replaced atomically, so the other mydocker
+	// processes never read a partially written file.
+	if err := util.WriteFileAtomic(ImagesConfigFile, jsonBytes, 0644); err != nil {
 		return fmt.Errorf("failed to write images configs to file %s: %v",
 			ImagesConfigFile, err)
 	}
@@ -223,5 +519,25 @@ func Load() error {
 		return fmt.Errorf("failed to json-decode images: %v", err)
 	}
 
+	// the images added by old versions of mydocker don't have
+	// the metadata, which are filled from their image configs.
+	for _, img := range Images {
+		if img.Id == "" || img.Architecture != "" {
+			continue
+		}
+		if config, _, err := img.LoadConfig(); err == nil {
+			img.setMetadata(config)
+		}
+	}
+
 	return nil
 }
+
+func sortedKeys(m map[string]struct{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagAndDelete(t *testing.T) {
+	setupImagesDir(t)
+
+	contents, err := NewConfig(ScratchConfig(), &ContainerConfig{
+		User:         "nobody",
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+	}, "", "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := CreateImage("app:v1", contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.User != "nobody" || !reflect.DeepEqual(img.ExposedPorts, []string{"80/tcp"}) {
+		t.Errorf("unexpected metadata: %+v", img)
+	}
+
+	if err := Tag("app:v1", "app"); err != nil {
+		t.Fatal(err)
+	}
+	repoTags := RepoTags(img.Uuid)
+	if !reflect.DeepEqual(repoTags, []string{"app:v1", "app:latest"}) {
+		t.Fatalf("unexpected repotags: %v", repoTags)
+	}
+
+	// the image with multiple repotags can't be removed by uuid.
+	if err := Delete(img.Uuid, false); err == nil {
+		t.Errorf("expected error when removing image by uuid")
+	}
+	// only the repotag is removed if it's not the last one.
+	if err := Delete("app:v1", false); err != nil {
+		t.Fatal(err)
+	}
+	if Exist("app:v1") || !Exist("app:latest") {
+		t.Errorf("unexpected images: %v", RepoTags(img.Uuid))
+	}
+
+	// the image is kept as untagged after the last repotag is removed.
+	if err := Untag("app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(Images) != 1 || Images[0].RepoTag != NoneRepoTag {
+		t.Fatalf("the image isn't untagged: %+v", Images)
+	}
+
+	// the untagged entry is renamed rather than duplicated.
+	if err := Tag(img.Uuid, "app:v2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(Images) != 1 || Images[0].RepoTag != "app:v2" {
+		t.Fatalf("the image isn't tagged: %+v", Images)
+	}
+
+	if err := Delete(img.Uuid, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(Images) != 0 {
+		t.Errorf("the image isn't removed: %+v", Images)
+	}
+}
@@ -0,0 +1,439 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/vishvananda/netlink"
+	"weike.sh/mydocker/util"
+)
+
+// Report is called by the checkers for each problem found with the
+// function to repair it, which is nil if it can't be repaired. the
+// problems sharing the same cause share the same idempotent repair.
+type Report func(object, problem string, repair func() error)
+
+// Check compares the ip allocations, veth links and iptables rules with
+// the endpoints of all the containers, of which the ones of the running
+// containers are in running, and reports the orphans and the drift. the
+// links and rules of the busy ones, i.e. being started or stopped, are
+// skipped, and the ips allocated but not used aren't reported unless
+// settled, since they maybe allocated for the containers being created.
+func Check(endpoints, running, busy []*Endpoint, settled bool, report Report) error {
+	if err := checkIPAM(endpoints, settled, report); err != nil {
+		return err
+	}
+	if err := checkLinks(running, busy, report); err != nil {
+		return err
+	}
+	return checkIPTables(running, busy, report)
+}
+
+func checkIPAM(endpoints []*Endpoint, settled bool, report Report) error {
+	if err := IPAllocator.Load(); err != nil {
+		return fmt.Errorf("failed to load IPAllocation info: %v", err)
+	}
+
+	subnets := map[string]bool{}
+	for _, nw := range Networks {
+		subnet := nw.IPNet.String()
+		subnets[subnet] = true
+
+		used := map[uint32]string{}
+		var ips []net.IP
+		for _, ep := range endpoints {
+			if ep.Network.Name != nw.Name || !nw.IPNet.Contains(ep.IPAddr) {
+				continue
+			}
+			ipInt := IP2Int(ep.IPAddr)
+			if other, ok := used[ipInt]; ok {
+				report("network "+nw.Name, fmt.Sprintf("the ip %s is used by both "+
+					"endpoints %s and %s", ep.IPAddr, other, ep.Uuid), nil)
+				continue
+			}
+			used[ipInt] = ep.Uuid
+			ips = append(ips, ep.IPAddr)
+		}
+
+		nw := nw
+		rebuild := func() error { return IPAllocator.rebuild(nw, ips) }
+
+		bitmap := (*IPAllocator.SubnetBitMap)[subnet]
+		ones, bits := nw.IPNet.Mask.Size()
+		size := 1 << uint8(bits-ones)
+		if len(bitmap) != size {
+			report("network "+nw.Name, fmt.Sprintf("the bitmap of subnet %s "+
+				"is missing or corrupted", subnet), rebuild)
+			continue
+		}
+
+		subnetIPInt := IP2Int(nw.IPNet.IP)
+		for index, bit := range bitmap {
+			_, inUse := used[subnetIPInt+uint32(index)]
+			ip := Int2IP(subnetIPInt + uint32(index))
+			switch {
+			case bit == '1' && !inUse && settled:
+				report("network "+nw.Name, fmt.Sprintf("the ip %s is allocated "+
+					"but not used by any container", ip), rebuild)
+			case bit != '1' && inUse:
+				report("network "+nw.Name, fmt.Sprintf("the ip %s is used by "+
+					"endpoint %s but not allocated", ip, used[IP2Int(ip)]), rebuild)
+			}
+		}
+
+		if int(nw.Counts) != len(ips) && (settled || int(nw.Counts) < len(ips)) {
+			report("network "+nw.Name, fmt.Sprintf("the counts is %d but %d ips "+
+				"are used", nw.Counts, len(ips)), rebuild)
+		}
+	}
+
+	for subnet := range *IPAllocator.SubnetBitMap {
+		if subnets[subnet] {
+			continue
+		}
+		subnet := subnet
+		report("subnet "+subnet, "the subnet is allocated but has no network",
+			func() error { return IPAllocator.removeSubnet(subnet) })
+	}
+
+	return nil
+}
+
+// rebuild rebuilds the bitmap of the subnet and the counts of nw
+// from the ips used by the endpoints of containers.
+func (ipam *IPAM) rebuild(nw *Network, ips []net.IP) error {
+	unlock, err := util.LockFile(ipam.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ipam.Load(); err != nil {
+		return fmt.Errorf("failed to load IPAllocation info: %v", err)
+	}
+	if err := nw.Load(); err != nil {
+		return err
+	}
+
+	ones, bits := nw.IPNet.Mask.Size()
+	size := 1 << uint8(bits-ones)
+	bitmap := []byte(strings.Repeat("0", size))
+	subnetIPInt := IP2Int(nw.IPNet.IP)
+	for _, ip := range ips {
+		// the network, gateway and broadcast are never allocated.
+		index := int(IP2Int(ip) - subnetIPInt)
+		if index > 1 && index < size-1 {
+			bitmap[index] = '1'
+		}
+	}
+
+	(*ipam.SubnetBitMap)[nw.IPNet.String()] = string(bitmap)
+	nw.Counts = uint32(strings.Count(string(bitmap), "1"))
+	if err := nw.Dump(); err != nil {
+		return err
+	}
+	return ipam.Dump()
+}
+
+func (ipam *IPAM) removeSubnet(subnet string) error {
+	unlock, err := util.LockFile(ipam.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := ipam.Load(); err != nil {
+		return fmt.Errorf("failed to load IPAllocation info: %v", err)
+	}
+	delete(*ipam.SubnetBitMap, subnet)
+	return ipam.Dump()
+}
+
+// checkLinks reports the veth links of the endpoints which aren't
+// connected to any running container, e.g. left by a host crash.
+func checkLinks(running, busy []*Endpoint, report Report) error {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %v", err)
+	}
+
+	existing := map[string]bool{}
+	for _, link := range links {
+		existing[link.Attrs().Name] = true
+	}
+
+	expected := map[string]bool{}
+	for _, ep := range busy {
+		expected[ep.Device.Name] = true
+	}
+	for _, ep := range running {
+		expected[ep.Device.Name] = true
+		if !existing[ep.Device.Name] {
+			report("link "+ep.Device.Name, fmt.Sprintf("the veth of endpoint %s "+
+				"is missing, please restart the container", ep.Uuid), nil)
+		}
+	}
+
+	for _, link := range links {
+		name := link.Attrs().Name
+		if link.Type() != "veth" || !strings.HasPrefix(name, "veth-") || expected[name] {
+			continue
+		}
+		link := link
+		report("link "+name, "the veth isn't connected to any running container",
+			func() error { return netlink.LinkDel(link) })
+	}
+
+	return nil
+}
+
+// iptRule is a rule listed by `iptables -S`, which is generated
+// from a template of bridgeIPTRules or portMapsIPTRules.
+type iptRule struct {
+	table string
+	// e.g. -A POSTROUTING -s 10.20.30.0/24 ! -o mydocker0 -j MASQUERADE
+	spec string
+	// e.g. masq, the key of the template.
+	kind string
+	args map[string]string
+}
+
+type iptTemplate struct {
+	kind   string
+	table  string
+	regexp *regexp.Regexp
+	names  []string
+}
+
+// e.g. -w 5 -t nat {action} POSTROUTING -s {subnet} ! -o {bridge} -j MASQUERADE
+var templateRegexp = regexp.MustCompile(`^-w \d+ -t (\S+) \{action\} (.*)$`)
+
+var placeholderRegexp = regexp.MustCompile(`\\\{(\w+)\\\}`)
+
+// iptTemplates converts the templates of rules to the regexps matching
+// the rules listed by `iptables -S`, which prints the addresses with
+// their masks, e.g. 127.0.0.1/32, and --set-mark as --set-xmark.
+func iptTemplates() []*iptTemplate {
+	var templates []*iptTemplate
+	for _, rules := range []map[string]string{bridgeIPTRules, portMapsIPTRules} {
+		for kind, rule := range rules {
+			match := templateRegexp.FindStringSubmatch(rule)
+			if match == nil {
+				continue
+			}
+
+			t := &iptTemplate{kind: kind, table: match[1]}
+			pattern := regexp.QuoteMeta("-A " + match[2])
+			pattern = strings.Replace(pattern, `127\.0\.0\.1`, `127\.0\.0\.1(?:/32)?`, -1)
+			pattern = strings.Replace(pattern, "--set-mark", "--set-x?mark", -1)
+			pattern = placeholderRegexp.ReplaceAllStringFunc(pattern, func(s string) string {
+				name := placeholderRegexp.FindStringSubmatch(s)[1]
+				t.names = append(t.names, name)
+				switch name {
+				case "inIP", "outIP":
+					return `([0-9.]+)(?:/32)?`
+				case "inPort", "outPort":
+					return `([0-9]+)`
+				case "mark":
+					return `(0x[0-9a-f]+)(?:/0xffffffff)?`
+				}
+				return `(\S+)`
+			})
+			t.regexp = regexp.MustCompile("^" + pattern + "$")
+			templates = append(templates, t)
+		}
+	}
+	return templates
+}
+
+func listIPTRules(templates []*iptTemplate) ([]*iptRule, error) {
+	var rules []*iptRule
+	for _, table := range []string{"nat", "mangle"} {
+		output, err := exec.Command("iptables", "-w", "5", "-t", table, "-S").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list iptables rules of table %s: %v", table, err)
+		}
+
+		for _, line := range strings.Split(string(output), "\n") {
+			for _, t := range templates {
+				if t.table != table {
+					continue
+				}
+				match := t.regexp.FindStringSubmatch(line)
+				if match == nil {
+					continue
+				}
+				rule := &iptRule{table: table, spec: line, kind: t.kind, args: map[string]string{}}
+				for i, name := range t.names {
+					rule.args[name] = match[i+1]
+				}
+				rules = append(rules, rule)
+				break
+			}
+		}
+	}
+	return rules, nil
+}
+
+// e.g. masq bridge=mydocker0 subnet=10.20.30.0/24
+func ruleKey(kind string, args map[string]string) string {
+	var pairs []string
+	for name, value := range args {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return kind + " " + strings.Join(pairs, " ")
+}
+
+func bridgeMark(bridge string) string {
+	return "0x" + util.Sha256Sum(bridge)[:8]
+}
+
+// checkIPTables reports the rules of networks and port maps which
+// are missing, and the rules generated by mydocker which are stale,
+// i.e. their networks are removed or their containers aren't running.
+func checkIPTables(running, busy []*Endpoint, report Report) error {
+	rules, err := listIPTRules(iptTemplates())
+	if err != nil {
+		return err
+	}
+	physNics, err := GetPhysicalNics()
+	if err != nil {
+		return fmt.Errorf("failed to get physical nics: %v", err)
+	}
+	physIPs, err := GetPhysicalIPs()
+	if err != nil {
+		return fmt.Errorf("failed to get physical ips: %v", err)
+	}
+
+	// the rules expected and the functions to restore them.
+	expected := map[string]func() error{}
+	var subnets []*net.IPNet
+	marks := map[string]bool{}
+	bridges := map[string]bool{}
+	for _, nw := range Networks {
+		if nw.Driver != Bridge {
+			continue
+		}
+		nw := nw
+		restore := func() error { return setBridgeIptablesRules(nw.Name, nw.IPNet) }
+		mark := bridgeMark(nw.Name)
+		subnets = append(subnets, nw.IPNet)
+		marks[mark], bridges[nw.Name] = true, true
+
+		expected[ruleKey("masq", map[string]string{"subnet": nw.IPNet.String(), "bridge": nw.Name})] = restore
+		expected[ruleKey("mark", map[string]string{"bridge": nw.Name, "mark": mark})] = restore
+		expected[ruleKey("drop", map[string]string{"bridge": nw.Name, "mark": mark})] = restore
+		for _, physNic := range physNics {
+			expected[ruleKey("phys", map[string]string{"physnic": physNic, "mark": mark})] = restore
+		}
+	}
+
+	for _, ep := range running {
+		inIP := ep.IPAddr.String()
+		for outPort, inPort := range ep.Ports {
+			outPort, inPort := outPort, inPort
+			restore := func() error { return setPortMap(outPort, inIP, inPort) }
+
+			args := map[string]string{"outPort": outPort, "inIP": inIP, "inPort": inPort}
+			expected[ruleKey("dnat", args)] = restore
+			for _, outIP := range append([]string{"127.0.0.1"}, physIPs...) {
+				args := map[string]string{"outIP": outIP, "outPort": outPort, "inIP": inIP, "inPort": inPort}
+				expected[ruleKey("host", args)] = restore
+			}
+			// the snat rule is set with the first physical ip.
+			if len(physIPs) == 0 {
+				report("endpoint "+ep.Uuid, fmt.Sprintf("the host has no physical ip "+
+					"for the snat rule of port %s", outPort), nil)
+				continue
+			}
+			args = map[string]string{"outIP": physIPs[0], "inIP": inIP, "inPort": inPort}
+			expected[ruleKey("snat", args)] = restore
+		}
+	}
+
+	// the rules of removed networks are recognized by their marks,
+	// which are generated from the names of bridges.
+	for _, rule := range rules {
+		if (rule.kind == "mark" || rule.kind == "drop") &&
+			rule.args["mark"] == bridgeMark(rule.args["bridge"]) {
+			marks[rule.args["mark"]], bridges[rule.args["bridge"]] = true, true
+		}
+	}
+	for _, rule := range rules {
+		if rule.kind == "masq" && bridges[rule.args["bridge"]] {
+			if _, subnet, err := net.ParseCIDR(rule.args["subnet"]); err == nil {
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+
+	// the port maps of the busy containers maybe being set or deleted.
+	busyIPs := map[string]bool{}
+	for _, ep := range busy {
+		busyIPs[ep.IPAddr.String()] = true
+	}
+
+	existing := map[string]bool{}
+	for _, rule := range rules {
+		key := ruleKey(rule.kind, rule.args)
+		existing[key] = true
+		if _, ok := expected[key]; ok || !ownedRule(rule, marks, bridges, subnets) {
+			continue
+		}
+		if busyIPs[rule.args["inIP"]] {
+			continue
+		}
+
+		rule := rule
+		report("iptables -t "+rule.table, fmt.Sprintf("the rule `%s` is stale", rule.spec),
+			func() error { return deleteIPTRule(rule) })
+	}
+
+	var missing []string
+	for key := range expected {
+		if !existing[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	for _, key := range missing {
+		report("iptables", fmt.Sprintf("the rule (%s) is missing", key), expected[key])
+	}
+
+	return nil
+}
+
+// ownedRule returns true if the rule is generated by mydocker, i.e.
+// the rule is of a bridge of mydocker, or its container's ip is in
+// the subnet of a network of mydocker.
+func ownedRule(rule *iptRule, marks, bridges map[string]bool, subnets []*net.IPNet) bool {
+	switch rule.kind {
+	case "masq":
+		return bridges[rule.args["bridge"]]
+	case "mark", "drop", "phys":
+		return marks[rule.args["mark"]]
+	}
+
+	ip := net.ParseIP(rule.args["inIP"])
+	for _, subnet := range subnets {
+		if ip != nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func deleteIPTRule(rule *iptRule) error {
+	args := append([]string{"-w", "5", "-t", rule.table, "-D"},
+		strings.Fields(strings.TrimPrefix(rule.spec, "-A "))...)
+	if output, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to delete iptables rule %s: %v: %s",
+			rule.spec, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestIPTTemplates(t *testing.T) {
+	templates := iptTemplates()
+	for line, expected := range map[string]string{
+		"-A POSTROUTING -s 10.20.30.0/24 ! -o mydocker0 -j MASQUERADE":                                                        "masq bridge=mydocker0 subnet=10.20.30.0/24",
+		"-A PREROUTING -i mydocker0 -j MARK --set-xmark 0x12151991/0xffffffff":                                                "mark bridge=mydocker0 mark=0x12151991",
+		"-A POSTROUTING -o eth0 -m mark --mark 0x12151991 -j ACCEPT":                                                          "phys mark=0x12151991 physnic=eth0",
+		"-A POSTROUTING ! -o mydocker0 -m mark --mark 0x12151991 -j DROP":                                                     "drop bridge=mydocker0 mark=0x12151991",
+		"-A PREROUTING ! -s 127.0.0.1/32 ! -d 127.0.0.1/32 -p tcp -m tcp --dport 8000 -j DNAT --to-destination 10.20.30.2:80": "dnat inIP=10.20.30.2 inPort=80 outPort=8000",
+		"-A OUTPUT -d 192.168.1.10/32 -p tcp -m tcp --dport 8000 -j DNAT --to-destination 10.20.30.2:80":                      "host inIP=10.20.30.2 inPort=80 outIP=192.168.1.10 outPort=8000",
+		"-A POSTROUTING -s 127.0.0.1/32 -d 10.20.30.2/32 -p tcp -m tcp --dport 80 -j SNAT --to-source 192.168.1.10":           "snat inIP=10.20.30.2 inPort=80 outIP=192.168.1.10",
+		"-A POSTROUTING -s 172.17.0.0/16 ! -o docker0 -j MASQUERADE":                                                          "masq bridge=docker0 subnet=172.17.0.0/16",
+		"-A DOCKER -i docker0 -j RETURN":                                                                                      "",
+	} {
+		var actual string
+		for _, tmpl := range templates {
+			if match := tmpl.regexp.FindStringSubmatch(line); match != nil {
+				args := map[string]string{}
+				for i, name := range tmpl.names {
+					args[name] = match[i+1]
+				}
+				actual = ruleKey(tmpl.kind, args)
+				break
+			}
+		}
+		if actual != expected {
+			t.Errorf("expected %q for rule %q, got %q", expected, line, actual)
+		}
+	}
+}
+
+func TestOwnedRule(t *testing.T) {
+	_, subnet, _ := net.ParseCIDR("10.20.30.0/24")
+	marks := map[string]bool{bridgeMark("mydocker0"): true}
+	bridges := map[string]bool{"mydocker0": true}
+
+	for _, c := range []struct {
+		rule  *iptRule
+		owned bool
+	}{
+		{&iptRule{kind: "masq", args: map[string]string{"bridge": "mydocker0"}}, true},
+		{&iptRule{kind: "masq", args: map[string]string{"bridge": "docker0"}}, false},
+		{&iptRule{kind: "phys", args: map[string]string{"mark": bridgeMark("mydocker0")}}, true},
+		{&iptRule{kind: "phys", args: map[string]string{"mark": "0xdeadbeef"}}, false},
+		{&iptRule{kind: "dnat", args: map[string]string{"inIP": "10.20.30.5"}}, true},
+		{&iptRule{kind: "dnat", args: map[string]string{"inIP": "172.17.0.2"}}, false},
+	} {
+		if owned := ownedRule(c.rule, marks, bridges, []*net.IPNet{subnet}); owned != c.owned {
+			t.Errorf("expected owned=%t for rule %+v", c.owned, c.rule)
+		}
+	}
+}
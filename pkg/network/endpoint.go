@@ -11,16 +11,23 @@ import (
 	"weike.sh/mydocker/util"
 )
 
+// Connect connects the container (pid) to the network of the endpoint,
+// the veth and port maps created are removed if any step failed, so
+// that the endpoint is either connected completely or not at all.
 func (ep *Endpoint) Connect(pid int) error {
 	if err := Drivers[ep.Network.Driver].Connect(ep); err != nil {
 		return fmt.Errorf("failed to init veth peers for container: %v", err)
 	}
 
 	if err := ep.addIPAddrAndRoute(pid); err != nil {
+		// removing the veth removes its peer in the container as well.
+		Drivers[ep.Network.Driver].DisConnect(ep)
 		return fmt.Errorf("failed to config ipaddr and route for container: %v", err)
 	}
 
 	if err := ep.handlePortMaps("create"); err != nil {
+		ep.handlePortMaps("delete")
+		Drivers[ep.Network.Driver].DisConnect(ep)
 		return fmt.Errorf("failed to config port maps for container: %v", err)
 	}
 
@@ -185,6 +192,21 @@ func (ep *Endpoint) handlePortMaps(action string) error {
 	return nil
 }
 
+// Stats returns the bytes received and sent by the container through
+// the endpoint, i.e. the bytes sent and received by the host veth.
+func (ep *Endpoint) Stats() (uint64, uint64, error) {
+	link, err := netlink.LinkByName(ep.Device.Name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find veth %s: %v", ep.Device.Name, err)
+	}
+
+	stats := link.Attrs().Statistics
+	if stats == nil {
+		return 0, 0, fmt.Errorf("no statistics of veth %s", ep.Device.Name)
+	}
+	return stats.TxBytes, stats.RxBytes, nil
+}
+
 func (ep *Endpoint) MarshalJSON() ([]byte, error) {
 	type epAlias Endpoint
 	return json.Marshal(&struct {
@@ -60,6 +60,10 @@ func Init() error {
 		}
 
 		for _, nwConfig := range nwConfigs {
+			// skip the temp files of configs being written.
+			if strings.HasPrefix(nwConfig.Name(), ".") {
+				continue
+			}
 			nwName := strings.TrimRight(nwConfig.Name(), ".json")
 			log.Debugf("found a network %s of driver %s", nwName, driverName)
 			nw := &Network{
@@ -82,28 +86,53 @@ func Init() error {
 	}
 
 	if _, ok := Networks[DefaultNetwork]; !ok {
-		log.Debugf("notes: the default network doesn't exist")
-		_, ipNet, _ := net.ParseCIDR(DefaultCIDR)
-		defaultNetwork := &Network{
-			Name:       DefaultNetwork,
-			Counts:     0,
-			Driver:     Bridge,
-			IPNet:      ipNet,
-			Gateway:    GetIPFromSubnetByIndex(ipNet, 1),
-			CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+		if err := createDefaultNetwork(); err != nil {
+			return err
 		}
+	}
+
+	jsonBytes, _ := json.MarshalIndent(Networks, "", "    ")
+	log.Debugf("found existed networks:\n%s", string(jsonBytes))
+
+	return nil
+}
 
-		log.Debugf("create the default network %s", defaultNetwork.Name)
-		if err := defaultNetwork.Create(); err != nil {
-			return fmt.Errorf("failed to create default network %s: %v",
+// createDefaultNetwork creates the default network if it isn't created
+// by another mydocker process, which is checked with the lock held.
+func createDefaultNetwork() error {
+	unlock, err := util.LockFile(IPAllocator.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	log.Debugf("notes: the default network doesn't exist")
+	_, ipNet, _ := net.ParseCIDR(DefaultCIDR)
+	defaultNetwork := &Network{
+		Name:       DefaultNetwork,
+		Counts:     0,
+		Driver:     Bridge,
+		IPNet:      ipNet,
+		Gateway:    GetIPFromSubnetByIndex(ipNet, 1),
+		CreateTime: time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	configFileName := path.Join(DriversDir, Bridge, DefaultNetwork+".json")
+	if info, err := os.Stat(configFileName); err == nil && info.Size() > 0 {
+		if err := defaultNetwork.Load(); err != nil {
+			return fmt.Errorf("failed to load default network %s: %v",
 				DefaultNetwork, err)
 		}
-
 		Networks[DefaultNetwork] = defaultNetwork
+		return Drivers[Bridge].Init(defaultNetwork)
 	}
 
-	jsonBytes, _ := json.MarshalIndent(Networks, "", "    ")
-	log.Debugf("found existed networks:\n%s", string(jsonBytes))
+	log.Debugf("create the default network %s", defaultNetwork.Name)
+	if err := defaultNetwork.Create(); err != nil {
+		return fmt.Errorf("failed to create default network %s: %v",
+			DefaultNetwork, err)
+	}
 
+	Networks[DefaultNetwork] = defaultNetwork
 	return nil
 }
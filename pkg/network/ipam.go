@@ -12,6 +12,12 @@ import (
 )
 
 func (ipam *IPAM) Init(nw *Network) error {
+	unlock, err := util.LockFile(ipam.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := ipam.Load(); err != nil {
 		return fmt.Errorf("failed to load IPAllocation info: %v", err)
 	}
@@ -36,10 +42,22 @@ func (ipam *IPAM) Init(nw *Network) error {
 	return ipam.Dump()
 }
 
+// Allocate allocates an unused ip address from the subnet of nw, the
+// allocator and nw are reloaded with the lock held, so the concurrent
+// mydocker processes never allocate the same ip address.
 func (ipam *IPAM) Allocate(nw *Network) (net.IP, error) {
+	unlock, err := util.LockFile(ipam.Allocator)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
 	if err := ipam.Load(); err != nil {
 		return nil, fmt.Errorf("failed to load IPAllocation info: %v", err)
 	}
+	if err := nw.Load(); err != nil {
+		return nil, err
+	}
 
 	if err := ipam.Init(nw); err != nil {
 		return nil, err
@@ -78,9 +96,18 @@ func (ipam *IPAM) Allocate(nw *Network) (net.IP, error) {
 }
 
 func (ipam *IPAM) Release(nw *Network, ip *net.IP) error {
+	unlock, err := util.LockFile(ipam.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if err := ipam.Load(); err != nil {
 		return fmt.Errorf("failed to load IPAllocation info: %v", err)
 	}
+	if err := nw.Load(); err != nil {
+		return err
+	}
 
 	if err := ipam.Init(nw); err != nil {
 		return err
@@ -130,9 +157,7 @@ func (ipam *IPAM) Dump() error {
 		return fmt.Errorf("failed to json-encode ipam: %v", err)
 	}
 
-	// WriteFile will create the file if it doesn't exist,
-	// otherwise WriteFile will truncate it before writing
-	if err := ioutil.WriteFile(ipam.Allocator, jsonBytes, 0644); err != nil {
+	if err := util.WriteFileAtomic(ipam.Allocator, jsonBytes, 0644); err != nil {
 		return fmt.Errorf("failed to write ipam config to file %s: %v",
 			ipam.Allocator, err)
 	}
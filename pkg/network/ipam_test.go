@@ -1,7 +1,13 @@
 package network
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -28,3 +34,73 @@ func TestIPAM_Release(t *testing.T) {
 	}
 
 }
+
+// the ip addresses are allocated by the child processes re-executing
+// this test, which print the allocated ip address and exit then.
+func TestIPAM_AllocateConcurrently(t *testing.T) {
+	_, ipnet, _ := net.ParseCIDR("10.40.0.0/24")
+	if dir := os.Getenv("MYDOCKER_TEST_IPAM_DIR"); dir != "" {
+		DriversDir = dir
+		IPAllocator = &IPAM{
+			Allocator:    path.Join(dir, "subnets.json"),
+			SubnetBitMap: &map[string]string{},
+		}
+		nw := &Network{
+			Name:    "test-concurrent",
+			Driver:  Bridge,
+			IPNet:   ipnet,
+			Gateway: GetIPFromSubnetByIndex(ipnet, 1),
+		}
+		ip, err := IPAllocator.Allocate(nw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Printf("allocated: %s\n", ip)
+		return
+	}
+
+	dir, err := ioutil.TempDir("", "mydocker-ipam-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const children = 8
+	outputs := make(chan string, children)
+	for i := 0; i < children; i++ {
+		go func() {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestIPAM_AllocateConcurrently$")
+			cmd.Env = append(os.Environ(), "MYDOCKER_TEST_IPAM_DIR="+dir)
+			output, err := cmd.CombinedOutput()
+			if err != nil {
+				output = append(output, err.Error()...)
+			}
+			outputs <- string(output)
+		}()
+	}
+
+	ips := map[string]bool{}
+	for i := 0; i < children; i++ {
+		output := <-outputs
+		idx := strings.Index(output, "allocated: ")
+		if idx < 0 {
+			t.Fatalf("failed to allocate ip: %s", output)
+		}
+		ip := strings.Fields(output[idx+len("allocated: "):])[0]
+		if ips[ip] {
+			t.Errorf("the ip %s is allocated twice", ip)
+		}
+		ips[ip] = true
+	}
+
+	nw := &Network{Name: "test-concurrent", Driver: Bridge}
+	oldDriversDir := DriversDir
+	DriversDir = dir
+	defer func() { DriversDir = oldDriversDir }()
+	if err := nw.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if nw.Counts != children {
+		t.Errorf("expected %d ips in network, got %d", children, nw.Counts)
+	}
+}
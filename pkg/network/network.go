@@ -77,7 +77,21 @@ func (nw *Network) ConfigFileName() (string, error) {
 	return configFileName, nil
 }
 
+// Create creates the network, the lock of IPAllocator is held while
+// creating and deleting networks, which protects their configs too.
 func (nw *Network) Create() error {
+	unlock, err := util.LockFile(IPAllocator.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the network maybe created by another mydocker meanwhile.
+	configFileName := path.Join(DriversDir, nw.Driver, nw.Name+".json")
+	if info, err := os.Stat(configFileName); err == nil && info.Size() > 0 {
+		return fmt.Errorf("the network name %s already exists", nw.Name)
+	}
+
 	if err := Drivers[nw.Driver].Create(nw); err != nil {
 		return err
 	}
@@ -88,6 +102,15 @@ func (nw *Network) Create() error {
 }
 
 func (nw *Network) Delete() error {
+	unlock, err := util.LockFile(IPAllocator.Allocator)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := nw.Load(); err != nil {
+		return err
+	}
 	if nw.Counts > 0 {
 		return fmt.Errorf("there still exist %d ips in subnet %s",
 			nw.Counts, nw.IPNet)
@@ -124,9 +147,7 @@ func (nw *Network) Dump() error {
 			nw.Name, err)
 	}
 
-	// WriteFile will create the file if it doesn't exist,
-	// otherwise WriteFile will truncate it before writing
-	if err := ioutil.WriteFile(configFileName, jsonBytes, 0644); err != nil {
+	if err := util.WriteFileAtomic(configFileName, jsonBytes, 0644); err != nil {
 		return fmt.Errorf("failed to write network config to file %s: %v",
 			configFileName, err)
 	}
@@ -9,6 +9,7 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <unistd.h>
+#include <sys/wait.h>
 
 __attribute__((constructor)) void enter_namespace(void) {
 	char *debug = getenv("debug_nsenter");
@@ -70,7 +71,17 @@ __attribute__((constructor)) void enter_namespace(void) {
 		close(fd);
 	}
 
-	exit(system(container_cmd));
+	// system() returns the wait status rather than the exit code, which
+	// is truncated to 0 by exit(), e.g. 256 for the exit code 1.
+	int status = system(container_cmd);
+	if (status == -1) {
+		printf("failed to execute the command %s\n", container_cmd);
+		exit(127);
+	}
+	if (WIFSIGNALED(status)) {
+		exit(128 + WTERMSIG(status));
+	}
+	exit(WEXITSTATUS(status));
 }
 */
 import "C"
@@ -0,0 +1,235 @@
+package util
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// TarPath writes the file or dir src into w as a tarball, whose top
+// entry is named name, e.g. "." to write only the contents of a dir.
+// the symlinks are written as they are, rather than followed, and
+// the contents of the dirs in excludes, e.g. mount points, are skipped.
+func TarPath(w io.Writer, src, name string, excludes ...string) error {
+	tw := tar.NewWriter(w)
+	// key is the inode of hard links, value is the first name.
+	inodes := map[uint64]string{}
+
+	err := filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(name, rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if stat, ok := fi.Sys().(*syscall.Stat_t); ok && fi.Mode().IsRegular() && stat.Nlink > 1 {
+			if first, ok := inodes[stat.Ino]; ok {
+				hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+			} else {
+				inodes[stat.Ino] = hdr.Name
+			}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if fi.IsDir() && file != src && Contains(excludes, file) {
+			return filepath.SkipDir
+		}
+
+		if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(tw, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %v", src, err)
+	}
+
+	return tw.Close()
+}
+
+// Untar extracts the tarball read from r into the dir, which is
+// resolved in the scope of root like SecureJoin, so that neither
+// the symlinks in root nor the entries can escape from root.
+func Untar(r io.Reader, root, dir string) error {
+	// the mtime of dirs must be set after extracting their children.
+	dirTimes := map[string]time.Time{}
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tarball: %v", err)
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+
+		parent, base := path.Split(path.Join(dir, name))
+		parentDir, err := SecureJoin(root, parent)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(parentDir, 0755); err != nil {
+			return fmt.Errorf("failed to mkdir %s: %v", parentDir, err)
+		}
+
+		// the hard links are relative to the top of the tarball.
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = path.Join(dir, path.Clean("/"+hdr.Linkname))
+		}
+
+		target := path.Join(parentDir, base)
+		if err := ExtractEntry(root, target, hdr, tr); err != nil {
+			return fmt.Errorf("failed to extract %s: %v", name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirTimes[target] = hdr.ModTime
+		}
+	}
+
+	for target, mtime := range dirTimes {
+		os.Chtimes(target, mtime, mtime)
+	}
+
+	return nil
+}
+
+// ExtractEntry creates the file target from the tar header and the
+// contents read from r, the hard links are resolved in root.
+func ExtractEntry(root, target string, hdr *tar.Header, r io.Reader) error {
+	// the existing file must be removed first, unless
+	// both the existing one and the new one are dirs.
+	if fi, err := os.Lstat(target); err == nil {
+		if !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, os.FileMode(mode)); err != nil {
+			return err
+		}
+
+	case tar.TypeReg:
+		file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(mode))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(file, r); err != nil {
+			file.Close()
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return err
+		}
+
+	case tar.TypeLink:
+		source, err := SecureJoin(root, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return err
+		}
+
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		switch hdr.Typeflag {
+		case tar.TypeChar:
+			mode |= syscall.S_IFCHR
+		case tar.TypeBlock:
+			mode |= syscall.S_IFBLK
+		case tar.TypeFifo:
+			mode |= syscall.S_IFIFO
+		}
+		dev := int((hdr.Devmajor << 8) | (hdr.Devminor & 0xff) | ((hdr.Devminor & 0xfff00) << 12))
+		if err := syscall.Mknod(target, mode, dev); err != nil {
+			return err
+		}
+
+	default:
+		// e.g. the pax global headers, just ignore them.
+		return nil
+	}
+
+	// the hard link shares the same inode with its source.
+	if hdr.Typeflag == tar.TypeLink {
+		return nil
+	}
+
+	// rootless users can't change the owner of files.
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+			return err
+		}
+	}
+
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+
+	// os.OpenFile and os.MkdirAll are affected by umask, and
+	// chown may clear the setuid bits, so chmod is necessary.
+	if err := syscall.Chmod(target, mode&07777); err != nil {
+		return err
+	}
+
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "SCHILY.xattr.") {
+			attr := strings.TrimPrefix(key, "SCHILY.xattr.")
+			// ignore the errors if the fs doesn't support xattrs.
+			syscall.Setxattr(target, attr, []byte(value), 0)
+		}
+	}
+
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
@@ -0,0 +1,187 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	contents string
+}
+
+func makeTarball(t *testing.T, entries []testEntry) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644}
+		if e.typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.contents))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestUntar(t *testing.T) {
+	base, err := ioutil.TempDir("", "mydocker-untar-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	// the secret outside root must be neither overwritten nor linked.
+	outside := path.Join(base, "outside")
+	secret := path.Join(outside, "secret")
+	if err := os.MkdirAll(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secret, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		entries []testEntry
+		// the files expected in root and their contents.
+		files map[string]string
+		err   string
+	}{
+		{
+			name: "parent dirs in names",
+			entries: []testEntry{
+				{name: "../escape", typeflag: tar.TypeReg, contents: "a"},
+				{name: "../../outside/secret", typeflag: tar.TypeReg, contents: "b"},
+			},
+			files: map[string]string{"escape": "a", "outside/secret": "b"},
+		},
+		{
+			name: "absolute symlink",
+			entries: []testEntry{
+				{name: "link", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "link/secret", typeflag: tar.TypeReg, contents: "evil"},
+			},
+			files: map[string]string{path.Join(outside, "secret"): "evil"},
+		},
+		{
+			name: "relative symlink",
+			entries: []testEntry{
+				{name: "up", typeflag: tar.TypeSymlink, linkname: "../../../.."},
+				{name: "up/outside/secret", typeflag: tar.TypeReg, contents: "evil"},
+			},
+			files: map[string]string{"outside/secret": "evil"},
+		},
+		{
+			name: "symlink replaced by file",
+			entries: []testEntry{
+				{name: "passwd", typeflag: tar.TypeSymlink, linkname: secret},
+				{name: "passwd", typeflag: tar.TypeReg, contents: "new"},
+			},
+			files: map[string]string{"passwd": "new"},
+		},
+		{
+			name: "symlink loop",
+			entries: []testEntry{
+				{name: "a", typeflag: tar.TypeSymlink, linkname: "b"},
+				{name: "b", typeflag: tar.TypeSymlink, linkname: "a"},
+				{name: "a/file", typeflag: tar.TypeReg, contents: "evil"},
+			},
+			err: "too many symlinks",
+		},
+		{
+			name:    "hardlink to parent dirs",
+			entries: []testEntry{{name: "hard", typeflag: tar.TypeLink, linkname: "../outside/secret"}},
+			err:     "no such file or directory",
+		},
+		{
+			name:    "hardlink to absolute path",
+			entries: []testEntry{{name: "hard", typeflag: tar.TypeLink, linkname: secret}},
+			err:     "no such file or directory",
+		},
+		{
+			name: "hardlink through symlink",
+			entries: []testEntry{
+				{name: "out", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "hard", typeflag: tar.TypeLink, linkname: "out/secret"},
+			},
+			err: "no such file or directory",
+		},
+		{
+			name: "hardlink in dir",
+			dir:  "/sub",
+			entries: []testEntry{
+				{name: "file", typeflag: tar.TypeReg, contents: "data"},
+				{name: "hard", typeflag: tar.TypeLink, linkname: "file"},
+			},
+			files: map[string]string{"sub/file": "data", "sub/hard": "data"},
+		},
+	}
+
+	for _, test := range tests {
+		root, err := ioutil.TempDir(base, "root-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := test.dir
+		if dir == "" {
+			dir = "/"
+		}
+
+		err = Untar(makeTarball(t, test.entries), root, dir)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q when extracting %s, got %v", test.err, test.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("failed to extract %s: %v", test.name, err)
+		}
+
+		for name, contents := range test.files {
+			data, err := ioutil.ReadFile(path.Join(root, name))
+			if err != nil || string(data) != contents {
+				t.Errorf("expected %s in root to be %q when extracting %s, got %q, %v",
+					name, contents, test.name, data, err)
+			}
+		}
+
+		var stat syscall.Stat_t
+		if err := syscall.Stat(secret, &stat); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(secret); string(data) != "secret" || stat.Nlink != 1 {
+			t.Errorf("the file outside root is modified when extracting %s: %q, %d links",
+				test.name, data, stat.Nlink)
+		}
+	}
+
+	// only the dir outside and the roots are in base.
+	infos, err := ioutil.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Name() != "outside" && !strings.HasPrefix(info.Name(), "root-") {
+			t.Errorf("unexpected file %s created outside root", info.Name())
+		}
+	}
+	if infos, _ := ioutil.ReadDir(outside); len(infos) != 1 {
+		t.Errorf("unexpected files created in the dir outside root: %d", len(infos))
+	}
+}
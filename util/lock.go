@@ -0,0 +1,171 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type fileLock struct {
+	file  *os.File
+	count int
+}
+
+var (
+	locksMutex sync.Mutex
+	locks      = map[string]*fileLock{}
+)
+
+// LockFile acquires an exclusive flock on `fileName.lock`, which blocks
+// until the other mydocker processes holding it release it, and returns
+// the function to release it. the lock is reentrant in this process, so
+// that the functions holding it can call each other, e.g. image.Delete
+// calls image.Untag, both of which modify repositories.json.
+func LockFile(fileName string) (func(), error) {
+	unlock, _, err := lockFile(fileName, true)
+	return unlock, err
+}
+
+// TryLockFile is like LockFile but doesn't block, it returns false if
+// the lock is held by another mydocker process.
+func TryLockFile(fileName string) (func(), bool, error) {
+	return lockFile(fileName, false)
+}
+
+func lockFile(fileName string, block bool) (func(), bool, error) {
+	lockName := fileName + ".lock"
+
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	if l, ok := locks[lockName]; ok {
+		l.count++
+		return func() { unlockFile(lockName) }, true, nil
+	}
+
+	if err := os.MkdirAll(path.Dir(lockName), 0755); err != nil {
+		return nil, false, fmt.Errorf("failed to mkdir %s: %v", path.Dir(lockName), err)
+	}
+	file, err := os.OpenFile(lockName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open lock file %s: %v", lockName, err)
+	}
+
+	how := syscall.LOCK_EX
+	if !block {
+		how |= syscall.LOCK_NB
+	}
+	log.Debugf("acquiring the lock %s", lockName)
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, false, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to lock %s: %v", lockName, err)
+	}
+
+	locks[lockName] = &fileLock{file: file, count: 1}
+	return func() { unlockFile(lockName) }, true, nil
+}
+
+// RLockFile acquires a shared flock on `fileName.lock`, which blocks
+// while another process holds it by LockFile, unlike LockFile, it isn't
+// reentrant, and the dir of fileName isn't created if it doesn't exist,
+// e.g. it's removed by the process holding the exclusive lock.
+func RLockFile(fileName string) (func(), error) {
+	lockName := fileName + ".lock"
+	file, err := os.OpenFile(lockName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", lockName, err)
+	}
+
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %v", lockName, err)
+	}
+
+	// closing the file releases the flock as well.
+	return func() { file.Close() }, nil
+}
+
+func unlockFile(lockName string) {
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	l, ok := locks[lockName]
+	if !ok {
+		return
+	}
+	if l.count--; l.count > 0 {
+		return
+	}
+
+	// closing the file releases the flock as well.
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+	delete(locks, lockName)
+	log.Debugf("released the lock %s", lockName)
+}
+
+// WriteFileAtomic writes data to a temporary file in the same dir, and
+// renames it to fileName after syncing it to disk, so that the readers
+// never see a truncated or partially written file, even if mydocker is
+// killed or the host crashes in the middle of writing.
+func WriteFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	dir, base := path.Split(fileName)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpName, fileName); err != nil {
+		return err
+	}
+
+	// sync the dir to persist the rename.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// the signals which can be named by users, e.g. in `kill -s`
+// or STOPSIGNAL, the realtime signals are named by numbers.
+var signals = map[string]syscall.Signal{
+	"ABRT":   syscall.SIGABRT,
+	"ALRM":   syscall.SIGALRM,
+	"BUS":    syscall.SIGBUS,
+	"CHLD":   syscall.SIGCHLD,
+	"CONT":   syscall.SIGCONT,
+	"FPE":    syscall.SIGFPE,
+	"HUP":    syscall.SIGHUP,
+	"ILL":    syscall.SIGILL,
+	"INT":    syscall.SIGINT,
+	"IO":     syscall.SIGIO,
+	"KILL":   syscall.SIGKILL,
+	"PIPE":   syscall.SIGPIPE,
+	"PROF":   syscall.SIGPROF,
+	"PWR":    syscall.SIGPWR,
+	"QUIT":   syscall.SIGQUIT,
+	"SEGV":   syscall.SIGSEGV,
+	"STOP":   syscall.SIGSTOP,
+	"SYS":    syscall.SIGSYS,
+	"TERM":   syscall.SIGTERM,
+	"TRAP":   syscall.SIGTRAP,
+	"TSTP":   syscall.SIGTSTP,
+	"TTIN":   syscall.SIGTTIN,
+	"TTOU":   syscall.SIGTTOU,
+	"URG":    syscall.SIGURG,
+	"USR1":   syscall.SIGUSR1,
+	"USR2":   syscall.SIGUSR2,
+	"VTALRM": syscall.SIGVTALRM,
+	"WINCH":  syscall.SIGWINCH,
+	"XCPU":   syscall.SIGXCPU,
+	"XFSZ":   syscall.SIGXFSZ,
+}
+
+// ParseSignal parses the signal named like SIGTERM, TERM or 15.
+func ParseSignal(name string) (syscall.Signal, error) {
+	if num, err := strconv.Atoi(name); err == nil {
+		// 64 is SIGRTMAX on linux.
+		if num <= 0 || num > 64 {
+			return 0, fmt.Errorf("invalid signal: %s", name)
+		}
+		return syscall.Signal(num), nil
+	}
+
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return 0, fmt.Errorf("invalid signal: %s", name)
+	}
+	return sig, nil
+}
+
+// ProcessIsAlive returns true if the process exists and isn't a
+// zombie, which has exited but hasn't been waited by its parent.
+func ProcessIsAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	// e.g. 1234 (sh) S 1 ..., the comm maybe contains spaces.
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	stat := string(contents)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	return len(fields) > 0 && fields[0] != "Z" && fields[0] != "X"
+}
+
+// ProcessStartTime returns the start time of the process in clock ticks
+// since boot, which tells the process from a later one reusing its pid.
+func ProcessStartTime(pid int) (uint64, error) {
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	// starttime is the 22nd field, i.e. the 20th one after comm.
+	stat := string(contents)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return 0, fmt.Errorf("invalid /proc/%d/stat: %s", pid, stat)
+	}
+	return strconv.ParseUint(fields[19], 10, 64)
+}
+
+// WaitExited blocks until the child process exits, but leaves it as a
+// zombie rather than reaping it, so that its pid isn't reused before
+// its exit is recorded by the caller, who reaps it with Wait() later.
+func WaitExited(pid int) error {
+	const pPid = 1 // P_PID of waitid(2)
+	// the siginfo_t filled by waitid(2) is 128 bytes.
+	var siginfo [16]uint64
+	for {
+		_, _, errno := syscall.Syscall6(syscall.SYS_WAITID, pPid, uintptr(pid),
+			uintptr(unsafe.Pointer(&siginfo[0])), syscall.WEXITED|syscall.WNOWAIT, 0, 0)
+		if errno != syscall.EINTR {
+			if errno != 0 {
+				return errno
+			}
+			return nil
+		}
+	}
+}
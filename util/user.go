@@ -0,0 +1,139 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type User struct {
+	Uid    int
+	Gid    int
+	Groups []int
+	Home   string
+}
+
+// LookupUser resolves the user spec in the scope of root, whose forms
+// are user, uid, user:group or uid:gid, the names are looked up in
+// /etc/passwd and /etc/group, while the ids needn't exist in them.
+func LookupUser(root, spec string) (*User, error) {
+	userName, groupName := spec, ""
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		userName, groupName = spec[:idx], spec[idx+1:]
+	}
+
+	passwd, err := readColonFile(root, "/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readColonFile(root, "/etc/group")
+	if err != nil {
+		return nil, err
+	}
+
+	// the user without an entry in /etc/passwd belongs to root group.
+	u := &User{Home: "/"}
+	found := false
+	uid, uidErr := strconv.Atoi(userName)
+	for _, fields := range passwd {
+		if len(fields) < 6 || (fields[0] != userName && fields[2] != userName) {
+			continue
+		}
+		u.Uid, _ = strconv.Atoi(fields[2])
+		u.Gid, _ = strconv.Atoi(fields[3])
+		u.Home = fields[5]
+		userName = fields[0]
+		found = true
+		break
+	}
+	if !found {
+		if uidErr != nil {
+			return nil, fmt.Errorf("no such user: %s", userName)
+		}
+		u.Uid = uid
+	}
+
+	if groupName != "" {
+		gid, err := strconv.Atoi(groupName)
+		found := err == nil
+		for _, fields := range groups {
+			if len(fields) >= 3 && fields[0] == groupName {
+				gid, _ = strconv.Atoi(fields[2])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no such group: %s", groupName)
+		}
+		u.Gid = gid
+	} else {
+		// the supplementary groups are only used without group spec.
+		for _, fields := range groups {
+			if len(fields) < 4 {
+				continue
+			}
+			if Contains(strings.Split(fields[3], ","), userName) {
+				gid, _ := strconv.Atoi(fields[2])
+				u.Groups = append(u.Groups, gid)
+			}
+		}
+	}
+
+	return u, nil
+}
+
+// readColonFile reads the file like /etc/passwd in the scope of root,
+// which is split into fields by colons, the missing file is empty.
+func readColonFile(root, name string) ([][]string, error) {
+	fileName, err := SecureJoin(root, name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", name, err)
+	}
+	defer file.Close()
+
+	var lines [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, strings.Split(line, ":"))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return lines, nil
+}
+
+// UserNames returns the names of the uids in /etc/passwd of root.
+func UserNames(root string) (map[int]string, error) {
+	passwd, err := readColonFile(root, "/etc/passwd")
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int]string{}
+	for _, fields := range passwd {
+		if len(fields) < 3 {
+			continue
+		}
+		if uid, err := strconv.Atoi(fields[2]); err == nil {
+			if _, ok := names[uid]; !ok {
+				names[uid] = fields[0]
+			}
+		}
+	}
+	return names, nil
+}
@@ -135,3 +135,74 @@ func Contains(items []string, ele string) bool {
 	}
 	return false
 }
+
+// SecureJoin joins unsafePath onto root like path.Join, but resolves
+// every symlink it meets as if root were "/", so the result can never
+// escape from root, e.g. SecureJoin("/rootfs", "../etc") returns
+// "/rootfs/etc", and a symlink /rootfs/lib -> /usr/lib is followed to
+// "/rootfs/usr/lib" instead of the host's /usr/lib.
+func SecureJoin(root, unsafePath string) (string, error) {
+	var resolved string
+	remaining := path.Clean("/" + unsafePath)
+	for linksWalked := 0; remaining != "/" && remaining != ""; {
+		remaining = strings.TrimPrefix(remaining, "/")
+		var part string
+		if i := strings.Index(remaining, "/"); i >= 0 {
+			part, remaining = remaining[:i], remaining[i:]
+		} else {
+			part, remaining = remaining, ""
+		}
+
+		next := path.Join("/", resolved, part)
+		fi, err := os.Lstat(path.Join(root, next))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			// non-existent or non-symlink components are kept as is.
+			resolved = next
+			continue
+		}
+
+		linksWalked++
+		if linksWalked > 255 {
+			return "", fmt.Errorf("too many symlinks in %s", unsafePath)
+		}
+
+		dest, err := os.Readlink(path.Join(root, next))
+		if err != nil {
+			return "", err
+		}
+		if !path.IsAbs(dest) {
+			dest = path.Join("/", resolved, dest)
+		}
+		remaining = path.Clean(dest + "/" + remaining)
+		resolved = ""
+	}
+
+	return path.Join(root, resolved), nil
+}
+
+// HumanDuration returns a human-readable approximation of the
+// duration, e.g. "5 minutes" or "About an hour", like docker does.
+func HumanDuration(d time.Duration) string {
+	if seconds := int(d.Seconds()); seconds < 1 {
+		return "Less than a second"
+	} else if seconds == 1 {
+		return "1 second"
+	} else if seconds < 60 {
+		return fmt.Sprintf("%d seconds", seconds)
+	} else if minutes := int(d.Minutes()); minutes == 1 {
+		return "About a minute"
+	} else if minutes < 60 {
+		return fmt.Sprintf("%d minutes", minutes)
+	} else if hours := int(d.Hours() + 0.5); hours == 1 {
+		return "About an hour"
+	} else if hours < 48 {
+		return fmt.Sprintf("%d hours", hours)
+	} else if hours < 24*7*2 {
+		return fmt.Sprintf("%d days", hours/24)
+	} else if hours < 24*30*2 {
+		return fmt.Sprintf("%d weeks", hours/24/7)
+	} else if hours < 24*365*2 {
+		return fmt.Sprintf("%d months", hours/24/30)
+	}
+	return fmt.Sprintf("%d years", int(d.Hours())/24/365)
+}
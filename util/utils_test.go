@@ -0,0 +1,82 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestSecureJoin(t *testing.T) {
+	root, err := ioutil.TempDir("", "mydocker-root-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	if err := os.MkdirAll(path.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(path.Join(root, "usr", "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]string{
+		"abs":       "/etc",
+		"rel":       "../../../etc",
+		"lib":       "usr/lib",
+		"usr/up":    "../../..",
+		"host-root": "/",
+		"loop1":     "loop2",
+		"loop2":     "loop1",
+		"self":      "self",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, path.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		unsafePath string
+		expected   string
+		err        string
+	}{
+		{unsafePath: "", expected: ""},
+		{unsafePath: "/", expected: ""},
+		{unsafePath: "etc/passwd", expected: "etc/passwd"},
+		{unsafePath: "../../etc/passwd", expected: "etc/passwd"},
+		{unsafePath: "/../etc/../../etc", expected: "etc"},
+		// the non-existent components are kept as they are.
+		{unsafePath: "missing/file", expected: "missing/file"},
+		// the absolute links are resolved as if root were "/".
+		{unsafePath: "abs/passwd", expected: "etc/passwd"},
+		{unsafePath: "host-root/etc/passwd", expected: "etc/passwd"},
+		// the relative links can't climb out of root either.
+		{unsafePath: "rel/passwd", expected: "etc/passwd"},
+		{unsafePath: "usr/up/etc", expected: "etc"},
+		{unsafePath: "lib/libc.so", expected: "usr/lib/libc.so"},
+		{unsafePath: "lib/../../etc", expected: "etc"},
+		{unsafePath: "loop1", err: "too many symlinks"},
+		{unsafePath: "loop1/file", err: "too many symlinks"},
+		{unsafePath: "self", err: "too many symlinks"},
+	}
+
+	for _, test := range tests {
+		result, err := SecureJoin(root, test.unsafePath)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("expected error %q when joining %q, got %q, %v",
+					test.err, test.unsafePath, result, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("failed to join %q: %v", test.unsafePath, err)
+			continue
+		}
+		if expected := path.Join(root, test.expected); result != expected {
+			t.Errorf("expected %q when joining %q, got %q", expected, test.unsafePath, result)
+		}
+	}
+}